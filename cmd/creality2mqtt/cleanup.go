@@ -51,7 +51,7 @@ Use this to start fresh or remove orphaned entities.`,
 		log.Info("Connected to MQTT broker")
 
 		// List of all entity types and their possible unique IDs
-		components := []string{"sensor", "binary_sensor", "switch", "camera"}
+		components := []string{"sensor", "binary_sensor", "switch", "number", "fan", "button", "camera"}
 
 		// All possible entity unique IDs (based on current and past implementations)
 		entityIDs := []string{
@@ -64,7 +64,7 @@ Use this to start fresh or remove orphaned entities.`,
 			// Status sensors
 			"printer_status",
 
-			// Fan sensors
+			// Fans (formerly fan speed sensors)
 			"model_fan_pct",
 			"auxiliary_fan_pct",
 			"case_fan_pct",
@@ -86,6 +86,19 @@ Use this to start fresh or remove orphaned entities.`,
 			// Switches
 			"light",
 
+			// Buttons
+			"pause_print",
+			"resume_print",
+			"cancel_print",
+
+			// Numbers
+			"nozzle_temp_setpoint",
+			"bed_temp_setpoint",
+			"cur_feedrate_pct",
+			"cur_flowrate_pct",
+			"pressure_advance",
+			"velocity_limits",
+
 			// Old/deprecated entities
 			"printer_online",
 			"printer_connected",
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// appConfig is the resolved configuration (flags > env > file > defaults)
+var appConfig = config.Default()
+
+// loadConfig resolves the configuration before any subcommand runs and
+// syncs the resolved values back into the flag variables.
+func loadConfig(cmd *cobra.Command, args []string) error {
+	cfg, err := resolveConfig(configPath, os.LookupEnv, cmd.Flags())
+	if err != nil {
+		// Configuration errors are not usage errors
+		cmd.SilenceUsage = true
+		return err
+	}
+	appConfig = cfg
+
+	logLevel = cfg.LogLevel
+	discoveryPrefix = cfg.DiscoveryPrefix
+	httpAddr = cfg.HTTPAddr
+	apiToken = cfg.APIToken
+	broker = cfg.MQTT.Broker
+	clientID = cfg.MQTT.ClientID
+	username = cfg.MQTT.Username
+	password = cfg.MQTT.Password
+	baseTopic = cfg.MQTT.BaseTopic
+	mqttMinInterval = time.Duration(cfg.MQTT.MinInterval)
+	wsURL = cfg.WSURL
+	deviceName = cfg.DeviceName
+	return nil
+}
+
+// resolveConfig loads the config file, then overlays env vars and explicitly set flags
+func resolveConfig(path string, lookup config.LookupFunc, flags *pflag.FlagSet) (config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.ApplyEnv(lookup); err != nil {
+		return cfg, err
+	}
+	if err := applyFlags(&cfg, flags); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// applyFlags overlays flags that were explicitly set on the command line
+func applyFlags(cfg *config.Config, flags *pflag.FlagSet) error {
+	strFlags := []struct {
+		name string
+		dst  *string
+	}{
+		{"log-level", &cfg.LogLevel},
+		{"discovery-prefix", &cfg.DiscoveryPrefix},
+		{"http-addr", &cfg.HTTPAddr},
+		{"api-token", &cfg.APIToken},
+		{"mqtt-broker", &cfg.MQTT.Broker},
+		{"mqtt-client-id", &cfg.MQTT.ClientID},
+		{"mqtt-username", &cfg.MQTT.Username},
+		{"mqtt-password", &cfg.MQTT.Password},
+		{"mqtt-base-topic", &cfg.MQTT.BaseTopic},
+		{"ws-url", &cfg.WSURL},
+		{"device-name", &cfg.DeviceName},
+	}
+	for _, f := range strFlags {
+		if !flags.Changed(f.name) {
+			continue
+		}
+		v, err := flags.GetString(f.name)
+		if err != nil {
+			return err
+		}
+		*f.dst = v
+	}
+
+	if flags.Changed("mqtt-min-interval") {
+		d, err := flags.GetDuration("mqtt-min-interval")
+		if err != nil {
+			return err
+		}
+		cfg.MQTT.MinInterval = config.Duration(d)
+	}
+
+	if flags.Changed("mqtt-change-only") {
+		changeOnly, err := flags.GetBool("mqtt-change-only")
+		if err != nil {
+			return err
+		}
+		cfg.MQTT.ChangeOnly = changeOnly
+	}
+
+	if flags.Changed("mqtt-refresh-interval") {
+		d, err := flags.GetDuration("mqtt-refresh-interval")
+		if err != nil {
+			return err
+		}
+		cfg.MQTT.RefreshInterval = config.Duration(d)
+	}
+
+	if flags.Changed("mqtt-deadband") {
+		specs, err := flags.GetStringArray("mqtt-deadband")
+		if err != nil {
+			return err
+		}
+		bands, err := config.ParseDeadbands(specs)
+		if err != nil {
+			return config.ValidationError{{Field: "--mqtt-deadband", Message: err.Error()}}
+		}
+		cfg.MQTT.Deadbands = bands
+	}
+
+	if flags.Changed("allow-set-param") {
+		specs, err := flags.GetStringArray("allow-set-param")
+		if err != nil {
+			return err
+		}
+		rules, err := config.ParseParamRules(specs)
+		if err != nil {
+			return config.ValidationError{{Field: "--allow-set-param", Message: err.Error()}}
+		}
+		cfg.Commands.SetAllowlist = rules
+	}
+
+	if flags.Changed("read-only") {
+		readOnly, err := flags.GetBool("read-only")
+		if err != nil {
+			return err
+		}
+		cfg.Commands.ReadOnly = readOnly
+	}
+
+	if flags.Changed("deny-gcode") {
+		denylist, err := flags.GetStringArray("deny-gcode")
+		if err != nil {
+			return err
+		}
+		cfg.Commands.GcodeDenylist = denylist
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  *float64
+	}{
+		{"max-nozzle-temp", &cfg.Commands.MaxNozzleTemp},
+		{"max-bed-temp", &cfg.Commands.MaxBedTemp},
+	} {
+		if !flags.Changed(f.name) {
+			continue
+		}
+		v, err := flags.GetFloat64(f.name)
+		if err != nil {
+			return err
+		}
+		*f.dst = v
+	}
+
+	if flags.Changed("clamp-temps") {
+		clamp, err := flags.GetBool("clamp-temps")
+		if err != nil {
+			return err
+		}
+		cfg.Commands.ClampTemps = clamp
+	}
+
+	if flags.Changed("command-min-interval") {
+		d, err := flags.GetDuration("command-min-interval")
+		if err != nil {
+			return err
+		}
+		cfg.Commands.MinInterval = config.Duration(d)
+	}
+
+	if flags.Changed("command-queue-ttl") {
+		d, err := flags.GetDuration("command-queue-ttl")
+		if err != nil {
+			return err
+		}
+		cfg.Commands.QueueTTL = config.Duration(d)
+	}
+
+	// A single printer URL replaces a printers list from env/file, and vice versa
+	wsChanged, printersChanged := flags.Changed("ws-url"), flags.Changed("printer")
+	if wsChanged && !printersChanged {
+		cfg.Printers = nil
+	}
+	if printersChanged {
+		if !wsChanged {
+			cfg.WSURL = ""
+		}
+		specs, err := flags.GetStringArray("printer")
+		if err != nil {
+			return err
+		}
+		printers, err := config.ParsePrinterSpecs(specs)
+		if err != nil {
+			return config.ValidationError{{Field: "--printer", Message: err.Error()}}
+		}
+		cfg.Printers = printers
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("mqtt-broker", "", "")
+	fs.String("mqtt-base-topic", "", "")
+	fs.String("ws-url", "", "")
+	fs.StringArray("printer", nil, "")
+	fs.Duration("mqtt-min-interval", 0, "")
+	fs.Bool("mqtt-change-only", false, "")
+	fs.Duration("mqtt-refresh-interval", 0, "")
+	fs.StringArray("mqtt-deadband", nil, "")
+	fs.StringArray("allow-set-param", nil, "")
+	fs.StringArray("deny-gcode", nil, "")
+	fs.Bool("read-only", false, "")
+	fs.Float64("max-nozzle-temp", 0, "")
+	fs.Float64("max-bed-temp", 0, "")
+	fs.Bool("clamp-temps", false, "")
+	fs.Duration("command-min-interval", 0, "")
+	fs.Duration("command-queue-ttl", 0, "")
+	return fs
+}
+
+func envMap(m map[string]string) config.LookupFunc {
+	return func(key string) (string, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+func TestResolveConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+mqtt:
+  broker: tcp://file:1883
+  base_topic: file/topic
+  min_interval: 5s
+printers:
+  - name: k1a
+    ws_url: ws://10.0.0.5:9999/
+`), 0o600))
+
+	t.Run("file only", func(t *testing.T) {
+		cfg, err := resolveConfig(path, envMap(nil), testFlagSet())
+		require.NoError(t, err)
+		assert.Equal(t, "tcp://file:1883", cfg.MQTT.Broker)
+		assert.Equal(t, "file/topic", cfg.MQTT.BaseTopic)
+		assert.Equal(t, config.Duration(5*time.Second), cfg.MQTT.MinInterval)
+		require.Len(t, cfg.Printers, 1)
+	})
+
+	t.Run("env beats file", func(t *testing.T) {
+		cfg, err := resolveConfig(path, envMap(map[string]string{
+			"CREALITY_MQTT_BROKER":       "tcp://env:1883",
+			"CREALITY_MQTT_MIN_INTERVAL": "1s",
+		}), testFlagSet())
+		require.NoError(t, err)
+		assert.Equal(t, "tcp://env:1883", cfg.MQTT.Broker)
+		assert.Equal(t, "file/topic", cfg.MQTT.BaseTopic)
+		assert.Equal(t, config.Duration(time.Second), cfg.MQTT.MinInterval)
+	})
+
+	t.Run("flags beat env", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("mqtt-broker", "tcp://flag:1883"))
+		require.NoError(t, fs.Set("mqtt-min-interval", "2s"))
+		cfg, err := resolveConfig(path, envMap(map[string]string{
+			"CREALITY_MQTT_BROKER":       "tcp://env:1883",
+			"CREALITY_MQTT_MIN_INTERVAL": "1s",
+		}), fs)
+		require.NoError(t, err)
+		assert.Equal(t, "tcp://flag:1883", cfg.MQTT.Broker)
+		assert.Equal(t, config.Duration(2*time.Second), cfg.MQTT.MinInterval)
+	})
+
+	t.Run("ws-url flag replaces printers from file", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("ws-url", "ws://10.0.0.9:9999/"))
+		cfg, err := resolveConfig(path, envMap(nil), fs)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Printers)
+		assert.Equal(t, "ws://10.0.0.9:9999/", cfg.WSURL)
+	})
+
+	t.Run("printer flags replace printers from file", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("printer", "a=ws://10.0.0.7:9999/"))
+		require.NoError(t, fs.Set("printer", "b=ws://10.0.0.8:9999/"))
+		cfg, err := resolveConfig(path, envMap(nil), fs)
+		require.NoError(t, err)
+		require.Len(t, cfg.Printers, 2)
+		assert.Equal(t, "a", cfg.Printers[0].Name)
+	})
+
+	t.Run("set allowlist flag", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("allow-set-param", "lightSw=0:1"))
+		cfg, err := resolveConfig(path, envMap(map[string]string{"CREALITY_SET_ALLOWLIST": "fanCase"}), fs)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"lightSw"}, slices.Collect(maps.Keys(cfg.Commands.SetAllowlist)))
+
+		fs = testFlagSet()
+		require.NoError(t, fs.Set("allow-set-param", "lightSw=1"))
+		_, err = resolveConfig(path, envMap(nil), fs)
+		assert.ErrorContains(t, err, "--allow-set-param")
+	})
+
+	t.Run("read-only flag", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("read-only", "true"))
+		cfg, err := resolveConfig(path, envMap(map[string]string{"CREALITY_READ_ONLY": "false"}), fs)
+		require.NoError(t, err)
+		assert.True(t, cfg.Commands.ReadOnly)
+	})
+
+	t.Run("gcode denylist flag", func(t *testing.T) {
+		cfg, err := resolveConfig(path, envMap(map[string]string{"CREALITY_GCODE_DENYLIST": "M84, G28"}), testFlagSet())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"M84", "G28"}, cfg.Commands.GcodeDenylist)
+
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("deny-gcode", "M112"))
+		cfg, err = resolveConfig(path, envMap(map[string]string{"CREALITY_GCODE_DENYLIST": "M84"}), fs)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"M112"}, cfg.Commands.GcodeDenylist)
+
+		fs = testFlagSet()
+		require.NoError(t, fs.Set("deny-gcode", "M84 X"))
+		_, err = resolveConfig(path, envMap(nil), fs)
+		assert.ErrorContains(t, err, "commands.gcode_denylist[0]")
+	})
+
+	t.Run("command policy flags", func(t *testing.T) {
+		env := envMap(map[string]string{
+			"CREALITY_MAX_NOZZLE_TEMP":      "250",
+			"CREALITY_MAX_BED_TEMP":         "90",
+			"CREALITY_CLAMP_TEMPS":          "false",
+			"CREALITY_COMMAND_MIN_INTERVAL": "5s",
+		})
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("max-nozzle-temp", "240"))
+		require.NoError(t, fs.Set("clamp-temps", "true"))
+		require.NoError(t, fs.Set("command-min-interval", "2s"))
+		cfg, err := resolveConfig(path, env, fs)
+		require.NoError(t, err)
+		assert.Equal(t, 240.0, cfg.Commands.MaxNozzleTemp)
+		assert.Equal(t, 90.0, cfg.Commands.MaxBedTemp, "env applies without the flag")
+		assert.True(t, cfg.Commands.ClampTemps)
+		assert.Equal(t, config.Duration(2*time.Second), cfg.Commands.MinInterval)
+
+		fs = testFlagSet()
+		require.NoError(t, fs.Set("max-bed-temp", "-1"))
+		_, err = resolveConfig(path, envMap(nil), fs)
+		assert.ErrorContains(t, err, "commands.max_bed_temp")
+	})
+
+	t.Run("command queue TTL flag", func(t *testing.T) {
+		cfg, err := resolveConfig(path, envMap(map[string]string{"CREALITY_COMMAND_QUEUE_TTL": "1m"}), testFlagSet())
+		require.NoError(t, err)
+		assert.Equal(t, config.Duration(time.Minute), cfg.Commands.QueueTTL)
+
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("command-queue-ttl", "0"))
+		cfg, err = resolveConfig(path, envMap(map[string]string{"CREALITY_COMMAND_QUEUE_TTL": "1m"}), fs)
+		require.NoError(t, err)
+		assert.Zero(t, cfg.Commands.QueueTTL)
+	})
+
+	t.Run("change-only flags", func(t *testing.T) {
+		fs := testFlagSet()
+		require.NoError(t, fs.Set("mqtt-change-only", "true"))
+		require.NoError(t, fs.Set("mqtt-refresh-interval", "1m"))
+		require.NoError(t, fs.Set("mqtt-deadband", "temperature/+/current=0.5"))
+		cfg, err := resolveConfig(path, envMap(map[string]string{"CREALITY_MQTT_DEADBANDS": "job/progress=1"}), fs)
+		require.NoError(t, err)
+		assert.True(t, cfg.MQTT.ChangeOnly)
+		assert.Equal(t, config.Duration(time.Minute), cfg.MQTT.RefreshInterval)
+		assert.Equal(t, []config.Deadband{{Topic: "temperature/+/current", Delta: 0.5}}, cfg.MQTT.Deadbands)
+
+		fs = testFlagSet()
+		require.NoError(t, fs.Set("mqtt-deadband", "job/progress"))
+		_, err = resolveConfig(path, envMap(nil), fs)
+		assert.ErrorContains(t, err, "--mqtt-deadband")
+	})
+
+	t.Run("invalid env reports the field", func(t *testing.T) {
+		_, err := resolveConfig(path, envMap(map[string]string{
+			"CREALITY_MQTT_MIN_INTERVAL": "soon",
+		}), testFlagSet())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "CREALITY_MQTT_MIN_INTERVAL")
+	})
+}
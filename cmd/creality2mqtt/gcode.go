@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/state"
+	"github.com/davidcollom/creality2mqtt/internal/wsclient"
+	"github.com/spf13/cobra"
+)
+
+var gcodeCmd = &cobra.Command{
+	Use:   "gcode [G-code...]",
+	Short: "Send G-code to a printer",
+	Long: `Connects to the printer's WebSocket (--ws-url) and sends G-code, one command per argument.
+Without arguments, G-code lines are read from stdin (an interactive console in a terminal).
+Commands in the G-code denylist (commands.gcode_denylist, --deny-gcode) are refused, and heater targets
+are held to the same limits as on the MQTT topics (--max-nozzle-temp, --max-bed-temp, --clamp-temps).`,
+	Example: `  creality2mqtt gcode --ws-url ws://192.168.1.50:9999/ G28 M84
+  creality2mqtt gcode --ws-url ws://192.168.1.50:9999/ --frames`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if wsURL == "" {
+			log.Error("--ws-url is required")
+			return fmt.Errorf("ws-url is required")
+		}
+		showFrames, _ := cmd.Flags().GetBool("frames")
+		wait, _ := cmd.Flags().GetDuration("wait")
+
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			log.Warn("Invalid log level, using info", "level", logLevel)
+			level = log.InfoLevel
+		}
+		log.SetLevel(level)
+
+		out := cmd.OutOrStdout()
+		var outMu sync.Mutex
+		// The printer reports its maximum temperatures, which the heater limits follow
+		printer := state.New()
+		firstFrame := make(chan struct{})
+		var firstOnce sync.Once
+		ws := wsclient.New(wsURL, func(data []byte) {
+			var frame map[string]any
+			if err := json.Unmarshal(data, &frame); err == nil {
+				printer.Merge(frame)
+				firstOnce.Do(func() { close(firstFrame) })
+			}
+			if showFrames {
+				outMu.Lock()
+				defer outMu.Unlock()
+				_, _ = fmt.Fprintf(out, "< %s\n", data)
+			}
+		})
+
+		ctx, cancel := context.WithCancel(cmd.Context())
+		defer cancel()
+		if err := connectPrinter(ctx, ws, 10*time.Second); err != nil {
+			return err
+		}
+		select {
+		case <-firstFrame:
+		case <-time.After(firstFrameTimeout):
+			log.Warn("No frame from the printer, using the default heater limits", "timeout", firstFrameTimeout)
+		}
+
+		in := io.Reader(strings.NewReader(strings.Join(args, "\n")))
+		interactive := false
+		if len(args) == 0 {
+			in = cmd.InOrStdin()
+			if f, ok := in.(*os.File); ok {
+				if st, err := f.Stat(); err == nil && st.Mode()&os.ModeCharDevice != 0 {
+					interactive = true
+				}
+			}
+		}
+
+		failed := sendGcode(in, out, &outMu, appConfig.Commands, printer, ws.SendMessage, interactive)
+		if showFrames && !interactive && wait > 0 {
+			// Give the printer time to report the effect of the last command
+			time.Sleep(wait)
+		}
+		if failed > 0 && !interactive {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d G-code command(s) failed", failed)
+		}
+		return nil
+	},
+}
+
+// firstFrameTimeout bounds the wait for the printer's first frame, which reports its maximum temperatures
+const firstFrameTimeout = 2 * time.Second
+
+// connectPrinter runs the WebSocket client in the background and waits for its first connection
+func connectPrinter(ctx context.Context, ws *wsclient.Client, timeout time.Duration) error {
+	connected := make(chan struct{})
+	var once sync.Once
+	ws.SetConnectHandler(func() { once.Do(func() { close(connected) }) })
+
+	errCh := make(chan error, 1)
+	go func() {
+		if err := ws.Run(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-connected:
+		return nil
+	case err := <-errCh:
+		return fmt.Errorf("websocket error: %w", err)
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out connecting to %s", wsURL)
+	}
+}
+
+// sendGcode sends every G-code line read from in and reports each outcome on out.
+// Heater targets are held to the limits of cfg and the maximum temperatures
+// the printer reported. It returns the number of lines that were refused or
+// could not be sent.
+func sendGcode(in io.Reader, out io.Writer, outMu *sync.Mutex, cfg config.CommandsConfig, printer *state.PrinterState, send func([]byte) error, interactive bool) int {
+	report := func(format string, args ...any) {
+		outMu.Lock()
+		defer outMu.Unlock()
+		_, _ = fmt.Fprintf(out, format, args...)
+	}
+
+	failed := 0
+	scanner := bufio.NewScanner(in)
+	for {
+		if interactive {
+			report("gcode> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := scanner.Text()
+		if len(bridge.SplitGcode(line)) == 0 {
+			continue
+		}
+
+		maxNozzle, _ := printer.Float("maxNozzleTemp")
+		maxBed, _ := printer.Float("maxBedTemp")
+		nozzle, bed := bridge.TempLimits(cfg, maxNozzle, maxBed)
+		limited, err := bridge.LimitHeaterGcode(line, nozzle, bed, cfg.ClampTemps)
+		var msg []byte
+		if err == nil {
+			msg, err = bridge.GcodeMessage(cfg, limited)
+		}
+		if err == nil {
+			err = send(msg)
+		}
+		if err != nil {
+			failed++
+			report("error: %s: %v\n", strings.TrimSpace(line), err)
+			continue
+		}
+		if limited != line {
+			report("ok: %s (clamped from %s)\n", strings.TrimSpace(limited), strings.TrimSpace(line))
+			continue
+		}
+		report("ok: %s\n", strings.TrimSpace(line))
+	}
+	return failed
+}
+
+func init() {
+	gcodeCmd.Flags().Bool("frames", false, "Print the frames received from the printer")
+	gcodeCmd.Flags().Duration("wait", 2*time.Second, "How long to keep printing frames after the last command (with --frames and arguments or piped input)")
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/state"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendGcode(t *testing.T) {
+	cfg := config.CommandsConfig{GcodeDenylist: []string{"M502"}}
+
+	tests := []struct {
+		name       string
+		input      string
+		sendErr    error
+		wantSent   []string
+		wantOutput string
+		wantFailed int
+	}{
+		{
+			name:       "lines",
+			input:      "G28\n\n; comment\nM84 ; motors off\n",
+			wantSent:   []string{`{"method":"set","params":{"gcodeCmd":"G28"}}`, `{"method":"set","params":{"gcodeCmd":"M84"}}`},
+			wantOutput: "ok: G28\nok: M84 ; motors off\n",
+		},
+		{
+			name:       "denied",
+			input:      "M502\nG28",
+			wantSent:   []string{`{"method":"set","params":{"gcodeCmd":"G28"}}`},
+			wantOutput: "error: M502: not allowed: M502 is in the G-code denylist\nok: G28\n",
+			wantFailed: 1,
+		},
+		{
+			name:       "send error",
+			input:      "G28",
+			sendErr:    errors.New("not connected"),
+			wantSent:   []string{`{"method":"set","params":{"gcodeCmd":"G28"}}`},
+			wantOutput: "error: G28: not connected\n",
+			wantFailed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			send := func(msg []byte) error {
+				sent = append(sent, string(msg))
+				return tt.sendErr
+			}
+			var out bytes.Buffer
+			failed := sendGcode(strings.NewReader(tt.input), &out, &sync.Mutex{}, cfg, state.New(), send, false)
+			assert.Equal(t, tt.wantFailed, failed)
+			assert.Equal(t, tt.wantSent, sent)
+			assert.Equal(t, tt.wantOutput, out.String())
+		})
+	}
+}
+
+func TestSendGcode_InteractivePrompt(t *testing.T) {
+	var out bytes.Buffer
+	sendGcode(strings.NewReader("G28\n"), &out, &sync.Mutex{}, config.CommandsConfig{}, state.New(), func([]byte) error { return nil }, true)
+	assert.Equal(t, "gcode> ok: G28\ngcode> ", out.String())
+}
+
+func TestSendGcode_HeaterLimits(t *testing.T) {
+	printer := state.New()
+	printer.Merge(map[string]any{"maxNozzleTemp": 300.0, "maxBedTemp": 100.0})
+
+	tests := []struct {
+		name       string
+		cfg        config.CommandsConfig
+		input      string
+		wantSent   []string
+		wantOutput string
+		wantFailed int
+	}{
+		{
+			name:     "printer limits",
+			input:    "M104S400\nSET_HEATER_TEMPERATURE HEATER=heater_bed TARGET=110\nM140 S60",
+			wantSent: []string{`{"method":"set","params":{"gcodeCmd":"M140 S60"}}`},
+			wantOutput: "error: M104S400: not allowed: M104S400 targets 400 °C, above the nozzle limit of 300 °C\n" +
+				"error: SET_HEATER_TEMPERATURE HEATER=heater_bed TARGET=110: not allowed: SET_HEATER_TEMPERATURE HEATER=heater_bed TARGET=110 targets 110 °C, above the bed limit of 100 °C\n" +
+				"ok: M140 S60\n",
+			wantFailed: 2,
+		},
+		{
+			name:       "configured limit is clamped",
+			cfg:        config.CommandsConfig{MaxNozzleTemp: 250, ClampTemps: true},
+			input:      "M104 S260",
+			wantSent:   []string{`{"method":"set","params":{"gcodeCmd":"M104 S250"}}`},
+			wantOutput: "ok: M104 S250 (clamped from M104 S260)\n",
+		},
+		{
+			name:       "unchecked heater",
+			input:      "M141 S40",
+			wantOutput: "error: M141 S40: not allowed: M141 sets a heater target the bridge can't check\n",
+			wantFailed: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent []string
+			send := func(msg []byte) error {
+				sent = append(sent, string(msg))
+				return nil
+			}
+			var out bytes.Buffer
+			failed := sendGcode(strings.NewReader(tt.input), &out, &sync.Mutex{}, tt.cfg, printer, send, false)
+			assert.Equal(t, tt.wantFailed, failed)
+			assert.Equal(t, tt.wantSent, sent)
+			assert.Equal(t, tt.wantOutput, out.String())
+		})
+	}
+}
+
+func TestGcodeCmd(t *testing.T) {
+	received := make(chan string, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"deviceId":"dev","maxNozzleTemp":300,"maxBedTemp":100}`))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	}))
+	defer srv.Close()
+
+	oldURL := wsURL
+	wsURL = "ws" + strings.TrimPrefix(srv.URL, "http")
+	defer func() { wsURL = oldURL }()
+
+	var out bytes.Buffer
+	gcodeCmd.SetOut(&out)
+	defer gcodeCmd.SetOut(nil)
+	gcodeCmd.SetContext(t.Context())
+	require.NoError(t, gcodeCmd.RunE(gcodeCmd, []string{"G28", "M84"}))
+
+	assert.Equal(t, "ok: G28\nok: M84\n", out.String())
+	for _, want := range []string{"G28", "M84"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, `{"method":"set","params":{"gcodeCmd":"`+want+`"}}`, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("printer did not receive %s", want)
+		}
+	}
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"os"
-	"strconv"
-	"time"
 )
 
 func getEnvOrDefault(envKey, defaultVal string) string {
@@ -12,14 +10,3 @@ func getEnvOrDefault(envKey, defaultVal string) string {
 	}
 	return defaultVal
 }
-func getEnvOrDefaultDuration(envKey string, defaultVal time.Duration) time.Duration {
-	{
-		if v := os.Getenv(envKey); v != "" {
-			// best effort parse
-			if n, err := strconv.Atoi(v); err == nil {
-				return time.Duration(n) * time.Second
-			}
-		}
-		return defaultVal
-	}
-}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/httpserver"
+)
+
+// connectionChecker reports whether a connection is up (*mqttclient.Client)
+type connectionChecker interface {
+	IsConnected() bool
+}
+
+// readinessChecks reports the MQTT connection and, per printer, the WebSocket
+// connection and whether discovery has been published.
+func readinessChecks(mqtt connectionChecker, printers []bridge.Status) []httpserver.Check {
+	checks := []httpserver.Check{check("mqtt", mqtt.IsConnected(), "not connected to broker")}
+	for _, p := range printers {
+		checks = append(checks,
+			check("printer/"+p.Name+"/websocket", p.WSConnected, "not connected to printer"),
+			check("printer/"+p.Name+"/discovery", p.DiscoveryPublished, "waiting for the first printer frame"),
+		)
+	}
+	return checks
+}
+
+func check(name string, ok bool, failure string) httpserver.Check {
+	c := httpserver.Check{Name: name, OK: ok}
+	if !ok {
+		c.Message = failure
+	}
+	return c
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/httpserver"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeConn bool
+
+func (f fakeConn) IsConnected() bool { return bool(f) }
+
+func TestReadinessChecks(t *testing.T) {
+	checks := readinessChecks(fakeConn(true), []bridge.Status{
+		{Name: "k1a", WSConnected: true, DiscoveryPublished: true},
+		{Name: "k1b", WSConnected: false, DiscoveryPublished: false},
+	})
+	assert.Equal(t, []httpserver.Check{
+		{Name: "mqtt", OK: true},
+		{Name: "printer/k1a/websocket", OK: true},
+		{Name: "printer/k1a/discovery", OK: true},
+		{Name: "printer/k1b/websocket", Message: "not connected to printer"},
+		{Name: "printer/k1b/discovery", Message: "waiting for the first printer frame"},
+	}, checks)
+
+	checks = readinessChecks(fakeConn(false), nil)
+	assert.Equal(t, []httpserver.Check{{Name: "mqtt", Message: "not connected to broker"}}, checks)
+}
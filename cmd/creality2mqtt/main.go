@@ -4,11 +4,14 @@ import (
 	"os"
 	"time"
 
+	"github.com/davidcollom/creality2mqtt/internal/config"
 	"github.com/spf13/cobra"
 )
 
 var (
+	configPath      string
 	wsURL           string
+	printerSpecs    []string
 	broker          string
 	clientID        string
 	baseTopic       string
@@ -16,6 +19,8 @@ var (
 	password        string
 	logLevel        string
 	discoveryPrefix string
+	httpAddr        string
+	apiToken        string
 	deviceName      string
 	mqttMinInterval time.Duration
 )
@@ -24,34 +29,51 @@ var (
 var rootCmd = &cobra.Command{
 	Use:   "creality2mqtt",
 	Short: "Bridge between Creality printer WebSocket and MQTT",
-	Long:  `A bridge application that connects to a Creality 3D printer via WebSocket and publishes data to an MQTT broker.`,
+	Long: `A bridge application that connects to Creality 3D printers via WebSocket and publishes data to an MQTT broker.
+
+Settings can be given as flags, CREALITY_* environment variables or a YAML config file (--config).
+Flags take precedence over environment variables, which take precedence over the config file.`,
+	PersistentPreRunE: loadConfig,
 }
 
 func init() {
+	defaults := config.Default()
+
 	// Global flags shared across commands
-	rootCmd.PersistentFlags().StringVar(&broker, "mqtt-broker", getEnvOrDefault("CREALITY_MQTT_BROKER", "tcp://localhost:1883"), "MQTT broker URL")
-	rootCmd.PersistentFlags().StringVar(&clientID, "mqtt-client-id", getEnvOrDefault("CREALITY_MQTT_CLIENT_ID", "creality2mqtt"), "MQTT client ID")
-	rootCmd.PersistentFlags().StringVar(&username, "mqtt-username", os.Getenv("CREALITY_MQTT_USERNAME"), "MQTT username")
-	rootCmd.PersistentFlags().StringVar(&password, "mqtt-password", os.Getenv("CREALITY_MQTT_PASSWORD"), "MQTT password")
-	rootCmd.PersistentFlags().StringVar(&discoveryPrefix, "discovery-prefix", getEnvOrDefault("CREALITY_DISCOVERY_PREFIX", "homeassistant"), "Home Assistant MQTT Discovery prefix")
-	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", getEnvOrDefault("CREALITY_LOG_LEVEL", "info"), "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVarP(&configPath, "config", "c", getEnvOrDefault("CREALITY_CONFIG", ""), "Path to a YAML config file [env CREALITY_CONFIG]")
+	rootCmd.PersistentFlags().StringVar(&broker, "mqtt-broker", defaults.MQTT.Broker, "MQTT broker URL [env CREALITY_MQTT_BROKER]")
+	rootCmd.PersistentFlags().StringVar(&clientID, "mqtt-client-id", defaults.MQTT.ClientID, "MQTT client ID [env CREALITY_MQTT_CLIENT_ID]")
+	rootCmd.PersistentFlags().StringVar(&username, "mqtt-username", defaults.MQTT.Username, "MQTT username [env CREALITY_MQTT_USERNAME]")
+	rootCmd.PersistentFlags().StringVar(&password, "mqtt-password", defaults.MQTT.Password, "MQTT password [env CREALITY_MQTT_PASSWORD]")
+	rootCmd.PersistentFlags().StringVar(&discoveryPrefix, "discovery-prefix", defaults.DiscoveryPrefix, "Home Assistant MQTT Discovery prefix [env CREALITY_DISCOVERY_PREFIX]")
+	rootCmd.PersistentFlags().StringVarP(&logLevel, "log-level", "l", defaults.LogLevel, "Log level (debug, info, warn, error) [env CREALITY_LOG_LEVEL]")
 
 	// Flags specific to the main run command
-	rootCmd.PersistentFlags().StringVar(&wsURL, "ws-url", os.Getenv("CREALITY_WS_URL"), "WebSocket URL of printer (e.g. ws://192.168.1.50:9999/) [required]")
-	rootCmd.PersistentFlags().StringVar(&baseTopic, "mqtt-base-topic", getEnvOrDefault("CREALITY_MQTT_BASE_TOPIC", "creality/printer"), "Base MQTT topic")
-	rootCmd.PersistentFlags().StringVar(&deviceName, "device-name", os.Getenv("CREALITY_DEVICE_NAME"), "Device name override for Home Assistant")
-	rootCmd.PersistentFlags().DurationVar(&mqttMinInterval, "mqtt-min-interval", getEnvOrDefaultDuration("CREALITY_MQTT_MIN_INTERVAL", 60*time.Second), "Minimum seconds between publishes per topic (0=disabled)")
-
-	if wsURL == "" || os.Getenv("CREALITY_WS_URL") != "" {
-		if err := rootCmd.MarkPersistentFlagRequired("ws-url"); err != nil {
-			panic("Failed to mark ws-url flag as required: " + err.Error())
-		}
-	}
+	rootCmd.PersistentFlags().StringVar(&wsURL, "ws-url", defaults.WSURL, "WebSocket URL of printer (e.g. ws://192.168.1.50:9999/) [env CREALITY_WS_URL]")
+	rootCmd.PersistentFlags().StringArrayVar(&printerSpecs, "printer", nil, "Printer to bridge as [name=]ws-url, repeat for several printers (e.g. k1=ws://192.168.1.50:9999/) [env CREALITY_PRINTERS, comma-separated]")
+	rootCmd.PersistentFlags().StringVar(&httpAddr, "http-addr", defaults.HTTPAddr, "Listen address of the HTTP server (health, metrics, dashboard), empty to disable [env CREALITY_HTTP_ADDR]")
+	rootCmd.PersistentFlags().StringVar(&apiToken, "api-token", defaults.APIToken, "Bearer token required by the REST API and dashboard data (empty = no authentication and no API commands) [env CREALITY_API_TOKEN]")
+	rootCmd.PersistentFlags().StringArray("allow-set-param", nil, "Param accepted on <base>/command/set as key[=min:max], repeat for several (e.g. lightSw=0:1) [env CREALITY_SET_ALLOWLIST, comma-separated]")
+	rootCmd.PersistentFlags().StringArray("deny-gcode", nil, "G-code command refused on <base>/gcode/send and by the gcode command, repeat for several; replaces the default denylist (e.g. M502) [env CREALITY_GCODE_DENYLIST, comma-separated]")
+	rootCmd.PersistentFlags().Bool("read-only", false, "Subscribe to no command topics and refuse API commands [env CREALITY_READ_ONLY]")
+	rootCmd.PersistentFlags().Float64("max-nozzle-temp", defaults.Commands.MaxNozzleTemp, "Highest nozzle target accepted in °C, below the printer's own maximum (0=printer limit) [env CREALITY_MAX_NOZZLE_TEMP]")
+	rootCmd.PersistentFlags().Float64("max-bed-temp", defaults.Commands.MaxBedTemp, "Highest bed target accepted in °C, below the printer's own maximum (0=printer limit) [env CREALITY_MAX_BED_TEMP]")
+	rootCmd.PersistentFlags().Bool("clamp-temps", defaults.Commands.ClampTemps, "Lower heater targets above the limit to the limit instead of rejecting them [env CREALITY_CLAMP_TEMPS]")
+	rootCmd.PersistentFlags().Duration("command-min-interval", time.Duration(defaults.Commands.MinInterval), "Minimum time between two commands on the same topic, e.g. 2s (0=no limit) [env CREALITY_COMMAND_MIN_INTERVAL]")
+	rootCmd.PersistentFlags().Duration("command-queue-ttl", time.Duration(defaults.Commands.QueueTTL), "How long a command waits for a disconnected printer before it expires, e.g. 1m (0=refuse commands while disconnected) [env CREALITY_COMMAND_QUEUE_TTL]")
+	rootCmd.PersistentFlags().StringVar(&baseTopic, "mqtt-base-topic", defaults.MQTT.BaseTopic, "Base MQTT topic [env CREALITY_MQTT_BASE_TOPIC]")
+	rootCmd.PersistentFlags().StringVar(&deviceName, "device-name", defaults.DeviceName, "Device name override for Home Assistant [env CREALITY_DEVICE_NAME]")
+	rootCmd.PersistentFlags().DurationVar(&mqttMinInterval, "mqtt-min-interval", time.Duration(defaults.MQTT.MinInterval), "Minimum interval between publishes per topic, e.g. 1s (0=disabled) [env CREALITY_MQTT_MIN_INTERVAL]")
+	rootCmd.PersistentFlags().Bool("mqtt-change-only", defaults.MQTT.ChangeOnly, "Do not publish payloads identical to the last one published on a topic [env CREALITY_MQTT_CHANGE_ONLY]")
+	rootCmd.PersistentFlags().Duration("mqtt-refresh-interval", time.Duration(defaults.MQTT.RefreshInterval), "Publish unchanged payloads again after this long, e.g. 5m (0=never) [env CREALITY_MQTT_REFRESH_INTERVAL]")
+	rootCmd.PersistentFlags().StringArray("mqtt-deadband", nil, "Numeric change below which a topic is not published, as pattern=delta matched against the end of the topic, repeat for several (e.g. temperature/+/current=0.5), needs --mqtt-change-only [env CREALITY_MQTT_DEADBANDS, comma-separated]")
 
 	// Add subcommands
 	rootCmd.AddCommand(cleanupCmd)
 	rootCmd.AddCommand(deviceInfoCmd)
+	rootCmd.AddCommand(gcodeCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(scanCmd)
 }
 
 func main() {
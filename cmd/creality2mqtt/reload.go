@@ -0,0 +1,167 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/mqttclient"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// configWatchInterval is how often the config file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// reloadPlan lists what has to change to go from one config to another
+type reloadPlan struct {
+	logLevel      bool
+	minInterval   bool
+	dedup         bool // change-only publishing or deadbands changed
+	mqttReconnect bool // broker endpoint, credentials or LWT topic changed
+	printers      bool // printers or discovery prefix changed
+	commands      bool // command settings changed
+}
+
+func (p reloadPlan) empty() bool {
+	return p == reloadPlan{}
+}
+
+func planReload(old, next config.Config) reloadPlan {
+	return reloadPlan{
+		logLevel:    old.LogLevel != next.LogLevel,
+		minInterval: old.MQTT.MinInterval != next.MQTT.MinInterval,
+		dedup: old.MQTT.ChangeOnly != next.MQTT.ChangeOnly ||
+			old.MQTT.RefreshInterval != next.MQTT.RefreshInterval ||
+			!slices.Equal(old.MQTT.Deadbands, next.MQTT.Deadbands),
+		mqttReconnect: old.MQTT.Broker != next.MQTT.Broker ||
+			old.MQTT.ClientID != next.MQTT.ClientID ||
+			old.MQTT.Username != next.MQTT.Username ||
+			old.MQTT.Password != next.MQTT.Password ||
+			// The Last Will topic is fixed for the lifetime of a connection
+			old.MQTT.BaseTopic != next.MQTT.BaseTopic,
+		printers: old.DiscoveryPrefix != next.DiscoveryPrefix ||
+			!slices.Equal(old.ResolvedPrinters(), next.ResolvedPrinters()),
+		commands: !reflect.DeepEqual(old.Commands, next.Commands),
+	}
+}
+
+// reloader re-reads the configuration and applies the difference to the running bridge
+type reloader struct {
+	mu      sync.Mutex
+	current config.Config
+	load    func() (config.Config, error)
+	mqtt    *mqttclient.Client
+	bridge  *bridge.Bridge
+}
+
+// Current returns the configuration currently applied
+func (r *reloader) Current() config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// Reload loads the configuration again and applies what changed.
+// An invalid configuration is logged and the current one is kept.
+func (r *reloader) Reload(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := r.load()
+	if err != nil {
+		log.Error("Config reload failed, keeping current configuration", "reason", reason, "error", err)
+		return
+	}
+
+	if next.HTTPAddr != r.current.HTTPAddr || next.APIToken != r.current.APIToken {
+		log.Warn("http_addr and api_token changes take effect after a restart", "http_addr", r.current.HTTPAddr)
+		next.HTTPAddr, next.APIToken = r.current.HTTPAddr, r.current.APIToken
+	}
+	if next.Commands.ReadOnly != r.current.Commands.ReadOnly {
+		// Command topics are subscribed once when a printer starts
+		log.Warn("commands.read_only changes take effect after a restart", "read_only", r.current.Commands.ReadOnly)
+		next.Commands.ReadOnly = r.current.Commands.ReadOnly
+	}
+
+	plan := planReload(r.current, next)
+	if plan.empty() {
+		log.Info("Config reloaded, nothing changed", "reason", reason)
+		return
+	}
+	log.Info("Applying config changes", "reason", reason)
+
+	// Connect first: if that fails nothing is applied and the next reload
+	// tries again from the same configuration
+	if plan.mqttReconnect {
+		if err := r.reconnect(next); err != nil {
+			log.Error("MQTT reconnect failed, keeping current configuration", "error", err)
+			return
+		}
+	}
+
+	if plan.logLevel {
+		level, _ := log.ParseLevel(next.LogLevel) // validated on load
+		log.SetLevel(level)
+		log.Info("Log level changed", "level", next.LogLevel)
+	}
+
+	if plan.minInterval {
+		log.Info("MQTT rate limiting changed", "min_interval", time.Duration(next.MQTT.MinInterval))
+		r.mqtt.SetMinInterval(time.Duration(next.MQTT.MinInterval))
+	}
+
+	if plan.dedup {
+		log.Info("MQTT change-only publishing changed",
+			"change_only", next.MQTT.ChangeOnly,
+			"refresh_interval", time.Duration(next.MQTT.RefreshInterval),
+			"deadbands", len(next.MQTT.Deadbands),
+		)
+		r.mqtt.SetDedup(mqttDedup(next.MQTT))
+	}
+
+	if plan.commands {
+		log.Info("Command settings changed",
+			"set_allowlist", len(next.Commands.SetAllowlist),
+			"gcode_denylist", len(next.Commands.GcodeDenylist),
+			"max_nozzle_temp", next.Commands.MaxNozzleTemp,
+			"max_bed_temp", next.Commands.MaxBedTemp,
+			"clamp_temps", next.Commands.ClampTemps,
+			"min_interval", time.Duration(next.Commands.MinInterval),
+			"queue_ttl", time.Duration(next.Commands.QueueTTL),
+		)
+		r.bridge.SetCommandsConfig(next.Commands)
+	}
+
+	if plan.printers {
+		r.bridge.Apply(bridgePrinters(next), next.DiscoveryPrefix)
+	}
+
+	r.current = next
+}
+
+// reconnect moves the MQTT connection to the settings of next and announces
+// the bridge on it. If the new connection fails the old one is restored and
+// its availability is set back to online.
+func (r *reloader) reconnect(next config.Config) error {
+	oldAvail := types.NewTopicBuilder(r.current.MQTT.BaseTopic, r.current.DiscoveryPrefix).Availability()
+	newAvail := types.NewTopicBuilder(next.MQTT.BaseTopic, next.DiscoveryPrefix).Availability()
+	log.Info("MQTT connection settings changed, reconnecting", "broker", next.MQTT.Broker)
+	if oldAvail != newAvail {
+		r.mqtt.Publish(oldAvail, "offline", true)
+	}
+	if err := r.mqtt.Reconnect(next.MQTT.Broker, next.MQTT.ClientID, next.MQTT.Username, next.MQTT.Password, newAvail, "offline"); err != nil {
+		if oldAvail != newAvail {
+			r.mqtt.Publish(oldAvail, "online", true)
+		}
+		return err
+	}
+	r.mqtt.Publish(newAvail, "online", true)
+	r.bridge.SetWillTopic(newAvail)
+	r.bridge.PublishAvailability()
+	r.bridge.PublishDiscovery()
+	return nil
+}
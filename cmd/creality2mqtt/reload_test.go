@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/mqttclient"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanReload(t *testing.T) {
+	base := config.Default()
+	base.Printers = []config.PrinterConfig{{Name: "a", WSURL: "ws://10.0.0.5:9999/"}}
+
+	tests := []struct {
+		name   string
+		mutate func(c *config.Config)
+		want   reloadPlan
+	}{
+		{
+			name:   "no change",
+			mutate: func(c *config.Config) {},
+			want:   reloadPlan{},
+		},
+		{
+			name: "log level and interval only",
+			mutate: func(c *config.Config) {
+				c.LogLevel = "debug"
+				c.MQTT.MinInterval = config.Duration(time.Second)
+			},
+			want: reloadPlan{logLevel: true, minInterval: true},
+		},
+		{
+			name: "deadbands only",
+			mutate: func(c *config.Config) {
+				c.MQTT.Deadbands = []config.Deadband{{Topic: "job/progress", Delta: 1}}
+			},
+			want: reloadPlan{dedup: true},
+		},
+		{
+			name: "broker change reconnects MQTT only",
+			mutate: func(c *config.Config) {
+				c.MQTT.Broker = "tcp://other:1883"
+			},
+			want: reloadPlan{mqttReconnect: true},
+		},
+		{
+			name: "base topic change moves LWT and printers",
+			mutate: func(c *config.Config) {
+				c.MQTT.BaseTopic = "other"
+			},
+			want: reloadPlan{mqttReconnect: true, printers: true},
+		},
+		{
+			name: "device name change",
+			mutate: func(c *config.Config) {
+				c.Printers = []config.PrinterConfig{{Name: "a", WSURL: "ws://10.0.0.5:9999/", DeviceName: "A"}}
+			},
+			want: reloadPlan{printers: true},
+		},
+		{
+			name: "set allowlist change",
+			mutate: func(c *config.Config) {
+				c.Commands.SetAllowlist = map[string]config.ParamRange{"lightSw": {}}
+			},
+			want: reloadPlan{commands: true},
+		},
+		{
+			name: "discovery prefix change",
+			mutate: func(c *config.Config) {
+				c.DiscoveryPrefix = "ha"
+			},
+			want: reloadPlan{printers: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := base
+			next.Printers = append([]config.PrinterConfig(nil), base.Printers...)
+			tt.mutate(&next)
+			assert.Equal(t, tt.want, planReload(base, next))
+		})
+	}
+}
+
+// brokerConn is a broker connection that records retained publishes
+type brokerConn struct {
+	mqtt.Client
+
+	mu        sync.Mutex
+	connected bool
+	retained  map[string]string
+}
+
+func (b *brokerConn) IsConnected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connected
+}
+
+func (b *brokerConn) Connect() mqtt.Token {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = true
+	return &mqtt.DummyToken{}
+}
+
+func (b *brokerConn) Disconnect(quiesce uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.connected = false
+}
+
+func (b *brokerConn) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if retained {
+		b.retained[topic] = payload.(string)
+	}
+	return &mqtt.DummyToken{}
+}
+
+func TestReloader_FailedReconnectAppliesNothing(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.InfoLevel)
+
+	current := config.Default()
+	current.LogLevel = "info"
+	conn := &brokerConn{connected: true, retained: map[string]string{}}
+	client := mqttclient.NewWithClient(conn, current.MQTT.Broker)
+
+	next := current
+	next.LogLevel = "debug"
+	next.MQTT.MinInterval = config.Duration(time.Minute)
+	next.MQTT.BaseTopic = "other"
+	// Nothing listens on port 1, the connection is refused
+	next.MQTT.Broker = "tcp://127.0.0.1:1"
+
+	r := &reloader{
+		current: current,
+		load:    func() (config.Config, error) { return next, nil },
+		mqtt:    client,
+		bridge:  bridge.New(client, current.DiscoveryPrefix, nil),
+	}
+	r.Reload("test")
+
+	assert.Equal(t, current, r.Current(), "configuration kept")
+	assert.Equal(t, log.InfoLevel, log.GetLevel(), "log level not applied")
+	assert.Equal(t, current.MQTT.Broker, client.Broker())
+	assert.True(t, conn.IsConnected(), "previous connection restored")
+	avail := types.NewTopicBuilder(current.MQTT.BaseTopic, current.DiscoveryPrefix).Availability()
+	assert.Equal(t, map[string]string{avail: "online"}, conn.retained,
+		"previous availability set back to online")
+}
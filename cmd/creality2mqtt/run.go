@@ -2,31 +2,34 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"net/url"
 	"os"
 	"os/signal"
-	"strings"
-	"sync"
 	"syscall"
+	"time"
 
 	"github.com/charmbracelet/log"
-	"github.com/davidcollom/creality2mqtt/internal/discovery"
-	"github.com/davidcollom/creality2mqtt/internal/mapper"
+	"github.com/davidcollom/creality2mqtt/internal/api"
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/dashboard"
+	"github.com/davidcollom/creality2mqtt/internal/httpserver"
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
 	"github.com/davidcollom/creality2mqtt/internal/mqttclient"
 	"github.com/davidcollom/creality2mqtt/internal/types"
-	"github.com/davidcollom/creality2mqtt/internal/wsclient"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/spf13/cobra"
 )
 
 var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Run the Creality to MQTT bridge",
-	Long:  `Connects to a Creality 3D printer via WebSocket and publishes data to an MQTT broker.`,
+	Long: `Connects to one or more Creality 3D printers via WebSocket and publishes data to an MQTT broker.
+Use --ws-url for a single printer, or repeat --printer name=ws-url to bridge several printers
+over one MQTT connection.
+
+Send SIGHUP (or edit the --config file) to reload the configuration without restarting.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if wsURL == "" {
+		printers := bridgePrinters(appConfig)
+		if len(printers) == 0 {
 			return cmd.Help()
 		}
 
@@ -38,17 +41,21 @@ var runCmd = &cobra.Command{
 		}
 		log.SetLevel(level)
 
-		log.Info("Starting creality2mqtt bridge")
+		log.Info("Starting creality2mqtt bridge", "printers", len(printers))
 		log.Debug("Configuration",
-			"ws_url", wsURL,
 			"mqtt_broker", broker,
 			"mqtt_client_id", clientID,
 			"base_topic", baseTopic,
 			"discovery_prefix", discoveryPrefix,
+			"http_addr", httpAddr,
 			"log_level", logLevel,
+			"config", configPath,
 		)
+		for _, p := range printers {
+			log.Debug("Printer", "name", p.Name, "ws_url", p.WSURL, "base_topic", p.BaseTopic)
+		}
 
-		// Create topic builder for consistent topic construction
+		// Create topic builder for the bridge-wide topics
 		topics := types.NewTopicBuilder(baseTopic, discoveryPrefix)
 
 		// Set up Last Will Testament (LWT) - published when we disconnect unexpectedly
@@ -62,9 +69,27 @@ var runCmd = &cobra.Command{
 		} else {
 			log.Info("MQTT rate limiting disabled")
 		}
+		mqttClient.SetDedup(mqttDedup(appConfig.MQTT))
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer cancel()
+
+		b := bridge.New(mqttClient, discoveryPrefix, printers)
+		b.SetWillTopic(topics.Availability())
+		b.SetCommandsConfig(appConfig.Commands)
+		r := &reloader{
+			current: appConfig,
+			load: func() (config.Config, error) {
+				return resolveConfig(configPath, os.LookupEnv, cmd.Flags())
+			},
+			mqtt:   mqttClient,
+			bridge: b,
+		}
+
 		defer func() {
 			// Publish offline status before disconnecting
-			mqttClient.Publish(topics.Availability(), "offline", true)
+			cur := r.Current()
+			mqttClient.Publish(types.NewTopicBuilder(cur.MQTT.BaseTopic, cur.DiscoveryPrefix).Availability(), "offline", true)
 			mqttClient.Disconnect()
 		}()
 
@@ -72,190 +97,87 @@ var runCmd = &cobra.Command{
 		mqttClient.Publish(topics.Availability(), "online", true)
 		log.Info("Published birth message", "topic", topics.Availability())
 
-		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-		defer cancel()
-
-		// Track discovery config
-		var discoveryMu sync.Mutex
-		var discoCfg *discovery.Config
-		var discoveryMsgs []types.MqttMessage
-		// Track published CFS box discovery to avoid duplicates
-		publishedCFS := map[int]bool{}
-
-		// Helper function to publish discovery messages
-		publishDiscovery := func() {
-			discoveryMu.Lock()
-			defer discoveryMu.Unlock()
-			if discoCfg != nil && len(discoveryMsgs) > 0 {
-				log.Info("Publishing MQTT Discovery messages", "count", len(discoveryMsgs))
-				for _, m := range discoveryMsgs {
-					log.Debug("Publishing discovery config", "topic", m.Topic)
-					mqttClient.Publish(m.Topic, m.Payload, m.Retain)
-				}
-				log.Info("MQTT Discovery published - entities should appear in Home Assistant")
+		// Serve health, readiness, version and metrics endpoints, the REST API and the dashboard
+		if httpAddr != "" {
+			srv := httpserver.New(httpAddr, versionInfo(), func() []httpserver.Check {
+				return readinessChecks(mqttClient, b.Status())
+			})
+			srv.Handle("GET /metrics", metrics.Handler())
+			if apiToken == "" {
+				log.Info("No api_token set, the REST API refuses commands")
 			}
+			srv.Handle("/api/", api.New(b, apiToken))
+			srv.Handle("/", dashboard.New(b, mqttClient, apiToken))
+			go func() {
+				if err := srv.Run(ctx); err != nil {
+					log.Error("HTTP server failed", "addr", httpAddr, "error", err)
+				}
+			}()
 		}
 
-		// Subscribe to Home Assistant status to republish discovery on HA restart
-		err = mqttClient.Subscribe(topics.HAStatus(), func(client mqtt.Client, msg mqtt.Message) {
-			payload := string(msg.Payload())
-			log.Debug("Home Assistant status changed", "status", payload)
-			if payload == "online" {
-				log.Info("Home Assistant came online, republishing discovery")
-				publishDiscovery()
-			}
-		})
-		if err != nil {
-			log.Warn("Failed to subscribe to HA status", "error", err)
-		}
-
-		// Track if we've sent discovery messages
-		var discoveryOnce sync.Once
-
-		// Create WebSocket client (before handler so we can reference it)
-		ws := wsclient.New(wsURL, nil)
+		// Reload the configuration on SIGHUP or when the config file changes
+		go watchReload(ctx, r)
 
-		// Subscribe to light control command topic
-		err = mqttClient.Subscribe(topics.LightCommand(), func(client mqtt.Client, msg mqtt.Message) {
-			payload := string(msg.Payload())
-			log.Info("Received light command", "payload", payload)
+		log.Info("Press Ctrl+C to stop")
+		b.Run(ctx)
 
-			// Convert MQTT payload to WebSocket command
-			var lightValue int
-			switch payload {
-			case "ON", "1":
-				lightValue = 1
-			case "OFF", "0":
-				lightValue = 0
-			default:
-				log.Warn("Invalid light command payload", "payload", payload)
-				return
-			}
+		log.Info("Shutting down gracefully")
+		return nil
+	},
+}
 
-			// Send command to printer via WebSocket with proper format
-			wsCmd := fmt.Sprintf(`{"method":"set","params":{"lightSw":%d}}`, lightValue)
-			if err := ws.SendMessage([]byte(wsCmd)); err != nil {
-				log.Error("Failed to send light command to printer", "error", err)
-			} else {
-				log.Info("Sent light command to printer", "command", wsCmd)
-			}
+// bridgePrinters converts the resolved printer configs for the bridge
+func bridgePrinters(cfg config.Config) []bridge.PrinterConfig {
+	resolved := cfg.ResolvedPrinters()
+	printers := make([]bridge.PrinterConfig, 0, len(resolved))
+	for _, p := range resolved {
+		printers = append(printers, bridge.PrinterConfig{
+			Name:       p.Name,
+			WSURL:      p.WSURL,
+			BaseTopic:  p.BaseTopic,
+			DeviceName: p.DeviceName,
 		})
-		if err != nil {
-			log.Warn("Failed to subscribe to light command topic", "error", err)
-		}
-
-		handler := func(data []byte) {
-			log.Debug("Received WebSocket message", "size", len(data))
-
-			// Parse the message to extract device info on first message
-			var rawMsg map[string]any
-			if err := json.Unmarshal(data, &rawMsg); err == nil {
-				discoveryOnce.Do(func() {
-					// Extract device info from first message
-					deviceID, devName, deviceModel := discovery.ExtractDeviceInfo(rawMsg)
-					if deviceName != "" {
-						devName = deviceName // Use CLI override if provided
-					}
-
-					log.Info("Device detected",
-						"device_id", deviceID,
-						"device_name", devName,
-						"device_model", deviceModel,
-					)
-
-					// Extract printer IP from WebSocket URL
-					printerIP := ""
-					if u, err := url.Parse(wsURL); err == nil {
-						printerIP = strings.Split(u.Host, ":")[0]
-					}
-
-					// Store discovery config for later republishing
-					discoveryMu.Lock()
-					discoCfg = &discovery.Config{
-						DiscoveryPrefix: discoveryPrefix,
-						BaseTopic:       baseTopic,
-						DeviceID:        deviceID,
-						DeviceName:      devName,
-						DeviceModel:     deviceModel,
-						PrinterIP:       printerIP,
-					}
-
-					// First, cleanup old/unused entities
-					cleanupMsgs := discovery.CleanupOldEntities(*discoCfg)
-					if len(cleanupMsgs) > 0 {
-						log.Info("Cleaning up old entities", "count", len(cleanupMsgs))
-						for _, m := range cleanupMsgs {
-							log.Debug("Removing old entity", "topic", m.Topic)
-							mqttClient.Publish(m.Topic, m.Payload, m.Retain)
-						}
-					}
-
-					// Then generate current discovery messages
-					discoveryMsgs = discovery.GenerateDiscoveryMessages(*discoCfg)
-					discoveryMu.Unlock()
-
-					// Publish discovery messages
-					publishDiscovery()
-				})
-
-				// Dynamic discovery for CFS box sensors when seen
-				if discoCfg != nil {
-					if bs, ok := rawMsg["boxState"].(map[string]any); ok {
-						if idAny, ok := bs["id"]; ok {
-							id := 0
-							switch v := idAny.(type) {
-							case float64:
-								id = int(v)
-							case int:
-								id = v
-							}
-							if id > 0 {
-								discoveryMu.Lock()
-								if !publishedCFS[id] {
-									device := &discovery.Device{
-										Identifiers:  []string{discoCfg.DeviceID},
-										Name:         discoCfg.DeviceName,
-										Manufacturer: "Creality",
-										Model:        discoCfg.DeviceModel,
-									}
-									topics := types.NewTopicBuilder(discoCfg.BaseTopic, discoCfg.DiscoveryPrefix)
-									availTopic := topics.Availability()
-									msgs := discovery.BuildCFSBoxSensors(*discoCfg, device, availTopic, id)
-									for _, m := range msgs {
-										log.Info("Publishing CFS discovery", "topic", m.Topic)
-										mqttClient.Publish(m.Topic, m.Payload, m.Retain)
-									}
-									publishedCFS[id] = true
-								}
-								discoveryMu.Unlock()
-							}
-						}
-					}
-				}
-			}
+	}
+	return printers
+}
 
-			msgs, err := mapper.DecodeAndMap(data, baseTopic)
-			if err != nil {
-				log.Error("Failed to decode message", "error", err)
-				return
-			}
+// mqttDedup converts the change-only publishing settings for the MQTT client
+func mqttDedup(cfg config.MQTTConfig) mqttclient.DedupConfig {
+	bands := make([]mqttclient.Deadband, 0, len(cfg.Deadbands))
+	for _, b := range cfg.Deadbands {
+		bands = append(bands, mqttclient.Deadband{Pattern: b.Topic, Delta: b.Delta})
+	}
+	return mqttclient.DedupConfig{
+		Enabled:   cfg.ChangeOnly,
+		Refresh:   time.Duration(cfg.RefreshInterval),
+		Deadbands: bands,
+	}
+}
 
-			for _, m := range msgs {
-				log.Debug("Publishing MQTT message", "topic", m.Topic, "payload", m.Payload)
-				mqttClient.Publish(m.Topic, m.Payload, m.Retain)
+// watchReload triggers a config reload on SIGHUP and, with --config, on file changes
+func watchReload(ctx context.Context, r *reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	changed := make(chan struct{}, 1)
+	if configPath != "" {
+		go config.Watch(ctx, configPath, configWatchInterval, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
 			}
+		})
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.Reload("SIGHUP")
+		case <-changed:
+			r.Reload("config file changed")
 		}
-
-		// Set handler on WebSocket client
-		ws.SetHandler(handler)
-
-		log.Info("Starting WebSocket connection", "url", wsURL)
-		log.Info("Press Ctrl+C to stop")
-		if err := ws.Run(ctx); err != nil && err != context.Canceled {
-			log.Error("WebSocket error", "error", err)
-		}
-
-		log.Info("Shutting down gracefully")
-		return nil
-	},
+	}
 }
@@ -1 +1,74 @@
 package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/mqttclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridgePrinters(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        func(c *config.Config)
+		wantNames  []string
+		wantTopics []string
+		wantDevice []string
+	}{
+		{
+			name: "single ws-url keeps base topic",
+			cfg: func(c *config.Config) {
+				c.WSURL = "ws://192.168.1.50:9999/"
+				c.DeviceName = "My K1"
+			},
+			wantNames:  []string{"192_168_1_50"},
+			wantTopics: []string{"creality/printer"},
+			wantDevice: []string{"My K1"},
+		},
+		{
+			name: "several printers get their own base topic",
+			cfg: func(c *config.Config) {
+				c.Printers = []config.PrinterConfig{
+					{Name: "k1a", WSURL: "ws://10.0.0.5:9999/"},
+					{Name: "K1 Max", WSURL: "ws://10.0.0.6:9999/", DeviceName: "Big One"},
+				}
+			},
+			wantNames:  []string{"k1a", "k1_max"},
+			wantTopics: []string{"creality/printer/k1a", "creality/printer/k1_max"},
+			wantDevice: []string{"", "Big One"},
+		},
+		{
+			name: "no printers",
+			cfg:  func(c *config.Config) {},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			tt.cfg(&cfg)
+			got := bridgePrinters(cfg)
+			require.Len(t, got, len(tt.wantNames))
+			for i, p := range got {
+				assert.Equal(t, tt.wantNames[i], p.Name)
+				assert.Equal(t, tt.wantTopics[i], p.BaseTopic)
+				assert.Equal(t, tt.wantDevice[i], p.DeviceName)
+			}
+		})
+	}
+}
+
+func TestMQTTDedup(t *testing.T) {
+	cfg := config.Default().MQTT
+	cfg.ChangeOnly = true
+	cfg.Deadbands = []config.Deadband{{Topic: "temperature/+/current", Delta: 0.5}}
+
+	assert.Equal(t, mqttclient.DedupConfig{
+		Enabled:   true,
+		Refresh:   5 * time.Minute,
+		Deadbands: []mqttclient.Deadband{{Pattern: "temperature/+/current", Delta: 0.5}},
+	}, mqttDedup(cfg))
+}
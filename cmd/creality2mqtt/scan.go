@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/discovery"
+	"github.com/davidcollom/creality2mqtt/internal/scanner"
+	"github.com/spf13/cobra"
+)
+
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Find Creality printers on the local network",
+	Long: `Probes a CIDR range, or the subnets of the local network interfaces, for the printer WebSocket
+on port 9999 and prints the device information of every printer found.
+
+Use --write-config to store the printers found as a ready-to-use config file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cidrs, _ := cmd.Flags().GetStringSlice("cidr")
+		port, _ := cmd.Flags().GetInt("port")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		concurrency, _ := cmd.Flags().GetInt("concurrency")
+		output, _ := cmd.Flags().GetString("output")
+		writePath, _ := cmd.Flags().GetString("write-config")
+
+		if output != "table" && output != "json" {
+			return fmt.Errorf("invalid output %q (use table or json)", output)
+		}
+
+		// Set log level
+		level, err := log.ParseLevel(logLevel)
+		if err != nil {
+			log.Warn("Invalid log level, using info", "level", logLevel)
+			level = log.InfoLevel
+		}
+		log.SetLevel(level)
+
+		if writePath != "" {
+			if _, err := os.Stat(writePath); err == nil {
+				return fmt.Errorf("%s already exists, refusing to overwrite it", writePath)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+
+		prefixes, err := scanner.ParsePrefixes(cidrs)
+		if err != nil {
+			return err
+		}
+		if len(prefixes) == 0 {
+			if prefixes, err = scanner.LocalSubnets(); err != nil {
+				return fmt.Errorf("list local subnets: %w", err)
+			}
+			if len(prefixes) == 0 {
+				return fmt.Errorf("no local IPv4 subnets found, use --cidr")
+			}
+		}
+		hosts, err := scanner.Hosts(prefixes)
+		if err != nil {
+			return err
+		}
+
+		log.Info("Scanning for printers", "ranges", prefixes, "hosts", len(hosts), "port", port)
+		results := scanner.Scan(cmd.Context(), hosts, scanner.Options{
+			Port:        port,
+			Timeout:     timeout,
+			Concurrency: concurrency,
+		})
+		log.Info("Scan complete", "printers", len(results))
+
+		if err := printScanResults(cmd.OutOrStdout(), output, results); err != nil {
+			return err
+		}
+
+		if writePath != "" {
+			if len(results) == 0 {
+				return fmt.Errorf("no printers found, not writing %s", writePath)
+			}
+			if err := config.Write(writePath, scanConfig(appConfig, results)); err != nil {
+				return err
+			}
+			log.Info("Wrote config file, without the MQTT password and API token", "path", writePath)
+		}
+		return nil
+	},
+}
+
+// printScanResults writes the printers found as a table or as JSON
+func printScanResults(w io.Writer, output string, results []scanner.Result) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if results == nil {
+			results = []scanner.Result{}
+		}
+		return enc.Encode(results)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "IP\tDEVICE ID\tNAME\tMODEL\tWS URL")
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.IP, r.DeviceID, r.DeviceName, r.DeviceModel, r.WSURL)
+	}
+	return tw.Flush()
+}
+
+// scanConfig returns base with its printers replaced by the printers found.
+// The MQTT password and API token are left out: they may come from env vars or
+// flags and do not belong in a file written for sharing and editing.
+func scanConfig(base config.Config, results []scanner.Result) config.Config {
+	cfg := base
+	cfg.WSURL = ""
+	cfg.DeviceName = ""
+	cfg.MQTT.Password = ""
+	cfg.APIToken = ""
+	cfg.Printers = make([]config.PrinterConfig, 0, len(results))
+	taken := map[string]bool{}
+	for _, r := range results {
+		name := scanPrinterName(r, taken)
+		taken[name] = true
+		cfg.Printers = append(cfg.Printers, config.PrinterConfig{
+			Name:  name,
+			WSURL: r.WSURL,
+		})
+	}
+	return cfg
+}
+
+// scanPrinterName names a printer found by the scan after its device name, or
+// its device ID when the name is unknown or taken, so its base topic survives
+// a new DHCP lease. The IP is only used when neither is usable.
+func scanPrinterName(r scanner.Result, taken map[string]bool) string {
+	// ExtractDeviceInfo falls back to these when the printer does not report them
+	unknownID, unknownName, _ := discovery.ExtractDeviceInfo(nil)
+
+	var candidates []string
+	if r.DeviceName != unknownName {
+		candidates = append(candidates, r.DeviceName)
+	}
+	if r.DeviceID != unknownID {
+		candidates = append(candidates, r.DeviceID)
+	}
+	for _, c := range candidates {
+		if name := config.SanitizeName(c); name != "" && !taken[name] {
+			return name
+		}
+	}
+	return config.SanitizeName(r.IP)
+}
+
+func init() {
+	scanCmd.Flags().StringSlice("cidr", nil, "Range(s) to scan, e.g. 192.168.1.0/24 (default: subnets of the local interfaces)")
+	scanCmd.Flags().Int("port", scanner.DefaultPort, "Printer WebSocket port")
+	scanCmd.Flags().Duration("timeout", 2*time.Second, "Timeout per host to connect and receive the first frame")
+	scanCmd.Flags().Int("concurrency", 64, "Number of hosts probed in parallel")
+	scanCmd.Flags().StringP("output", "o", "table", "Output format (table, json)")
+	scanCmd.Flags().String("write-config", "", "Write a config file with the printers found to this path")
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/scanner"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var scanResults = []scanner.Result{
+	{IP: "192.168.1.50", WSURL: "ws://192.168.1.50:9999/", DeviceID: "k1_a", DeviceName: "K1 A", DeviceModel: "K1 Max"},
+	{IP: "192.168.1.51", WSURL: "ws://192.168.1.51:9999/", DeviceID: "k1_b", DeviceName: "K1 B", DeviceModel: "K1 SE"},
+}
+
+func TestPrintScanResults(t *testing.T) {
+	var table bytes.Buffer
+	require.NoError(t, printScanResults(&table, "table", scanResults))
+	assert.Contains(t, table.String(), "IP            DEVICE ID")
+	assert.Contains(t, table.String(), "192.168.1.51  k1_b")
+
+	var out bytes.Buffer
+	require.NoError(t, printScanResults(&out, "json", scanResults))
+	var decoded []scanner.Result
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, scanResults, decoded)
+
+	out.Reset()
+	require.NoError(t, printScanResults(&out, "json", nil))
+	assert.Equal(t, "[]\n", out.String())
+}
+
+func TestScanConfig(t *testing.T) {
+	base := config.Default()
+	base.WSURL = "ws://10.0.0.1:9999/"
+	base.MQTT.Broker = "tcp://broker:1883"
+	base.MQTT.Username = "bridge"
+	base.MQTT.Password = "from-env"
+	base.APIToken = "from-flag"
+
+	cfg := scanConfig(base, scanResults)
+	assert.Empty(t, cfg.WSURL)
+	assert.Equal(t, "tcp://broker:1883", cfg.MQTT.Broker)
+	assert.Equal(t, "bridge", cfg.MQTT.Username)
+	// Credentials are not written
+	assert.Empty(t, cfg.MQTT.Password)
+	assert.Empty(t, cfg.APIToken)
+	assert.Equal(t, []config.PrinterConfig{
+		{Name: "k1_a", WSURL: "ws://192.168.1.50:9999/"},
+		{Name: "k1_b", WSURL: "ws://192.168.1.51:9999/"},
+	}, cfg.Printers)
+	require.NoError(t, cfg.Validate())
+}
+
+func TestScanConfig_PrinterNames(t *testing.T) {
+	results := []scanner.Result{
+		{IP: "192.168.1.50", WSURL: "ws://192.168.1.50:9999/", DeviceID: "k1_a", DeviceName: "Workshop"},
+		// Same name: the device ID is used instead
+		{IP: "192.168.1.51", WSURL: "ws://192.168.1.51:9999/", DeviceID: "k1_b", DeviceName: "Workshop"},
+		// Name not reported
+		{IP: "192.168.1.52", WSURL: "ws://192.168.1.52:9999/", DeviceID: "k1_c", DeviceName: "Creality Printer"},
+		// Neither reported
+		{IP: "192.168.1.53", WSURL: "ws://192.168.1.53:9999/", DeviceID: "creality_printer", DeviceName: "Creality Printer"},
+	}
+
+	cfg := scanConfig(config.Default(), results)
+	var names []string
+	for _, p := range cfg.Printers {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"workshop", "k1_b", "k1_c", "192_168_1_53"}, names)
+	require.NoError(t, cfg.Validate())
+}
@@ -1,11 +1,18 @@
 package main
 
+import "github.com/davidcollom/creality2mqtt/internal/httpserver"
+
 var (
 	version = "dev"
 	commit  = "none"
 	date    = "unknown"
 )
 
+// versionInfo returns the build information served on /version
+func versionInfo() httpserver.VersionInfo {
+	return httpserver.VersionInfo{Version: version, Commit: commit, Date: date}
+}
+
 func init() {
 	rootCmd.Version = version + " (commit: " + commit + ", date: " + date + ")"
 }
@@ -0,0 +1,162 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/httpserver"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// API serves the JSON REST API for printer state and commands
+type API struct {
+	bridge *bridge.Bridge
+	token  string
+	mux    *http.ServeMux
+}
+
+// CommandRequest is the body of POST /api/printers/{id}/commands.
+// Payload is the same payload the command's MQTT topic accepts, as a JSON string
+// or, for structured commands, as a JSON value.
+type CommandRequest struct {
+	Command string          `json:"command"`
+	Payload json.RawMessage `json:"payload"`
+	// Force bypasses the interlock refusing G-code while printing
+	Force bool `json:"force,omitempty"`
+}
+
+// PrinterInfo is an entry of GET /api/printers
+type PrinterInfo struct {
+	bridge.Status
+	BaseTopic string `json:"base_topic"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// New creates the API for the bridge. When token is set, every request except
+// the OpenAPI document needs an "Authorization: Bearer <token>" header.
+// Without a token the API is read-only: commands are refused.
+func New(b *bridge.Bridge, token string) *API {
+	a := &API{bridge: b, token: token, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /api/openapi.yaml", a.handleOpenAPI)
+	a.mux.Handle("GET /api/printers", a.auth(a.handlePrinters))
+	a.mux.Handle("GET /api/printers/{id}/state", a.auth(a.handleState))
+	a.mux.Handle("GET /api/printers/{id}/topics", a.auth(a.handleTopics))
+	a.mux.Handle("POST /api/printers/{id}/commands", a.auth(a.handleCommand))
+	return a
+}
+
+// ServeHTTP implements http.Handler. Mount it on "/api/".
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) auth(next http.HandlerFunc) http.Handler {
+	return httpserver.RequireToken(a.token, next)
+}
+
+func (a *API) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	_, _ = w.Write(openAPISpec)
+}
+
+func (a *API) handlePrinters(w http.ResponseWriter, r *http.Request) {
+	out := []PrinterInfo{}
+	for _, p := range a.bridge.Printers() {
+		out = append(out, PrinterInfo{Status: p.Status(), BaseTopic: p.Config().BaseTopic})
+	}
+	httpserver.WriteJSON(w, http.StatusOK, out)
+}
+
+// printer resolves the {id} path value, writing a 404 when it is unknown
+func (a *API) printer(w http.ResponseWriter, r *http.Request) *bridge.Printer {
+	p := a.bridge.Printer(r.PathValue("id"))
+	if p == nil {
+		writeError(w, http.StatusNotFound, "printer not found")
+	}
+	return p
+}
+
+func (a *API) handleState(w http.ResponseWriter, r *http.Request) {
+	if p := a.printer(w, r); p != nil {
+		httpserver.WriteJSON(w, http.StatusOK, p.State())
+	}
+}
+
+func (a *API) handleTopics(w http.ResponseWriter, r *http.Request) {
+	if p := a.printer(w, r); p != nil {
+		httpserver.WriteJSON(w, http.StatusOK, p.Values())
+	}
+}
+
+func (a *API) handleCommand(w http.ResponseWriter, r *http.Request) {
+	// Anything on the network could send commands otherwise
+	if a.token == "" {
+		writeError(w, http.StatusForbidden, "commands need an api_token")
+		return
+	}
+	// A cross-site form or text/plain POST cannot set this content type
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, "content type must be application/json")
+		return
+	}
+	p := a.printer(w, r)
+	if p == nil {
+		return
+	}
+
+	var req CommandRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if req.Command == "" {
+		writeError(w, http.StatusBadRequest, "command is required")
+		return
+	}
+
+	run := p.Command
+	if req.Force {
+		run = p.ForceCommand
+	}
+	id, err := run(req.Command, payloadString(req.Payload))
+	switch {
+	case errors.Is(err, bridge.ErrUnknownCommand), errors.Is(err, bridge.ErrInvalidPayload):
+		writeError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, bridge.ErrNotAllowed), errors.Is(err, bridge.ErrReadOnly):
+		writeError(w, http.StatusForbidden, err.Error())
+	case errors.Is(err, bridge.ErrJobState):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, bridge.ErrRateLimited):
+		writeError(w, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, bridge.ErrQueued):
+		httpserver.WriteJSON(w, http.StatusAccepted, map[string]string{"status": bridge.ResultQueued, "id": id})
+	case err != nil:
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		httpserver.WriteJSON(w, http.StatusAccepted, map[string]string{"status": bridge.ResultSent, "id": id})
+	}
+}
+
+// payloadString unquotes JSON strings and passes other JSON values through as text
+func payloadString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	return string(raw)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	httpserver.WriteJSON(w, code, errorResponse{Error: msg})
+}
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakePublisher struct{}
+
+func (fakePublisher) Publish(topic, payload string, retain bool)  {}
+func (fakePublisher) PublishNow(topic, payload string)            {}
+func (fakePublisher) Subscribe(string, mqtt.MessageHandler) error { return nil }
+func (fakePublisher) Unsubscribe(string) error                    { return nil }
+
+// printerServer is a printer WebSocket that records the messages it receives
+type printerServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []string
+}
+
+func newPrinterServer(t *testing.T) *printerServer {
+	t.Helper()
+	ps := &printerServer{}
+	ps.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"deviceId":"dev","nozzleTemp":"200.0"}`))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			ps.mu.Lock()
+			ps.received = append(ps.received, string(data))
+			ps.mu.Unlock()
+		}
+	}))
+	t.Cleanup(ps.Close)
+	return ps
+}
+
+func (ps *printerServer) messages() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append([]string(nil), ps.received...)
+}
+
+// newTestAPI runs a bridge with printer "k1" connected to the printer server
+func newTestAPI(t *testing.T, token string) (*API, *printerServer) {
+	t.Helper()
+	ps := newPrinterServer(t)
+	b := bridge.New(fakePublisher{}, "ha", []bridge.PrinterConfig{
+		{Name: "k1", WSURL: "ws" + strings.TrimPrefix(ps.URL, "http"), BaseTopic: "bt"},
+		{Name: "offline", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt2"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	require.Eventually(t, func() bool {
+		// Discovery is published before the frame's values are recorded
+		p := b.Printer("k1")
+		s := p.Status()
+		return s.WSConnected && s.DiscoveryPublished && len(p.Values()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	return New(b, token), ps
+}
+
+func do(a *API, method, path, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAPI_Read(t *testing.T) {
+	a, _ := newTestAPI(t, "")
+
+	tests := []struct {
+		name        string
+		path        string
+		wantCode    int
+		wantContent string
+	}{
+		{"printers", "/api/printers", http.StatusOK, `"name":"k1","device_id":"dev","ws_connected":true`},
+		{"state by name", "/api/printers/k1/state", http.StatusOK, `"nozzleTemp":"200.0"`},
+		{"state by device id", "/api/printers/dev/state", http.StatusOK, `"deviceId":"dev"`},
+		{"topics", "/api/printers/k1/topics", http.StatusOK, `"topic":"bt/temperature/nozzle/current","payload":"200.000"`},
+		{"unknown printer", "/api/printers/nope/state", http.StatusNotFound, `"error":"printer not found"`},
+		{"openapi", "/api/openapi.yaml", http.StatusOK, "openapi: 3.0.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(a, http.MethodGet, tt.path, "", "")
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantContent)
+		})
+	}
+}
+
+func TestAPI_Commands(t *testing.T) {
+	a, ps := newTestAPI(t, "s3cret")
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		wantCode    int
+		wantContent string
+	}{
+		{"light on", "/api/printers/k1/commands", `{"command":"light","payload":"ON"}`, http.StatusAccepted, `"status":"sent"`},
+		{"unknown command", "/api/printers/k1/commands", `{"command":"explode"}`, http.StatusBadRequest, "unknown command"},
+		{"invalid payload", "/api/printers/k1/commands", `{"command":"light","payload":"maybe"}`, http.StatusBadRequest, "invalid payload"},
+		{"missing command", "/api/printers/k1/commands", `{}`, http.StatusBadRequest, "command is required"},
+		{"unknown field", "/api/printers/k1/commands", `{"cmd":"light"}`, http.StatusBadRequest, "invalid request body"},
+		{"printer offline", "/api/printers/offline/commands", `{"command":"light","payload":"OFF"}`, http.StatusServiceUnavailable, "send to printer"},
+		{"resume while idle", "/api/printers/k1/commands", `{"command":"resume"}`, http.StatusConflict, "needs a paused job"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(a, http.MethodPost, tt.path, tt.body, "s3cret")
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.wantContent)
+		})
+	}
+
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{`{"method":"set","params":{"lightSw":1}}`}, ps.messages())
+
+	// The response carries the correlation ID of the published results
+	rec := do(a, http.MethodPost, "/api/printers/k1/commands", `{"command":"light","payload":"OFF"}`, "s3cret")
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Len(t, resp["id"], 16)
+}
+
+func TestAPI_CommandPolicy(t *testing.T) {
+	a, _ := newTestAPI(t, "s3cret")
+
+	a.bridge.SetCommandsConfig(config.CommandsConfig{MinInterval: config.Duration(time.Hour)})
+	rec := do(a, http.MethodPost, "/api/printers/k1/commands", `{"command":"gcode","payload":"G28","force":true}`, "s3cret")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	rec = do(a, http.MethodPost, "/api/printers/k1/commands", `{"command":"gcode","payload":"G28"}`, "s3cret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Contains(t, rec.Body.String(), "rate limited")
+
+	a.bridge.SetCommandsConfig(config.CommandsConfig{ReadOnly: true})
+	rec = do(a, http.MethodPost, "/api/printers/k1/commands", `{"command":"light","payload":"ON"}`, "s3cret")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "read-only mode")
+}
+
+func TestAPI_CommandQueued(t *testing.T) {
+	a, _ := newTestAPI(t, "s3cret")
+	a.bridge.SetCommandsConfig(config.CommandsConfig{QueueTTL: config.Duration(time.Minute)})
+
+	// The offline printer holds the command until it reconnects
+	rec := do(a, http.MethodPost, "/api/printers/offline/commands", `{"command":"light","payload":"OFF"}`, "s3cret")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"status":"queued"`)
+
+	rec = do(a, http.MethodGet, "/api/printers", "", "s3cret")
+	assert.Contains(t, rec.Body.String(), `"name":"offline","ws_connected":false,"discovery_published":false,"queued_commands":1`)
+
+	// G-code is not queued
+	rec = do(a, http.MethodPost, "/api/printers/offline/commands", `{"command":"gcode","payload":"G28"}`, "s3cret")
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestAPI_CommandsNeedToken(t *testing.T) {
+	a, ps := newTestAPI(t, "")
+
+	for _, body := range []string{
+		`{"command":"light","payload":"ON"}`,
+		`{"command":"gcode","payload":"G28","force":true}`,
+	} {
+		rec := do(a, http.MethodPost, "/api/printers/k1/commands", body, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Contains(t, rec.Body.String(), "commands need an api_token")
+	}
+	assert.Empty(t, ps.messages())
+}
+
+func TestAPI_CommandContentType(t *testing.T) {
+	a, ps := newTestAPI(t, "s3cret")
+
+	tests := []struct {
+		name        string
+		contentType string
+		wantCode    int
+	}{
+		{"none", "", http.StatusUnsupportedMediaType},
+		{"cross-site text", "text/plain", http.StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType},
+		{"json with charset", "application/json; charset=utf-8", http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/printers/k1/commands", strings.NewReader(`{"command":"light","payload":"ON"}`))
+			req.Header.Set("Authorization", "Bearer s3cret")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			a.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestAPI_BearerToken(t *testing.T) {
+	a, _ := newTestAPI(t, "s3cret")
+
+	rec := do(a, http.MethodGet, "/api/printers", "", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="creality2mqtt"`, rec.Header().Get("WWW-Authenticate"))
+
+	rec = do(a, http.MethodGet, "/api/printers", "", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = do(a, http.MethodGet, "/api/printers", "", "s3cret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// The OpenAPI document stays public
+	rec = do(a, http.MethodGet, "/api/openapi.yaml", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,243 @@
+package bridge
+
+import (
+	"context"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Bridge runs several printers over one shared MQTT connection
+type Bridge struct {
+	mqtt Publisher
+
+	commands *commandSettings
+
+	mu              sync.Mutex
+	discoveryPrefix string
+	willTopic       string
+	printers        []*Printer
+	// running printers and how to stop them, set once Run started
+	ctx     context.Context
+	running map[*Printer]*run
+	wg      sync.WaitGroup
+}
+
+// run is a printer running in the background
+type run struct {
+	cancel context.CancelFunc
+	// closed once the printer's Run returned
+	done chan struct{}
+}
+
+// New creates a Bridge with one Printer per config, all sharing the given publisher
+func New(pub Publisher, discoveryPrefix string, printers []PrinterConfig) *Bridge {
+	b := &Bridge{
+		mqtt:            pub,
+		discoveryPrefix: discoveryPrefix,
+		running:         map[*Printer]*run{},
+		commands:        &commandSettings{},
+	}
+	for _, cfg := range printers {
+		b.printers = append(b.printers, b.newPrinter(cfg, discoveryPrefix))
+	}
+	return b
+}
+
+func (b *Bridge) newPrinter(cfg PrinterConfig, discoveryPrefix string) *Printer {
+	p := NewPrinter(cfg, discoveryPrefix, b.mqtt)
+	p.commands = b.commands
+	p.willTopic = b.willTopic
+	return p
+}
+
+// SetWillTopic sets the Last Will topic of the MQTT connection. The discovery
+// availability of every printer follows it, so the entities go unavailable
+// when the bridge dies.
+func (b *Bridge) SetWillTopic(topic string) {
+	b.mu.Lock()
+	b.willTopic = topic
+	b.mu.Unlock()
+	for _, p := range b.Printers() {
+		p.SetWillTopic(topic)
+	}
+}
+
+// SetCommandsConfig replaces the command settings of every printer
+func (b *Bridge) SetCommandsConfig(cfg config.CommandsConfig) {
+	b.commands.set(cfg)
+	// The target temperature controls follow the configured limits
+	for _, p := range b.Printers() {
+		p.updateTempLimits()
+	}
+}
+
+// Printers returns the bridged printers
+func (b *Bridge) Printers() []*Printer {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]*Printer(nil), b.printers...)
+}
+
+// Printer returns the printer with the given name or device ID, or nil
+func (b *Bridge) Printer(id string) *Printer {
+	for _, p := range b.Printers() {
+		if p.Name() == id {
+			return p
+		}
+	}
+	for _, p := range b.Printers() {
+		if s := p.Status(); s.DeviceID != "" && s.DeviceID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// PublishDiscovery republishes discovery messages for every printer
+func (b *Bridge) PublishDiscovery() {
+	for _, p := range b.Printers() {
+		p.PublishDiscovery()
+	}
+}
+
+// PublishAvailability republishes the availability of every printer, which
+// follows its WebSocket connection
+func (b *Bridge) PublishAvailability() {
+	for _, p := range b.Printers() {
+		p.PublishAvailability(p.availability())
+	}
+}
+
+// Status returns the status of every printer
+func (b *Bridge) Status() []Status {
+	printers := b.Printers()
+	out := make([]Status, 0, len(printers))
+	for _, p := range printers {
+		out = append(out, p.Status())
+	}
+	return out
+}
+
+func (b *Bridge) haStatusTopic() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return types.NewTopicBuilder("", b.discoveryPrefix).HAStatus()
+}
+
+func (b *Bridge) subscribeHAStatus(topic string) {
+	// Subscribe to Home Assistant status to republish discovery on HA restart
+	err := b.mqtt.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+		payload := string(msg.Payload())
+		log.Debug("Home Assistant status changed", "status", payload)
+		if payload == "online" {
+			log.Info("Home Assistant came online, republishing discovery")
+			b.PublishDiscovery()
+		}
+	})
+	if err != nil {
+		log.Warn("Failed to subscribe to HA status", "error", err)
+	}
+}
+
+// Run starts every printer and blocks until all of them have stopped
+func (b *Bridge) Run(ctx context.Context) {
+	b.subscribeHAStatus(b.haStatusTopic())
+
+	b.mu.Lock()
+	b.ctx = ctx
+	for _, p := range b.printers {
+		b.start(p)
+	}
+	b.mu.Unlock()
+
+	<-ctx.Done()
+	b.wg.Wait()
+}
+
+// start runs a printer in the background. b.mu must be held.
+func (b *Bridge) start(p *Printer) {
+	ctx, cancel := context.WithCancel(b.ctx)
+	r := &run{cancel: cancel, done: make(chan struct{})}
+	b.running[p] = r
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer close(r.done)
+		if err := p.Run(ctx); err != nil && err != context.Canceled {
+			log.Error("WebSocket error", "printer", p.Name(), "error", err)
+		}
+	}()
+}
+
+// Apply reconciles the running printers with a new set of printer configs.
+//
+// Printers are matched by name: new ones are started, missing ones are stopped
+// and existing ones are reconfigured in place (see Printer.Reconfigure).
+// Removed printers have stopped by the time Apply returns.
+func (b *Bridge) Apply(printers []PrinterConfig, discoveryPrefix string) {
+	b.mu.Lock()
+	oldPrefix := b.discoveryPrefix
+	b.discoveryPrefix = discoveryPrefix
+
+	existing := map[string]*Printer{}
+	for _, p := range b.printers {
+		existing[p.Name()] = p
+	}
+
+	var next, reconfigure []*Printer
+	var configs []PrinterConfig
+	for _, cfg := range printers {
+		if p, ok := existing[cfg.Name]; ok {
+			delete(existing, cfg.Name)
+			next = append(next, p)
+			reconfigure = append(reconfigure, p)
+			configs = append(configs, cfg)
+			continue
+		}
+		p := b.newPrinter(cfg, discoveryPrefix)
+		next = append(next, p)
+		log.Info("Adding printer", "printer", cfg.Name, "url", cfg.WSURL)
+		if b.ctx != nil {
+			b.start(p)
+		}
+	}
+	var stopped []*run
+	for name, p := range existing {
+		log.Info("Removing printer", "printer", name)
+		if r, ok := b.running[p]; ok {
+			r.cancel()
+			stopped = append(stopped, r)
+			delete(b.running, p)
+		}
+	}
+	b.printers = next
+	running := b.ctx != nil
+	b.mu.Unlock()
+
+	// The metrics are only forgotten once the printer stopped, as a frame
+	// still being handled would bring its series back
+	for _, r := range stopped {
+		<-r.done
+	}
+	for name, p := range existing {
+		p.expireQueue()
+		metrics.ForgetPrinter(name, p.Status().DeviceID)
+	}
+
+	for i, p := range reconfigure {
+		p.Reconfigure(configs[i], discoveryPrefix)
+	}
+
+	if discoveryPrefix != oldPrefix && running {
+		oldTopic := types.NewTopicBuilder("", oldPrefix).HAStatus()
+		if err := b.mqtt.Unsubscribe(oldTopic); err != nil {
+			log.Warn("Failed to unsubscribe from HA status", "error", err)
+		}
+		b.subscribeHAStatus(types.NewTopicBuilder("", discoveryPrefix).HAStatus())
+	}
+}
@@ -0,0 +1,367 @@
+package bridge
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	"github.com/davidcollom/creality2mqtt/internal/wsclient"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePublisher records published messages and subscriptions
+type fakePublisher struct {
+	mu        sync.Mutex
+	published []types.MqttMessage
+	subs      map[string]mqtt.MessageHandler
+}
+
+func newFakePublisher() *fakePublisher {
+	return &fakePublisher{subs: map[string]mqtt.MessageHandler{}}
+}
+
+func (f *fakePublisher) Publish(topic, payload string, retain bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = append(f.published, types.MqttMessage{Topic: topic, Payload: payload, Retain: retain})
+}
+
+func (f *fakePublisher) PublishNow(topic, payload string) {
+	f.Publish(topic, payload, false)
+}
+
+func (f *fakePublisher) Subscribe(topic string, handler mqtt.MessageHandler) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subs[topic] = handler
+	return nil
+}
+
+func (f *fakePublisher) Unsubscribe(topic string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subs, topic)
+	return nil
+}
+
+func (f *fakePublisher) subscribed(topic string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.subs[topic]
+	return ok
+}
+
+func (f *fakePublisher) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.published = nil
+}
+
+func (f *fakePublisher) topics() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	m := map[string]string{}
+	for _, msg := range f.published {
+		m[msg.Topic] = msg.Payload
+	}
+	return m
+}
+
+// payloads returns every payload published on a topic, oldest first
+func (f *fakePublisher) payloads(topic string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var out []string
+	for _, msg := range f.published {
+		if msg.Topic == topic {
+			out = append(out, msg.Payload)
+		}
+	}
+	return out
+}
+
+func TestBridge_PrintersShareOnePublisher(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "homeassistant", []PrinterConfig{
+		{Name: "k1a", WSURL: "ws://10.0.0.5:9999/", BaseTopic: "creality/k1a"},
+		{Name: "k1b", WSURL: "ws://10.0.0.6:9999/", BaseTopic: "creality/k1b"},
+	})
+	require.Len(t, b.Printers(), 2)
+
+	b.Printers()[0].HandleMessage([]byte(`{"deviceId":"k1-a","nozzleTemp":"200.0"}`))
+	b.Printers()[1].HandleMessage([]byte(`{"deviceId":"k1-b","nozzleTemp":"180.0"}`))
+
+	tp := pub.topics()
+	assert.Equal(t, "200.000", tp["creality/k1a/temperature/nozzle/current"])
+	assert.Equal(t, "180.000", tp["creality/k1b/temperature/nozzle/current"])
+
+	// Each printer gets its own device ID and discovery set
+	assert.Contains(t, tp, "homeassistant/sensor/k1_a/printer_status/config")
+	assert.Contains(t, tp, "homeassistant/sensor/k1_b/printer_status/config")
+	assert.Contains(t, tp["homeassistant/sensor/k1_b/printer_status/config"], `"state_topic":"creality/k1b/printer_status"`)
+}
+
+func TestBridge_DiscoveryAvailabilityFollowsTheLastWill(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{{Name: "k1", WSURL: "ws://10.0.0.5:9999/", BaseTopic: "creality/k1"}})
+	b.SetWillTopic("creality/status")
+	p := b.Printers()[0]
+
+	p.HandleMessage([]byte(`{"deviceId":"dev","boxState":{"id":1,"humidity":30}}`))
+	wantAvailability := `"availability":[` +
+		`{"topic":"creality/status","payload_available":"online","payload_not_available":"offline"},` +
+		`{"topic":"creality/k1/status","payload_available":"online","payload_not_available":"offline"}],` +
+		`"availability_mode":"all"`
+	tp := pub.topics()
+	assert.Contains(t, tp["ha/sensor/dev/printer_status/config"], wantAvailability)
+	assert.Contains(t, tp["ha/sensor/dev/cfs_1_humidity/config"], wantAvailability)
+
+	// A new Last Will topic republishes discovery
+	pub.reset()
+	b.SetWillTopic("bridge/status")
+	assert.Contains(t, pub.topics()["ha/sensor/dev/printer_status/config"], `{"topic":"bridge/status",`)
+}
+
+func TestPrinter_CFSDiscoveryPublishedOnce(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://10.0.0.5:9999/", BaseTopic: "bt"}, "ha", pub)
+
+	frame := []byte(`{"deviceId":"dev","boxState":{"id":1,"humidity":30,"temp":22}}`)
+	p.HandleMessage(frame)
+	p.HandleMessage(frame)
+
+	count := 0
+	for _, m := range pub.published {
+		if m.Topic == "ha/sensor/dev/cfs_1_humidity/config" {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count)
+}
+
+func TestPrinter_TargetTempNumbersFollowReportedLimits(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://10.0.0.5:9999/", BaseTopic: "bt"}, "ha", pub)
+
+	p.HandleMessage([]byte(`{"deviceId":"dev","maxNozzleTemp":300}`))
+	tp := pub.topics()
+	assert.Contains(t, tp["ha/number/dev/nozzle_temp_setpoint/config"], `"max":300`)
+	assert.Contains(t, tp["ha/number/dev/nozzle_temp_setpoint/config"], `"command_topic":"bt/temperature/nozzle/target/set"`)
+	assert.Contains(t, tp["ha/number/dev/bed_temp_setpoint/config"], `"max":100`)
+
+	// A delta without the limits keeps them, a changed limit republishes the numbers
+	pub.reset()
+	p.HandleMessage([]byte(`{"nozzleTemp":"25.0"}`))
+	assert.NotContains(t, pub.topics(), "ha/number/dev/nozzle_temp_setpoint/config")
+	p.HandleMessage([]byte(`{"maxBedTemp":115}`))
+	tp = pub.topics()
+	assert.Contains(t, tp["ha/number/dev/bed_temp_setpoint/config"], `"max":115`)
+	assert.Contains(t, tp["ha/number/dev/nozzle_temp_setpoint/config"], `"max":300`)
+	assert.NotContains(t, tp, "ha/sensor/dev/printer_status/config")
+}
+
+func TestPrinter_ReconfigureMovesTopicsAndRefreshesDiscovery(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "old"}, "ha", pub)
+	p.subscribeCommands(p.Topics())
+	p.HandleMessage([]byte(`{"deviceId":"dev"}`))
+	wsBefore := p.ws
+	pub.reset()
+
+	p.Reconfigure(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "new", DeviceName: "Renamed"}, "ha")
+
+	assert.False(t, pub.subscribed("old/light_sw/set"))
+	assert.True(t, pub.subscribed("new/light_sw/set"))
+	tp := pub.topics()
+	assert.Equal(t, "offline", tp["old/status"])
+	// The new topic follows the WebSocket, which never connected here
+	assert.Equal(t, "offline", tp["new/status"])
+	assert.Contains(t, tp["ha/sensor/dev/printer_status/config"], `"state_topic":"new/printer_status"`)
+	assert.Contains(t, tp["ha/sensor/dev/printer_status/config"], `"name":"Renamed"`)
+	// Same URL: the WebSocket client is kept
+	assert.Same(t, wsBefore, p.ws)
+}
+
+func TestPrinter_AvailabilityFollowsWebSocket(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev"}`)
+	pub := newFakePublisher()
+	// Left retained by a previous run
+	pub.Publish("bt/status", "online", true)
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+
+	// Offline until the printer is reached, whatever was retained before
+	runPrinter(t, p)
+	assert.Equal(t, []string{"online", "offline", "online"}, pub.payloads("bt/status"))
+
+	// The printer drops the connection, e.g. on reboot, and the client reconnects
+	ps.mu.Lock()
+	_ = ps.conn.Close()
+	ps.mu.Unlock()
+	require.Eventually(t, func() bool { return len(pub.payloads("bt/status")) == 5 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"online", "offline", "online", "offline", "online"}, pub.payloads("bt/status"))
+}
+
+func TestPrinter_ReconfigureURLReplacesWebSocket(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	wsBefore := p.ws
+	pub.reset()
+
+	p.Reconfigure(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:2/", BaseTopic: "bt"}, "ha")
+
+	assert.NotSame(t, wsBefore, p.ws)
+	// Nothing moved, so availability is not touched
+	assert.NotContains(t, pub.topics(), "bt/status")
+}
+
+func TestPrinter_ReconfigureDiscoveryPrefixRemovesOldConfigs(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	p.HandleMessage([]byte(`{"deviceId":"dev"}`))
+	pub.reset()
+
+	p.Reconfigure(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "homeassistant")
+
+	tp := pub.topics()
+	assert.Equal(t, "", tp["ha/sensor/dev/printer_status/config"])
+	assert.NotEmpty(t, tp["homeassistant/sensor/dev/printer_status/config"])
+}
+
+func TestBridge_Apply(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{
+		{Name: "a", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/a"},
+		{Name: "b", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/b"},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	require.Eventually(t, func() bool { return pub.subscribed("bt/b/light_sw/set") }, time.Second, 10*time.Millisecond)
+	a := b.Printers()[0]
+
+	b.Apply([]PrinterConfig{
+		{Name: "a", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/a"},
+		{Name: "c", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/c"},
+	}, "ha")
+
+	printers := b.Printers()
+	require.Len(t, printers, 2)
+	assert.Same(t, a, printers[0], "unchanged printer must keep running as-is")
+	assert.Equal(t, "c", printers[1].Name())
+	require.Eventually(t, func() bool { return pub.subscribed("bt/c/light_sw/set") }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return !pub.subscribed("bt/b/light_sw/set") }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, "offline", pub.topics()["bt/b/status"])
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("bridge did not stop")
+	}
+}
+
+func TestBridge_ApplyStopsRemovedPrinters(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev-gone"}`)
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{
+		{Name: "gone", WSURL: ps.wsURL(), BaseTopic: "bt/gone"},
+		{Name: "queued", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"},
+	})
+	b.SetCommandsConfig(config.CommandsConfig{QueueTTL: config.Duration(time.Minute)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		b.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	gone, queued := b.Printers()[0], b.Printers()[1]
+	require.Eventually(t, func() bool { return gone.Status().DiscoveryPublished }, 2*time.Second, 10*time.Millisecond)
+	id, err := queued.Command("light", "ON")
+	require.ErrorIs(t, err, ErrQueued)
+
+	b.Apply(nil, "ha")
+
+	// Both have stopped, so their series are gone for good
+	assert.False(t, gone.Status().WSConnected)
+	assert.False(t, metrics.FramesReceived.DeleteLabelValues("gone"))
+	assert.False(t, metrics.WSQueuedCommands.DeleteLabelValues("queued"))
+	// The queued command is not left waiting for a printer that is gone
+	results := commandResults(t, pub)
+	require.NotEmpty(t, results)
+	assert.Equal(t, CommandResult{ID: id, Command: "light", Payload: "ON", Status: ResultExpired, Error: wsclient.ErrExpired.Error()}, results[len(results)-1])
+}
+
+func TestBridge_Status(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{
+		{Name: "a", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/a"},
+		{Name: "b", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/b"},
+	})
+	b.Printers()[0].HandleMessage([]byte(`{"deviceId":"dev-a"}`))
+
+	assert.Equal(t, []Status{
+		{Name: "a", DeviceID: "dev_a", DiscoveryPublished: true},
+		{Name: "b"},
+	}, b.Status())
+
+	// Moving discovery to another prefix republishes it
+	b.Printers()[0].Reconfigure(PrinterConfig{Name: "a", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/a"}, "homeassistant")
+	assert.True(t, b.Printers()[0].Status().DiscoveryPublished)
+}
+
+func TestPrinter_Metrics(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "metrics", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+
+	p.HandleMessage([]byte(`{"deviceId":"dev-metrics","nozzleTemp":"210.0"}`))
+	p.HandleMessage([]byte(`not json`))
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.FramesReceived.WithLabelValues("metrics")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DecodeErrors.WithLabelValues("metrics")))
+	assert.Equal(t, 210.0, testutil.ToFloat64(metrics.Temperature.WithLabelValues("dev_metrics", "nozzle", "current")))
+}
+
+func TestPrinter_ValuesAndEntities(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	assert.Empty(t, p.Entities())
+
+	p.HandleMessage([]byte(`{"deviceId":"dev","nozzleTemp":"200.0","boxState":{"id":1,"humidity":30}}`))
+	p.HandleMessage([]byte(`{"nozzleTemp":"201.0"}`))
+
+	values := map[string]string{}
+	for _, v := range p.Values() {
+		values[v.Topic] = v.Payload
+	}
+	assert.Equal(t, "201.000", values["bt/temperature/nozzle/current"])
+	// The state document carries the merged state of both frames
+	assert.JSONEq(t, `{"temperatures":{"nozzle":{"current":201},"bed":{}},"job":{"state":"idle"},"fans":{},"cfs":[{"id":1,"humidity":30}]}`, values["bt/state"])
+
+	ids := map[string]string{}
+	for _, e := range p.Entities() {
+		ids[e.UniqueID] = e.Component
+	}
+	assert.Equal(t, "sensor", ids["dev_printer_status"])
+	assert.Equal(t, "switch", ids["dev_light"])
+	assert.Equal(t, "sensor", ids["dev_cfs_1_humidity"])
+
+	// Values of the old base topic are dropped when it moves
+	p.Reconfigure(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "moved"}, "ha")
+	assert.Empty(t, p.Values())
+}
@@ -0,0 +1,820 @@
+package bridge
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/discovery"
+	"github.com/davidcollom/creality2mqtt/internal/mapper"
+	"github.com/davidcollom/creality2mqtt/internal/state"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	"github.com/davidcollom/creality2mqtt/internal/wsclient"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	// ErrUnknownCommand is returned for a command name that is not registered
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrInvalidPayload is returned when a command payload cannot be converted
+	ErrInvalidPayload = errors.New("invalid payload")
+	// ErrNotAllowed is returned when a command is refused by the configuration
+	ErrNotAllowed = errors.New("not allowed")
+	// ErrNotConfirmed is reported when the printer does not apply a sent change
+	ErrNotConfirmed = errors.New("not confirmed by the printer")
+	// ErrJobState is returned when a command does not apply to the current print job state
+	ErrJobState = errors.New("not possible in the current job state")
+	// ErrQueued is returned when a command waits for the printer to reconnect
+	ErrQueued = errors.New("queued until the printer reconnects")
+)
+
+// Command result statuses published on <base>/command/result
+const (
+	// ResultSent is published once a command is written to the printer
+	ResultSent = "sent"
+	// ResultConfirmed is published when the printer reports the expected change
+	ResultConfirmed = "confirmed"
+	// ResultRejected is published when the bridge refuses a command or the
+	// printer only reports a different value until the timeout
+	ResultRejected = "rejected"
+	// ResultTimeout is published when no frame reports the change in time
+	ResultTimeout = "timeout"
+	// ResultFailed is published when a command cannot be sent to the printer
+	ResultFailed = "failed"
+	// ResultReplaced is published when a newer command for the same field is
+	// sent before the printer reported the change
+	ResultReplaced = "replaced"
+	// ResultQueued is published when a command waits for the printer to reconnect
+	ResultQueued = "queued"
+	// ResultExpired is published when a queued command is dropped because the
+	// printer did not reconnect within commands.queue_ttl
+	ResultExpired = "expired"
+)
+
+// CommandResult is the outcome of a command, published on <base>/command/result.
+// A command with a printer field to confirm gets a second result after "sent",
+// a queued command gets "sent" or "expired" after "queued".
+type CommandResult struct {
+	ID      string `json:"id"`
+	Command string `json:"command"`
+	Payload string `json:"payload"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	// State holds the printer fields observed after the command
+	State map[string]any `json:"state,omitempty"`
+	Time  time.Time      `json:"time"`
+}
+
+// commandSettings are the command settings shared by the printers of a bridge
+type commandSettings struct {
+	mu  sync.RWMutex
+	cfg config.CommandsConfig
+}
+
+func (s *commandSettings) get() config.CommandsConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cfg
+}
+
+func (s *commandSettings) set(cfg config.CommandsConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+}
+
+// command converts the payload of an inbound command into a printer WebSocket message.
+// Every command is available on its MQTT command topic and through Printer.Command.
+type command struct {
+	name  string
+	topic func(*types.TopicBuilder) string
+	build func(p *Printer, payload string) ([]byte, error)
+	// optimistic returns the state to publish once the command is sent, until
+	// the next frame from the printer reports the actual value. Optional.
+	optimistic func(topics *types.TopicBuilder, payload string) []types.MqttMessage
+	// confirm returns the printer field and value the next frame carrying the
+	// field should report once the command is applied. Commands without it
+	// (raw set params, G-code) have no reply to wait for. Optional.
+	confirm func(payload string) (expectedChange, bool)
+	// result is called with the outcome of every attempt (nil once sent) and,
+	// for commands with collectFrames, the frames that followed it. Optional.
+	result func(p *Printer, payload string, frames *frameCollector, err error)
+	// collectFrames commands gather the frames the printer sends after them
+	collectFrames bool
+	// interlocked commands (motion, G-code) are refused while printing unless forced
+	interlocked bool
+	// limit returns the highest value accepted for a heater target. Optional.
+	limit func(p *Printer) float64
+	// heaterGcode commands take G-code whose heater targets are held to the
+	// same limits as the target commands
+	heaterGcode bool
+	// noQueue commands (job actions, G-code) fail while the printer is
+	// disconnected instead of waiting for it
+	noQueue bool
+}
+
+var commands = []command{
+	{name: "light", topic: (*types.TopicBuilder).LightCommand, build: buildLightCommand, confirm: confirmLight},
+	{name: "set", topic: (*types.TopicBuilder).CommandSet, build: buildSetCommand},
+	{name: "nozzle_target", topic: (*types.TopicBuilder).NozzleTargetCommand, build: buildNozzleTargetCommand, confirm: confirmTarget("targetNozzleTemp"), limit: nozzleLimit},
+	{name: "bed_target", topic: (*types.TopicBuilder).BedTargetCommand, build: buildBedTargetCommand, confirm: confirmTarget("targetBedTemp0"), limit: bedLimit},
+	fanCommand("model", 0),
+	fanCommand("auxiliary", 2),
+	fanCommand("case", 1),
+	{name: "pause", topic: jobTopic("pause"), build: buildPauseCommand, confirm: confirmPrintState(mapper.PrintStatePaused), noQueue: true},
+	{name: "resume", topic: jobTopic("resume"), build: buildResumeCommand, confirm: confirmPrintState(mapper.PrintStatePrinting), noQueue: true},
+	{name: "cancel", topic: jobTopic("cancel"), build: buildCancelCommand, confirm: confirmPrintState(mapper.PrintStateStopped), noQueue: true},
+	tuningCommand("feedrate", "curFeedratePct", `"setFeedratePct":%s`),
+	tuningCommand("flowrate", "curFlowratePct", `"setFlowratePct":%s`),
+	tuningCommand("pressure_advance", "pressureAdvance", `"gcodeCmd":"SET_PRESSURE_ADVANCE ADVANCE=%s"`),
+	tuningCommand("velocity_limit", "velocityLimits", `"gcodeCmd":"SET_VELOCITY_LIMIT VELOCITY=%s"`),
+	{name: "gcode", topic: (*types.TopicBuilder).GcodeSend, build: buildGcodeCommand, result: publishGcodeResponse, collectFrames: true, interlocked: true, heaterGcode: true, noQueue: true},
+}
+
+// Commands returns the names of the supported commands
+func Commands() []string {
+	names := make([]string, 0, len(commands))
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	return names
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// Command runs a command by name with the same payload its MQTT command topic accepts.
+// It returns the correlation ID of the results published for the command.
+func (p *Printer) Command(name, payload string) (string, error) {
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownCommand, name)
+	}
+	return p.runCommand(cmd, payload, false)
+}
+
+// ForceCommand runs a command like Command, also while printing
+func (p *Printer) ForceCommand(name, payload string) (string, error) {
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownCommand, name)
+	}
+	return p.runCommand(cmd, payload, true)
+}
+
+func (p *Printer) runCommand(cmd command, payload string, force bool) (id string, err error) {
+	id = newCommandID()
+	var frames *frameCollector
+	if cmd.result != nil {
+		defer func() { cmd.result(p, payload, frames, err) }()
+	}
+	name := p.Name()
+	log.Info("Received command", "printer", name, "command", cmd.name, "payload", payload, "id", id, "force", force)
+
+	allowed, err := p.checkPolicy(cmd, payload, force)
+	if err != nil {
+		log.Warn("Command refused", "printer", name, "command", cmd.name, "error", err)
+		p.publishResult(CommandResult{ID: id, Command: cmd.name, Payload: payload, Status: ResultRejected, Error: err.Error()})
+		return id, err
+	}
+	payload = allowed
+
+	msg, err := cmd.build(p, payload)
+	if err == nil {
+		err = p.checkRate(cmd)
+	}
+	if err != nil {
+		log.Warn("Command refused", "printer", name, "command", cmd.name, "payload", payload, "error", err)
+		p.publishResult(CommandResult{ID: id, Command: cmd.name, Payload: payload, Status: ResultRejected, Error: err.Error()})
+		return id, err
+	}
+
+	var watch *changeWatch
+	if cmd.confirm != nil {
+		if change, ok := cmd.confirm(payload); ok {
+			watch = &changeWatch{expectedChange: change}
+		}
+	}
+	result := CommandResult{ID: id, Command: cmd.name, Payload: payload}
+	var reply *wsclient.Reply
+	out := wsclient.Outgoing{
+		Data: msg,
+		TTL:  p.queueTTL(cmd),
+		// Wait for the reply before sending, the printer may answer straight away.
+		// A queued command only waits once it is replayed, so the full frame
+		// sent on reconnect is not taken for its reply.
+		BeforeWrite: func() {
+			if watch != nil {
+				reply = p.expectChange(watch)
+			}
+			if cmd.collectFrames {
+				frames = p.collectFrames()
+			}
+		},
+	}
+	out.Done = func(err error) { _ = p.commandWritten(cmd, msg, result, watch, reply, err) }
+
+	err = p.send(out)
+	if errors.Is(err, wsclient.ErrQueued) {
+		log.Info("Printer not connected, command queued", "printer", name, "command", cmd.name, "id", id, "ttl", out.TTL)
+		result.Status = ResultQueued
+		p.publishResult(result)
+		return id, ErrQueued
+	}
+	return id, p.commandWritten(cmd, msg, result, watch, reply, err)
+}
+
+// commandWritten publishes the outcome of writing a command to the printer,
+// straight away or replayed from the queue, and waits for the printer to
+// report the change
+func (p *Printer) commandWritten(cmd command, msg []byte, result CommandResult, watch *changeWatch, reply *wsclient.Reply, err error) error {
+	name := p.Name()
+	if err != nil {
+		if reply != nil {
+			p.dropChange(watch.field, reply)
+		}
+		if errors.Is(err, wsclient.ErrExpired) {
+			log.Warn("Queued command expired before the printer reconnected", "printer", name, "command", cmd.name, "id", result.ID)
+			result.Status, result.Error = ResultExpired, err.Error()
+		} else {
+			log.Error("Failed to send command to printer", "printer", name, "command", cmd.name, "error", err)
+			err = fmt.Errorf("send to printer: %w", err)
+			result.Status, result.Error = ResultFailed, err.Error()
+		}
+		p.publishResult(result)
+		return err
+	}
+	log.Info("Sent command to printer", "printer", name, "command", string(msg), "id", result.ID)
+	result.Status = ResultSent
+	p.publishResult(result)
+
+	if reply != nil {
+		go p.awaitChange(result, watch, reply)
+	}
+	if cmd.optimistic != nil {
+		msgs := cmd.optimistic(p.Topics(), result.Payload)
+		p.recordValues(msgs)
+		for _, m := range msgs {
+			p.mqtt.PublishNow(m.Topic, m.Payload)
+		}
+	}
+	return nil
+}
+
+// queueTTL returns how long a command may wait for the printer to reconnect
+func (p *Printer) queueTTL(cmd command) time.Duration {
+	if cmd.noQueue {
+		return 0
+	}
+	return time.Duration(p.commands.get().QueueTTL)
+}
+
+// newCommandID returns a random correlation ID for a command
+func newCommandID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// commandTopics returns the MQTT topics of a command. Interlocked commands
+// also listen on <topic>/force, which bypasses the job state interlock.
+func commandTopics(cmd command, topics *types.TopicBuilder) map[string]bool {
+	topic := cmd.topic(topics)
+	out := map[string]bool{topic: false}
+	if cmd.interlocked {
+		out[topic+"/force"] = true
+	}
+	return out
+}
+
+func (p *Printer) subscribeCommands(topics *types.TopicBuilder) {
+	if p.commands.get().ReadOnly {
+		log.Info("Read-only mode, not subscribing to command topics", "printer", p.Name())
+		return
+	}
+	for _, cmd := range commands {
+		for topic, force := range commandTopics(cmd, topics) {
+			err := p.mqtt.Subscribe(topic, func(client mqtt.Client, msg mqtt.Message) {
+				payload := string(msg.Payload())
+				if _, err := p.runCommand(cmd, payload, force); isRejection(err) {
+					p.publishCommandError(cmd.name, payload, err)
+				}
+			})
+			if err != nil {
+				log.Warn("Failed to subscribe to command topic", "printer", p.Name(), "command", cmd.name, "topic", topic, "error", err)
+			}
+		}
+	}
+}
+
+func (p *Printer) unsubscribeCommands(topics *types.TopicBuilder) {
+	if p.commands.get().ReadOnly {
+		return
+	}
+	for _, cmd := range commands {
+		for topic := range commandTopics(cmd, topics) {
+			if err := p.mqtt.Unsubscribe(topic); err != nil {
+				log.Warn("Failed to unsubscribe from command topic", "printer", p.Name(), "command", cmd.name, "topic", topic, "error", err)
+			}
+		}
+	}
+}
+
+// isRejection reports whether a command was refused by the bridge, rather than failing to reach the printer
+func isRejection(err error) bool {
+	return errors.Is(err, ErrInvalidPayload) || errors.Is(err, ErrNotAllowed) || errors.Is(err, ErrJobState) ||
+		errors.Is(err, ErrReadOnly) || errors.Is(err, ErrRateLimited)
+}
+
+// publishCommandError reports a rejected command on <base>/command/error
+func (p *Printer) publishCommandError(name, payload string, cmdErr error) {
+	data, err := json.Marshal(struct {
+		Command string `json:"command"`
+		Payload string `json:"payload"`
+		Error   string `json:"error"`
+	}{name, payload, cmdErr.Error()})
+	if err != nil {
+		log.Error("Failed to encode command error", "printer", p.Name(), "error", err)
+		return
+	}
+	p.mqtt.PublishNow(p.Topics().CommandError(), string(data))
+}
+
+// publishResult publishes the outcome of a command on <base>/command/result
+func (p *Printer) publishResult(r CommandResult) {
+	r.Time = time.Now().UTC()
+	data, err := json.Marshal(r)
+	if err != nil {
+		log.Error("Failed to encode command result", "printer", p.Name(), "error", err)
+		return
+	}
+	p.mqtt.PublishNow(p.Topics().CommandResult(), string(data))
+}
+
+// buildLightCommand maps ON/OFF (or 1/0) to the printer's lightSw param
+func buildLightCommand(_ *Printer, payload string) ([]byte, error) {
+	var lightValue int
+	switch payload {
+	case "ON", "1":
+		lightValue = 1
+	case "OFF", "0":
+		lightValue = 0
+	default:
+		return nil, fmt.Errorf("%w: light expects ON or OFF, got %q", ErrInvalidPayload, payload)
+	}
+	return fmt.Appendf(nil, `{"method":"set","params":{"lightSw":%d}}`, lightValue), nil
+}
+
+// policedSetParams are the set params with a dedicated command, and the
+// command to use instead. The generic set command refuses them even when they
+// are allowlisted, so it can't bypass the heater limits or the G-code policy.
+var policedSetParams = map[string]string{
+	"nozzleTempControl": "nozzle_target",
+	"bedTempControl":    "bed_target",
+	"gcodeCmd":          "gcode",
+}
+
+// buildSetCommand wraps a JSON object of params in the printer's set envelope.
+// Every param must be in the configured allowlist and within its range, and
+// must not have a dedicated command (see policedSetParams).
+func buildSetCommand(p *Printer, payload string) ([]byte, error) {
+	var params map[string]any
+	if err := json.Unmarshal([]byte(payload), &params); err != nil || len(params) == 0 {
+		return nil, fmt.Errorf("%w: set expects a JSON object of params", ErrInvalidPayload)
+	}
+
+	allowlist := p.commands.get().SetAllowlist
+	var problems []string
+	for _, key := range slices.Sorted(maps.Keys(params)) {
+		if cmd, ok := policedSetParams[key]; ok {
+			problems = append(problems, key+": use the "+cmd+" command")
+			continue
+		}
+		r, ok := allowlist[key]
+		if !ok {
+			problems = append(problems, key+": not in the allowlist")
+			continue
+		}
+		if err := r.Check(params[key]); err != nil {
+			problems = append(problems, key+": "+err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotAllowed, strings.Join(problems, "; "))
+	}
+
+	return json.Marshal(map[string]any{"method": "set", "params": params})
+}
+
+// buildNozzleTargetCommand sets the nozzle target temperature in °C
+func buildNozzleTargetCommand(p *Printer, payload string) ([]byte, error) {
+	v, err := parseNumber("nozzle_target", payload, 0, nozzleLimit(p), "°C")
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, `{"method":"set","params":{"nozzleTempControl":%s}}`, v), nil
+}
+
+// buildBedTargetCommand sets the target temperature of the (first) bed in °C
+func buildBedTargetCommand(p *Printer, payload string) ([]byte, error) {
+	v, err := parseNumber("bed_target", payload, 0, bedLimit(p), "°C")
+	if err != nil {
+		return nil, err
+	}
+	return fmt.Appendf(nil, `{"method":"set","params":{"bedTempControl":{"num":0,"val":%s}}}`, v), nil
+}
+
+// parseNumber checks a payload is a number between min and max and returns it
+// formatted for the printer
+func parseNumber(name, payload string, min, max float64, unit string) (string, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return "", fmt.Errorf("%w: %s expects a number in %s, got %q", ErrInvalidPayload, name, unit, payload)
+	}
+	if v < min || v > max {
+		return "", fmt.Errorf("%w: %s must be between %g and %g %s (got %g)", ErrInvalidPayload, name, min, max, unit, v)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64), nil
+}
+
+// fanCommand sets the speed of a fan with M106, where index is the printer's
+// fan number (P0 model, P1 case, P2 auxiliary). The new speed is published
+// optimistically on the fan's percentage topic.
+func fanCommand(fan string, index int) command {
+	name := fan + "_fan"
+	return command{
+		name:  name,
+		topic: func(tb *types.TopicBuilder) string { return tb.FanCommand(fan) },
+		build: func(_ *Printer, payload string) ([]byte, error) {
+			pct, err := parseFanPercentage(name, payload)
+			if err != nil {
+				return nil, err
+			}
+			// M106 takes the speed as 0-255
+			speed := int(math.Round(float64(pct) * 255 / 100))
+			return fmt.Appendf(nil, `{"method":"set","params":{"gcodeCmd":"M106 P%d S%d"}}`, index, speed), nil
+		},
+		optimistic: func(tb *types.TopicBuilder, payload string) []types.MqttMessage {
+			pct, err := parseFanPercentage(name, payload)
+			if err != nil {
+				return nil
+			}
+			return []types.MqttMessage{{Topic: tb.FanPercentage(fan), Payload: strconv.Itoa(pct)}}
+		},
+		confirm: func(payload string) (expectedChange, bool) {
+			pct, err := parseFanPercentage(name, payload)
+			// The printer reports the 0-255 speed as a rounded percentage
+			return expectedChange{field: fan + "FanPct", want: float64(pct), tolerance: 1}, err == nil
+		},
+	}
+}
+
+// parseFanPercentage accepts ON (full speed), OFF or a percentage from 0 to 100
+func parseFanPercentage(name, payload string) (int, error) {
+	switch payload = strings.TrimSpace(payload); payload {
+	case "ON":
+		return 100, nil
+	case "OFF":
+		return 0, nil
+	}
+	v, err := strconv.ParseFloat(payload, 64)
+	if err != nil || math.IsNaN(v) || v < 0 || v > 100 {
+		return 0, fmt.Errorf("%w: %s expects ON, OFF or a percentage from 0 to 100, got %q", ErrInvalidPayload, name, payload)
+	}
+	return int(math.Round(v)), nil
+}
+
+// confirmLight expects lightSw to report the new light state
+func confirmLight(payload string) (expectedChange, bool) {
+	switch payload {
+	case "ON", "1":
+		return expectedChange{field: "lightSw", want: 1}, true
+	case "OFF", "0":
+		return expectedChange{field: "lightSw", want: 0}, true
+	}
+	return expectedChange{}, false
+}
+
+// confirmTarget expects a target temperature field to report the new target
+func confirmTarget(field string) func(string) (expectedChange, bool) {
+	return func(payload string) (expectedChange, bool) {
+		v, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+		return expectedChange{field: field, want: v, tolerance: 0.5}, err == nil
+	}
+}
+
+// confirmPrintState expects the printer's "state" code to change to state
+func confirmPrintState(state int) func(string) (expectedChange, bool) {
+	return func(string) (expectedChange, bool) {
+		return expectedChange{field: "state", want: float64(state)}, true
+	}
+}
+
+func jobTopic(action string) func(*types.TopicBuilder) string {
+	return func(tb *types.TopicBuilder) string { return tb.JobCommand(action) }
+}
+
+// checkJobState refuses a job command unless the printer's job is in one of the given states
+func checkJobState(p *Printer, name string, allowed ...string) error {
+	state := mapper.JobState(p.State())
+	if !slices.Contains(allowed, state) {
+		return fmt.Errorf("%w: %s needs a %s job, the printer is %s", ErrJobState, name, strings.Join(allowed, " or "), state)
+	}
+	return nil
+}
+
+// buildPauseCommand pauses a printing job
+func buildPauseCommand(p *Printer, _ string) ([]byte, error) {
+	if err := checkJobState(p, "pause", mapper.JobPrinting); err != nil {
+		return nil, err
+	}
+	return []byte(`{"method":"set","params":{"pause":1}}`), nil
+}
+
+// buildResumeCommand resumes a paused job
+func buildResumeCommand(p *Printer, _ string) ([]byte, error) {
+	if err := checkJobState(p, "resume", mapper.JobPaused); err != nil {
+		return nil, err
+	}
+	return []byte(`{"method":"set","params":{"pause":0}}`), nil
+}
+
+// buildCancelCommand stops a printing or paused job. The payload must be the
+// confirm payload so a stray message cannot cancel a print.
+func buildCancelCommand(p *Printer, payload string) ([]byte, error) {
+	if payload != discovery.CancelConfirmPayload {
+		return nil, fmt.Errorf("%w: cancel must be confirmed with %q", ErrInvalidPayload, discovery.CancelConfirmPayload)
+	}
+	if err := checkJobState(p, "cancel", mapper.JobPrinting, mapper.JobPaused); err != nil {
+		return nil, err
+	}
+	return []byte(`{"method":"set","params":{"stop":1}}`), nil
+}
+
+// tuningCommand changes a print tuning setting within the range of its
+// discovery.TuningControl. param is the printer set param, formatted with the value.
+func tuningCommand(name, key, param string) command {
+	ctrl, ok := discovery.LookupTuningControl(key)
+	if !ok {
+		panic("unknown tuning control " + key)
+	}
+	return command{
+		name:  name,
+		topic: func(tb *types.TopicBuilder) string { return tb.TuningCommand(ctrl.Subtopic) },
+		build: func(_ *Printer, payload string) ([]byte, error) {
+			v, err := parseNumber(name, payload, ctrl.Min, ctrl.Max, ctrl.Unit)
+			if err != nil {
+				return nil, err
+			}
+			return fmt.Appendf(nil, `{"method":"set","params":{`+param+`}}`, v), nil
+		},
+		confirm: func(payload string) (expectedChange, bool) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+			return expectedChange{field: key, want: v, tolerance: ctrl.Step / 2}, err == nil
+		},
+	}
+}
+
+// defaultConfirmTimeout is how long a sent change waits for a frame reporting it
+const defaultConfirmTimeout = 30 * time.Second
+
+// expectedChange is the value a printer field should report once a command is applied
+type expectedChange struct {
+	field     string
+	want      float64
+	tolerance float64
+}
+
+// matches reports whether a reported value is the expected one
+func (c expectedChange) matches(raw any) bool {
+	v, ok := state.FloatValue(raw)
+	return ok && math.Abs(v-c.want) <= c.tolerance
+}
+
+// changeWatch waits for the frame reporting the expected value of a change.
+// Frames reporting another value, such as a periodic status frame sent before
+// the printer applied the change, do not end the wait; the last of them is kept.
+type changeWatch struct {
+	expectedChange
+
+	mu       sync.Mutex
+	other    any
+	hasOther bool
+}
+
+// reported matches a frame reporting the expected value
+func (w *changeWatch) reported(frame map[string]any) bool {
+	raw, ok := frame[w.field]
+	if !ok {
+		return false
+	}
+	if w.matches(raw) {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.other, w.hasOther = raw, true
+	return false
+}
+
+// lastOther returns the last other value reported while waiting, if any
+func (w *changeWatch) lastOther() (any, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.other, w.hasOther
+}
+
+// expectChange waits for the next frame reporting the expected value of a
+// change. A newer command for the same field replaces the pending one.
+func (p *Printer) expectChange(watch *changeWatch) *wsclient.Reply {
+	reply := p.Expect(watch.reported)
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if old, ok := p.pending[watch.field]; ok {
+		old.Cancel()
+	}
+	p.pending[watch.field] = reply
+	return reply
+}
+
+// dropChange stops waiting for a change, unless a newer command replaced it
+func (p *Printer) dropChange(field string, reply *wsclient.Reply) {
+	reply.Cancel()
+	p.pendingMu.Lock()
+	defer p.pendingMu.Unlock()
+	if p.pending[field] == reply {
+		delete(p.pending, field)
+	}
+}
+
+// awaitChange waits for the printer to report a change and publishes the
+// final result of the command. When the wait times out after frames reported
+// another value, the command is rejected and also published on the command
+// error topic. The printer only reports changed fields, so a change nobody
+// reports times out.
+func (p *Printer) awaitChange(result CommandResult, watch *changeWatch, reply *wsclient.Reply) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.confirmTimeout)
+	defer cancel()
+	frame, err := reply.Wait(ctx)
+	p.dropChange(watch.field, reply)
+
+	switch {
+	case errors.Is(err, wsclient.ErrCanceled):
+		log.Debug("Command replaced before the printer reported it", "printer", p.Name(), "command", result.Command)
+		result.Status = ResultReplaced
+	case err != nil:
+		if raw, ok := watch.lastOther(); ok {
+			result.State = map[string]any{watch.field: raw}
+			err = fmt.Errorf("%w: reported %s=%v, expected %g", ErrNotConfirmed, watch.field, raw, watch.want)
+			log.Warn("Printer did not apply command", "printer", p.Name(), "command", result.Command, "error", err)
+			result.Status, result.Error = ResultRejected, err.Error()
+			p.publishCommandError(result.Command, result.Payload, err)
+			break
+		}
+		log.Debug("No frame reported the change", "printer", p.Name(), "command", result.Command, "field", watch.field)
+		result.Status, result.Error = ResultTimeout, fmt.Sprintf("no frame reported %s within %s", watch.field, p.confirmTimeout)
+		// The last value reported before the command, if any
+		if v, ok := p.State()[watch.field]; ok {
+			result.State = map[string]any{watch.field: v}
+		}
+	default:
+		raw := frame[watch.field]
+		result.State = map[string]any{watch.field: raw}
+		log.Info("Printer confirmed command", "printer", p.Name(), "command", result.Command, "field", watch.field, "value", raw)
+		result.Status = ResultConfirmed
+	}
+	p.publishResult(result)
+}
+
+// SplitGcode returns the G-code lines of a payload without comments and blank lines
+func SplitGcode(payload string) []string {
+	var lines []string
+	for _, line := range strings.Split(payload, "\n") {
+		line, _, _ = strings.Cut(line, ";")
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// GcodeMessage checks G-code lines against the denylist and wraps them in the
+// printer's gcodeCmd set param, one command per line
+func GcodeMessage(cfg config.CommandsConfig, payload string) ([]byte, error) {
+	lines := SplitGcode(payload)
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: gcode expects at least one G-code line", ErrInvalidPayload)
+	}
+	for _, line := range lines {
+		if cmd, denied := cfg.DeniedGcode(line); denied {
+			return nil, fmt.Errorf("%w: %s is in the G-code denylist", ErrNotAllowed, cmd)
+		}
+	}
+	return json.Marshal(map[string]any{
+		"method": "set",
+		"params": map[string]string{"gcodeCmd": strings.Join(lines, "\n")},
+	})
+}
+
+func buildGcodeCommand(p *Printer, payload string) ([]byte, error) {
+	return GcodeMessage(p.commands.get(), payload)
+}
+
+// defaultGcodeResponseWindow is how long the frames following sent G-code are
+// collected for its response
+const defaultGcodeResponseWindow = 2 * time.Second
+
+// frameCollector gathers the frames the printer sends after a command
+type frameCollector struct {
+	reply  *wsclient.Reply
+	mu     sync.Mutex
+	frames []map[string]any
+}
+
+// collectFrames starts gathering every frame from the printer until stop
+func (p *Printer) collectFrames() *frameCollector {
+	c := &frameCollector{}
+	c.reply = p.Expect(func(frame map[string]any) bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.frames = append(c.frames, frame)
+		// Never matched, so every frame is seen until the reply is cancelled
+		return false
+	})
+	return c
+}
+
+// stop ends the collection and returns the frames gathered
+func (c *frameCollector) stop() []map[string]any {
+	c.reply.Cancel()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.frames
+}
+
+// publishGcodeResponse reports on <base>/gcode/response whether G-code was
+// rejected by the bridge or failed to reach the printer, straight away, or
+// once the response window has passed that it was sent, with the frames the
+// printer sent in the meantime. The firmware has no reply dedicated to
+// G-code: the frames are whatever it reported, e.g. the position after a G28.
+func publishGcodeResponse(p *Printer, payload string, frames *frameCollector, cmdErr error) {
+	resp := gcodeResponse{Gcode: SplitGcode(payload), Status: "sent"}
+	if resp.Gcode == nil {
+		resp.Gcode = []string{}
+	}
+	if cmdErr != nil {
+		if frames != nil {
+			frames.stop()
+		}
+		resp.Status, resp.Error = "failed", cmdErr.Error()
+		if isRejection(cmdErr) {
+			resp.Status = "rejected"
+		}
+		p.publishGcodeOutcome(resp)
+		return
+	}
+
+	go func() {
+		if frames != nil {
+			time.Sleep(p.gcodeResponseWindow)
+			resp.Frames = frames.stop()
+		}
+		p.publishGcodeOutcome(resp)
+	}()
+}
+
+// gcodeResponse is the payload of <base>/gcode/response
+type gcodeResponse struct {
+	Gcode  []string `json:"gcode"`
+	Status string   `json:"status"`
+	Error  string   `json:"error,omitempty"`
+	// Frames the printer sent within the response window after the G-code
+	Frames []map[string]any `json:"frames,omitempty"`
+}
+
+func (p *Printer) publishGcodeOutcome(resp gcodeResponse) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		log.Error("Failed to encode G-code response", "printer", p.Name(), "error", err)
+		return
+	}
+	p.mqtt.PublishNow(p.Topics().GcodeResponse(), string(data))
+}
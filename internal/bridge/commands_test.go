@@ -0,0 +1,861 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/mqttclient"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// printerServer is a printer WebSocket that sends one frame and records the messages it receives.
+// Further frames are sent with send.
+type printerServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	conn     *websocket.Conn
+	received []string
+}
+
+func newPrinterServer(t *testing.T, frame string) *printerServer {
+	t.Helper()
+	ps := &printerServer{}
+	ps.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		ps.mu.Lock()
+		ps.conn = conn
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		ps.mu.Unlock()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			ps.mu.Lock()
+			ps.received = append(ps.received, string(data))
+			ps.mu.Unlock()
+		}
+	}))
+	t.Cleanup(ps.Close)
+	return ps
+}
+
+func (ps *printerServer) wsURL() string {
+	return "ws" + strings.TrimPrefix(ps.URL, "http")
+}
+
+// send sends a frame to the connected printer client
+func (ps *printerServer) send(t *testing.T, frame string) {
+	t.Helper()
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	require.NotNil(t, ps.conn)
+	require.NoError(t, ps.conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+}
+
+func (ps *printerServer) messages() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return append([]string(nil), ps.received...)
+}
+
+// runPrinter runs the printer until the test ends and waits for its first frame
+func runPrinter(t *testing.T, p *Printer) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = p.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	require.Eventually(t, func() bool {
+		s := p.Status()
+		return s.WSConnected && s.DiscoveryPublished
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// commandErr runs a command and returns only its error
+func commandErr(p *Printer, name, payload string) error {
+	_, err := p.Command(name, payload)
+	return err
+}
+
+func TestBuildLightCommand(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{"ON", `{"method":"set","params":{"lightSw":1}}`, false},
+		{"1", `{"method":"set","params":{"lightSw":1}}`, false},
+		{"OFF", `{"method":"set","params":{"lightSw":0}}`, false},
+		{"0", `{"method":"set","params":{"lightSw":0}}`, false},
+		{"on", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			got, err := buildLightCommand(nil, tt.payload)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidPayload)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestPrinter_Command(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+
+	assert.ErrorIs(t, commandErr(p, "nope", "ON"), ErrUnknownCommand)
+	assert.ErrorIs(t, commandErr(p, "light", "maybe"), ErrInvalidPayload)
+	// Valid, but the printer is not connected
+	err := commandErr(p, "light", "ON")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "send to printer")
+
+	p.subscribeCommands(p.Topics())
+	assert.True(t, pub.subscribed("bt/light_sw/set"))
+	assert.Equal(t, []string{
+		"light", "set", "nozzle_target", "bed_target", "model_fan", "auxiliary_fan", "case_fan",
+		"pause", "resume", "cancel", "feedrate", "flowrate", "pressure_advance", "velocity_limit", "gcode",
+	}, Commands())
+}
+
+func TestBuildSetCommand(t *testing.T) {
+	zero, one, maxSpeed := 0.0, 1.0, 300.0
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", newFakePublisher())
+	p.commands.set(config.CommandsConfig{SetAllowlist: map[string]config.ParamRange{
+		"lightSw":           {Min: &zero, Max: &one},
+		"setFeedratePct":    {Min: &zero, Max: &maxSpeed},
+		"fanCase":           {},
+		"nozzleTempControl": {},
+		"bedTempControl":    {},
+		"gcodeCmd":          {},
+	}})
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		wantErr error
+		errMsg  string
+	}{
+		{
+			name:    "allowed params",
+			payload: `{"lightSw":1,"fanCase":{"num":0,"val":60}}`,
+			want:    `{"method":"set","params":{"fanCase":{"num":0,"val":60},"lightSw":1}}`,
+		},
+		{name: "not json", payload: `ON`, wantErr: ErrInvalidPayload},
+		{name: "empty object", payload: `{}`, wantErr: ErrInvalidPayload},
+		{name: "array", payload: `[1]`, wantErr: ErrInvalidPayload},
+		{
+			name:    "key not allowed",
+			payload: `{"lightSw":1,"cfsAutoRefill":1}`,
+			wantErr: ErrNotAllowed,
+			errMsg:  "cfsAutoRefill: not in the allowlist",
+		},
+		{
+			name:    "out of range",
+			payload: `{"setFeedratePct":350,"lightSw":"1"}`,
+			wantErr: ErrNotAllowed,
+			errMsg:  "lightSw: must be a number; setFeedratePct: must be at most 300 (got 350)",
+		},
+		{
+			// Allowlisted, but set would bypass the heater limits and the G-code policy
+			name:    "params with a dedicated command",
+			payload: `{"nozzleTempControl":500,"bedTempControl":{"num":0,"val":200},"gcodeCmd":"G28"}`,
+			wantErr: ErrNotAllowed,
+			errMsg:  "bedTempControl: use the bed_target command; gcodeCmd: use the gcode command; nozzleTempControl: use the nozzle_target command",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildSetCommand(p, tt.payload)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestBuildTargetTempCommands(t *testing.T) {
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", newFakePublisher())
+
+	tests := []struct {
+		name    string
+		build   func(*Printer, string) ([]byte, error)
+		payload string
+		want    string
+		errMsg  string
+	}{
+		{"nozzle", buildNozzleTargetCommand, "215", `{"method":"set","params":{"nozzleTempControl":215}}`, ""},
+		{"nozzle decimal", buildNozzleTargetCommand, " 210.5 ", `{"method":"set","params":{"nozzleTempControl":210.5}}`, ""},
+		{"nozzle off", buildNozzleTargetCommand, "0.0", `{"method":"set","params":{"nozzleTempControl":0}}`, ""},
+		{"nozzle default limit", buildNozzleTargetCommand, "301", "", "between 0 and 300"},
+		{"nozzle negative", buildNozzleTargetCommand, "-5", "", "between 0 and 300"},
+		{"nozzle not a number", buildNozzleTargetCommand, "hot", "", "expects a number in °C"},
+		{"nozzle NaN", buildNozzleTargetCommand, "NaN", "", "expects a number in °C"},
+		{"bed", buildBedTargetCommand, "60", `{"method":"set","params":{"bedTempControl":{"num":0,"val":60}}}`, ""},
+		{"bed default limit", buildBedTargetCommand, "110", "", "between 0 and 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.build(p, tt.payload)
+			if tt.errMsg != "" {
+				assert.ErrorIs(t, err, ErrInvalidPayload)
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+
+	// Printer-reported limits replace the defaults
+	p.HandleMessage([]byte(`{"deviceId":"dev","maxNozzleTemp":320,"maxBedTemp":"115"}`))
+	_, err := buildNozzleTargetCommand(p, "320")
+	assert.NoError(t, err)
+	_, err = buildBedTargetCommand(p, "110")
+	assert.NoError(t, err)
+	_, err = buildBedTargetCommand(p, "116")
+	assert.ErrorContains(t, err, "between 0 and 115")
+}
+
+func TestFanCommands(t *testing.T) {
+	tests := []struct {
+		command string
+		payload string
+		want    string
+		errMsg  string
+	}{
+		{"model_fan", "ON", `{"method":"set","params":{"gcodeCmd":"M106 P0 S255"}}`, ""},
+		{"model_fan", "OFF", `{"method":"set","params":{"gcodeCmd":"M106 P0 S0"}}`, ""},
+		{"model_fan", "50", `{"method":"set","params":{"gcodeCmd":"M106 P0 S128"}}`, ""},
+		{"case_fan", "100", `{"method":"set","params":{"gcodeCmd":"M106 P1 S255"}}`, ""},
+		{"auxiliary_fan", "33.3", `{"method":"set","params":{"gcodeCmd":"M106 P2 S84"}}`, ""},
+		{"auxiliary_fan", "101", "", "percentage from 0 to 100"},
+		{"case_fan", "fast", "", "percentage from 0 to 100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+"/"+tt.payload, func(t *testing.T) {
+			cmd, ok := lookupCommand(tt.command)
+			require.True(t, ok)
+			got, err := cmd.build(nil, tt.payload)
+			if tt.errMsg != "" {
+				assert.ErrorIs(t, err, ErrInvalidPayload)
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestPrinter_FanCommandPublishesOptimisticState(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","modelFanPct":0}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	runPrinter(t, p)
+	assert.Equal(t, "0", pub.topics()["bt/model_fan_pct"])
+
+	require.NoError(t, commandErr(p, "model_fan", "40"))
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, `{"method":"set","params":{"gcodeCmd":"M106 P0 S102"}}`, ps.messages()[0])
+	assert.Equal(t, "40", pub.topics()["bt/model_fan_pct"])
+
+	// The next frame reports the actual speed
+	p.HandleMessage([]byte(`{"modelFanPct":39}`))
+	assert.Equal(t, "39", pub.topics()["bt/model_fan_pct"])
+
+	// Rejected commands publish no state
+	pub.reset()
+	assert.ErrorIs(t, commandErr(p, "model_fan", "-1"), ErrInvalidPayload)
+	assert.NotContains(t, pub.topics(), "bt/model_fan_pct")
+}
+
+func TestJobCommands(t *testing.T) {
+	idle := `{"deviceId":"dev","state":0}`
+	printing := `{"deviceId":"dev","state":1,"printProgress":40,"printLeftTime":600}`
+	paused := `{"deviceId":"dev","state":5,"printProgress":40,"printLeftTime":600}`
+
+	tests := []struct {
+		name    string
+		frame   string
+		command string
+		payload string
+		want    string
+		wantErr error
+	}{
+		{"pause while printing", printing, "pause", "PRESS", `{"method":"set","params":{"pause":1}}`, nil},
+		{"pause while paused", paused, "pause", "PRESS", "", ErrJobState},
+		{"pause while idle", idle, "pause", "PRESS", "", ErrJobState},
+		{"resume while paused", paused, "resume", "PRESS", `{"method":"set","params":{"pause":0}}`, nil},
+		{"resume while printing", printing, "resume", "PRESS", "", ErrJobState},
+		{"cancel while printing", printing, "cancel", "CANCEL", `{"method":"set","params":{"stop":1}}`, nil},
+		{"cancel while paused", paused, "cancel", "CANCEL", `{"method":"set","params":{"stop":1}}`, nil},
+		{"cancel without confirm", printing, "cancel", "PRESS", "", ErrInvalidPayload},
+		{"cancel while idle", idle, "cancel", "CANCEL", "", ErrJobState},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", newFakePublisher())
+			p.HandleMessage([]byte(tt.frame))
+			cmd, ok := lookupCommand(tt.command)
+			require.True(t, ok)
+
+			got, err := cmd.build(p, tt.payload)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestPrinter_JobCommandRejectionsArePublished(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	p.HandleMessage([]byte(`{"deviceId":"dev","state":0}`))
+	p.subscribeCommands(p.Topics())
+	require.True(t, pub.subscribed("bt/command/resume"))
+
+	pub.mu.Lock()
+	handler := pub.subs["bt/command/resume"]
+	pub.mu.Unlock()
+	handler(nil, fakeMessage{payload: "PRESS"})
+
+	assert.JSONEq(t,
+		`{"command":"resume","payload":"PRESS","error":"not possible in the current job state: resume needs a paused job, the printer is idle"}`,
+		pub.topics()["bt/command/error"])
+}
+
+func TestTuningCommands(t *testing.T) {
+	tests := []struct {
+		command string
+		payload string
+		want    string
+		errMsg  string
+	}{
+		{"feedrate", "120", `{"method":"set","params":{"setFeedratePct":120}}`, ""},
+		{"feedrate", "5", "", "between 10 and 300 %"},
+		{"flowrate", "95", `{"method":"set","params":{"setFlowratePct":95}}`, ""},
+		{"flowrate", "200", "", "between 50 and 150 %"},
+		{"pressure_advance", "0.045", `{"method":"set","params":{"gcodeCmd":"SET_PRESSURE_ADVANCE ADVANCE=0.045"}}`, ""},
+		{"pressure_advance", "-0.1", "", "between 0 and 1 s"},
+		{"velocity_limit", "300", `{"method":"set","params":{"gcodeCmd":"SET_VELOCITY_LIMIT VELOCITY=300"}}`, ""},
+		{"velocity_limit", "fast", "", "expects a number in mm/s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+"/"+tt.payload, func(t *testing.T) {
+			cmd, ok := lookupCommand(tt.command)
+			require.True(t, ok)
+			got, err := cmd.build(nil, tt.payload)
+			if tt.errMsg != "" {
+				assert.ErrorIs(t, err, ErrInvalidPayload)
+				assert.ErrorContains(t, err, tt.errMsg)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestPrinter_TuningChangesAreConfirmed(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","curFeedratePct":100,"pressureAdvance":"0.040000"}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	runPrinter(t, p)
+	p.confirmTimeout = 200 * time.Millisecond
+	assert.True(t, pub.subscribed("bt/cur_feedrate_pct/set"))
+	pendingCount := func() int {
+		p.pendingMu.Lock()
+		defer p.pendingMu.Unlock()
+		return len(p.pending)
+	}
+
+	// Confirmed by the frame reporting the value, unrelated frames are ignored
+	require.NoError(t, commandErr(p, "pressure_advance", "0.045"))
+	ps.send(t, `{"nozzleTemp":"210.0"}`)
+	ps.send(t, `{"pressureAdvance":"0.045000"}`)
+	require.Eventually(t, func() bool { return pendingCount() == 0 }, time.Second, 5*time.Millisecond)
+	assert.NotContains(t, pub.topics(), "bt/command/error")
+
+	// A stale frame reporting the old value does not end the wait
+	require.NoError(t, commandErr(p, "feedrate", "150"))
+	ps.send(t, `{"curFeedratePct":100}`)
+	ps.send(t, `{"curFeedratePct":150}`)
+	require.Eventually(t, func() bool { return pendingCount() == 0 }, time.Second, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.NotContains(t, pub.topics(), "bt/command/error")
+
+	// Only another value until the timeout is reported as an error
+	require.NoError(t, commandErr(p, "feedrate", "110"))
+	ps.send(t, `{"curFeedratePct":100}`)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, pendingCount())
+	require.Eventually(t, func() bool { return pub.topics()["bt/command/error"] != "" }, time.Second, 5*time.Millisecond)
+	assert.JSONEq(t,
+		`{"command":"feedrate","payload":"110","error":"not confirmed by the printer: reported curFeedratePct=100, expected 110"}`,
+		pub.topics()["bt/command/error"])
+	pub.reset()
+
+	// A newer command for the same field replaces the pending one
+	require.NoError(t, commandErr(p, "feedrate", "120"))
+	require.NoError(t, commandErr(p, "feedrate", "130"))
+	assert.Equal(t, 1, pendingCount())
+	ps.send(t, `{"curFeedratePct":130}`)
+	require.Eventually(t, func() bool { return pendingCount() == 0 }, time.Second, 5*time.Millisecond)
+	assert.NotContains(t, pub.topics(), "bt/command/error")
+
+	// Changes nobody reports expire
+	p.confirmTimeout = 20 * time.Millisecond
+	require.NoError(t, commandErr(p, "flowrate", "90"))
+	require.Eventually(t, func() bool { return pendingCount() == 0 }, time.Second, 5*time.Millisecond)
+	assert.NotContains(t, pub.topics(), "bt/command/error")
+}
+
+func TestPrinter_CommandsAwaitTheirReply(t *testing.T) {
+	tests := []struct {
+		command string
+		payload string
+		reply   string
+		wantErr string
+	}{
+		{"light", "ON", `{"lightSw":1}`, ""},
+		{"light", "OFF", `{"lightSw":1}`, "reported lightSw=1, expected 0"},
+		{"nozzle_target", "215", `{"targetNozzleTemp":215}`, ""},
+		{"bed_target", "60", `{"targetBedTemp0":"0.000000"}`, "reported targetBedTemp0=0.000000, expected 60"},
+		{"model_fan", "40", `{"modelFanPct":39}`, ""},
+		{"case_fan", "OFF", `{"caseFanPct":100}`, "reported caseFanPct=100, expected 0"},
+		{"pause", "PRESS", `{"state":5}`, ""},
+		{"cancel", "CANCEL", `{"state":1}`, "reported state=1, expected 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.command+"/"+tt.payload, func(t *testing.T) {
+			ps := newPrinterServer(t, `{"deviceId":"dev","state":1,"printProgress":40,"printLeftTime":600}`)
+			pub := newFakePublisher()
+			p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+			runPrinter(t, p)
+			p.confirmTimeout = 100 * time.Millisecond
+
+			require.NoError(t, commandErr(p, tt.command, tt.payload))
+			ps.send(t, tt.reply)
+			require.Eventually(t, func() bool {
+				p.pendingMu.Lock()
+				defer p.pendingMu.Unlock()
+				return len(p.pending) == 0
+			}, time.Second, 5*time.Millisecond)
+
+			if tt.wantErr == "" {
+				assert.NotContains(t, pub.topics(), "bt/command/error")
+				return
+			}
+			assert.Contains(t, pub.topics()["bt/command/error"], tt.wantErr)
+		})
+	}
+}
+
+// commandResults decodes the results published on bt/command/result
+func commandResults(t *testing.T, pub *fakePublisher) []CommandResult {
+	t.Helper()
+	var out []CommandResult
+	for _, payload := range pub.payloads("bt/command/result") {
+		var r CommandResult
+		require.NoError(t, json.Unmarshal([]byte(payload), &r))
+		assert.False(t, r.Time.IsZero())
+		r.Time = time.Time{}
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestPrinter_CommandResults(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","lightSw":0,"targetNozzleTemp":0}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	runPrinter(t, p)
+	p.confirmTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		run     func() (string, error)
+		replies []string
+		want    []CommandResult
+		wantErr bool
+	}{
+		{
+			name:    "confirmed",
+			run:     func() (string, error) { return p.Command("light", "ON") },
+			replies: []string{`{"lightSw":1}`},
+			want: []CommandResult{
+				{Command: "light", Payload: "ON", Status: ResultSent},
+				{Command: "light", Payload: "ON", Status: ResultConfirmed, State: map[string]any{"lightSw": float64(1)}},
+			},
+		},
+		{
+			name:    "stale frame before the change",
+			run:     func() (string, error) { return p.Command("light", "OFF") },
+			replies: []string{`{"lightSw":1}`, `{"lightSw":0}`},
+			want: []CommandResult{
+				{Command: "light", Payload: "OFF", Status: ResultSent},
+				{Command: "light", Payload: "OFF", Status: ResultConfirmed, State: map[string]any{"lightSw": float64(0)}},
+			},
+		},
+		{
+			name:    "printer reports another value until the timeout",
+			run:     func() (string, error) { return p.Command("light", "ON") },
+			replies: []string{`{"lightSw":0}`},
+			want: []CommandResult{
+				{Command: "light", Payload: "ON", Status: ResultSent},
+				{Command: "light", Payload: "ON", Status: ResultRejected, Error: "not confirmed by the printer: reported lightSw=0, expected 1", State: map[string]any{"lightSw": float64(0)}},
+			},
+		},
+		{
+			name: "timeout",
+			run:  func() (string, error) { return p.Command("nozzle_target", "200") },
+			want: []CommandResult{
+				{Command: "nozzle_target", Payload: "200", Status: ResultSent},
+				{Command: "nozzle_target", Payload: "200", Status: ResultTimeout, Error: "no frame reported targetNozzleTemp within 100ms", State: map[string]any{"targetNozzleTemp": float64(0)}},
+			},
+		},
+		{
+			name:    "rejected by the bridge",
+			run:     func() (string, error) { return p.Command("light", "maybe") },
+			want:    []CommandResult{{Command: "light", Payload: "maybe", Status: ResultRejected, Error: `invalid payload: light expects ON or OFF, got "maybe"`}},
+			wantErr: true,
+		},
+		{
+			name: "nothing to confirm",
+			run:  func() (string, error) { return p.Command("gcode", "G28") },
+			want: []CommandResult{{Command: "gcode", Payload: "G28", Status: ResultSent}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub.reset()
+			id, err := tt.run()
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, id, 16)
+			for _, reply := range tt.replies {
+				ps.send(t, reply)
+			}
+			require.Eventually(t, func() bool { return len(pub.payloads("bt/command/result")) == len(tt.want) }, time.Second, 5*time.Millisecond)
+			for i := range tt.want {
+				tt.want[i].ID = id
+			}
+			assert.Equal(t, tt.want, commandResults(t, pub))
+		})
+	}
+}
+
+// brokerClient is a connected paho client recording the payloads published on a topic
+type brokerClient struct {
+	mqtt.Client
+	topic string
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (b *brokerClient) IsConnected() bool       { return true }
+func (b *brokerClient) Disconnect(quiesce uint) {}
+
+func (b *brokerClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if topic == b.topic {
+		b.published = append(b.published, payload.(string))
+	}
+	return &mqtt.DummyToken{}
+}
+
+func (b *brokerClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	return &mqtt.DummyToken{}
+}
+
+func (b *brokerClient) Unsubscribe(topics ...string) mqtt.Token { return &mqtt.DummyToken{} }
+
+func (b *brokerClient) statuses(t *testing.T) []string {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []string
+	for _, payload := range b.published {
+		var r CommandResult
+		require.NoError(t, json.Unmarshal([]byte(payload), &r))
+		out = append(out, r.Command+" "+r.Payload+" "+r.Status)
+	}
+	return out
+}
+
+func TestPrinter_CommandResultsAreNotRateLimited(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","lightSw":0}`)
+	broker := &brokerClient{topic: "bt/command/result"}
+	client := mqttclient.NewWithClient(broker, "tcp://test:1883")
+	client.SetMinInterval(time.Minute)
+	client.SetDedup(mqttclient.DedupConfig{Enabled: true})
+	t.Cleanup(client.Flush)
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", client)
+	runPrinter(t, p)
+
+	_, err := p.Command("light", "ON")
+	require.NoError(t, err)
+	ps.send(t, `{"lightSw":1}`)
+	require.Eventually(t, func() bool { return len(broker.statuses(t)) == 2 }, time.Second, 5*time.Millisecond)
+
+	_, err = p.Command("light", "OFF")
+	require.NoError(t, err)
+	ps.send(t, `{"lightSw":0}`)
+	require.Eventually(t, func() bool { return len(broker.statuses(t)) == 4 }, time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, []string{
+		"light ON " + ResultSent, "light ON " + ResultConfirmed,
+		"light OFF " + ResultSent, "light OFF " + ResultConfirmed,
+	}, broker.statuses(t))
+}
+
+func TestPrinter_CommandResultReplacedAndFailed(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","curFeedratePct":100}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	runPrinter(t, p)
+
+	first, err := p.Command("feedrate", "120")
+	require.NoError(t, err)
+	second, err := p.Command("feedrate", "130")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	ps.send(t, `{"curFeedratePct":130}`)
+	require.Eventually(t, func() bool { return len(pub.payloads("bt/command/result")) == 4 }, time.Second, 5*time.Millisecond)
+
+	final := map[string]string{}
+	for _, r := range commandResults(t, pub) {
+		final[r.ID] = r.Status
+	}
+	assert.Equal(t, map[string]string{first: ResultReplaced, second: ResultConfirmed}, final)
+
+	offline := NewPrinter(PrinterConfig{Name: "k2", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	pub.reset()
+	id, err := offline.Command("light", "ON")
+	require.Error(t, err)
+	results := commandResults(t, pub)
+	require.Len(t, results, 1)
+	assert.Equal(t, id, results[0].ID)
+	assert.Equal(t, ResultFailed, results[0].Status)
+	assert.Contains(t, results[0].Error, "send to printer")
+}
+
+func TestGcodeMessage(t *testing.T) {
+	cfg := config.CommandsConfig{GcodeDenylist: config.DefaultGcodeDenylist()}
+
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+		wantErr error
+	}{
+		{"single line", "G28", `{"method":"set","params":{"gcodeCmd":"G28"}}`, nil},
+		{"lines and comments", "G28 ; home\n\n  M84\n; done", `{"method":"set","params":{"gcodeCmd":"G28\nM84"}}`, nil},
+		{"denied", "G28\nm502", "", ErrNotAllowed},
+		{"denied after comment strip", "SAVE_CONFIG ; persist", "", ErrNotAllowed},
+		{"only comments", "; nothing", "", ErrInvalidPayload},
+		{"empty", "", "", ErrInvalidPayload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GcodeMessage(cfg, tt.payload)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestPrinter_GcodeResponses(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev"}`)
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}})
+	b.SetCommandsConfig(config.CommandsConfig{GcodeDenylist: []string{"M502"}})
+	p := b.Printers()[0]
+	p.gcodeResponseWindow = 200 * time.Millisecond
+	runPrinter(t, p)
+	require.True(t, pub.subscribed("bt/gcode/send"))
+
+	pub.mu.Lock()
+	handler := pub.subs["bt/gcode/send"]
+	pub.mu.Unlock()
+
+	// The frames the printer sends within the window are the response
+	handler(nil, fakeMessage{payload: "G28\nM84"})
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, `{"method":"set","params":{"gcodeCmd":"G28\nM84"}}`, ps.messages()[0])
+	assert.NotContains(t, pub.topics(), "bt/gcode/response", "published once the window has passed")
+	ps.send(t, `{"curPosition":"X:0.00 Y:0.00 Z:0.00"}`)
+	require.Eventually(t, func() bool { return pub.topics()["bt/gcode/response"] != "" }, time.Second, 10*time.Millisecond)
+	assert.JSONEq(t,
+		`{"gcode":["G28","M84"],"status":"sent","frames":[{"curPosition":"X:0.00 Y:0.00 Z:0.00"}]}`,
+		pub.topics()["bt/gcode/response"])
+
+	// Frames after the window are not part of it
+	pub.reset()
+	handler(nil, fakeMessage{payload: "M84"})
+	require.Eventually(t, func() bool { return pub.topics()["bt/gcode/response"] != "" }, time.Second, 10*time.Millisecond)
+	assert.JSONEq(t, `{"gcode":["M84"],"status":"sent"}`, pub.topics()["bt/gcode/response"])
+
+	handler(nil, fakeMessage{payload: "M502"})
+	assert.JSONEq(t,
+		`{"gcode":["M502"],"status":"rejected","error":"not allowed: M502 is in the G-code denylist"}`,
+		pub.topics()["bt/gcode/response"])
+	assert.Len(t, ps.messages(), 2)
+}
+
+type fakeMessage struct {
+	mqtt.Message
+	payload string
+}
+
+func (m fakeMessage) Payload() []byte { return []byte(m.payload) }
+
+func TestPrinter_RejectedCommandsArePublished(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}})
+	b.SetCommandsConfig(config.CommandsConfig{SetAllowlist: map[string]config.ParamRange{"lightSw": {}}})
+	p := b.Printers()[0]
+	p.subscribeCommands(p.Topics())
+
+	pub.mu.Lock()
+	handler := pub.subs["bt/command/set"]
+	pub.mu.Unlock()
+	require.NotNil(t, handler)
+
+	handler(nil, fakeMessage{payload: `{"gcodeCmd":"G28"}`})
+	assert.JSONEq(t,
+		`{"command":"set","payload":"{\"gcodeCmd\":\"G28\"}","error":"not allowed: gcodeCmd: use the gcode command"}`,
+		pub.topics()["bt/command/error"])
+
+	// Allowed but not connected: a send failure is not a rejection
+	pub.reset()
+	handler(nil, fakeMessage{payload: `{"lightSw":1}`})
+	assert.NotContains(t, pub.topics(), "bt/command/error")
+}
+
+func TestPrinter_CommandQueue(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","lightSw":0}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	p.commands.set(config.CommandsConfig{QueueTTL: config.Duration(time.Minute)})
+
+	// Not connected yet
+	id, err := p.Command("light", "ON")
+	require.ErrorIs(t, err, ErrQueued)
+	// Job actions and G-code are not queued
+	_, err = p.Command("gcode", "G28")
+	assert.ErrorIs(t, err, websocket.ErrCloseSent)
+	assert.Equal(t, 1, p.Status().QueuedCommands)
+	assert.Equal(t, []string{"1"}, pub.payloads("bt/command/queued"))
+
+	runPrinter(t, p)
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"method":"set","params":{"lightSw":1}}`, ps.messages()[0])
+	// The full frame sent on connect is not the reply to the replayed command
+	ps.send(t, `{"lightSw":1}`)
+
+	require.Eventually(t, func() bool { return len(pub.payloads("bt/command/result")) == 4 }, 2*time.Second, 5*time.Millisecond)
+	var statuses []string
+	for _, r := range commandResults(t, pub) {
+		if r.ID == id {
+			statuses = append(statuses, r.Status)
+		}
+	}
+	assert.Equal(t, []string{ResultQueued, ResultSent, ResultConfirmed}, statuses)
+	assert.Zero(t, p.Status().QueuedCommands)
+	assert.Equal(t, "0", pub.topics()["bt/command/queued"])
+}
+
+func TestPrinter_QueuedCommandExpires(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	p.commands.set(config.CommandsConfig{QueueTTL: config.Duration(20 * time.Millisecond)})
+
+	id, err := p.Command("nozzle_target", "200")
+	require.ErrorIs(t, err, ErrQueued)
+	require.Eventually(t, func() bool { return len(pub.payloads("bt/command/result")) == 2 }, 2*time.Second, 5*time.Millisecond)
+
+	results := commandResults(t, pub)
+	assert.Equal(t, CommandResult{ID: id, Command: "nozzle_target", Payload: "200", Status: ResultQueued}, results[0])
+	assert.Equal(t, CommandResult{ID: id, Command: "nozzle_target", Payload: "200", Status: ResultExpired, Error: "expired before the connection was back"}, results[1])
+	assert.Equal(t, []string{"1", "0"}, pub.payloads("bt/command/queued"))
+}
+
+func TestPrinter_ReconfigureURLMovesQueuedCommands(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev","lightSw":0}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	p.commands.set(config.CommandsConfig{QueueTTL: config.Duration(time.Minute)})
+
+	id, err := p.Command("light", "ON")
+	require.ErrorIs(t, err, ErrQueued)
+	oldWS := p.ws
+
+	// The printer moved: the command waits for the new address instead
+	p.Reconfigure(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha")
+	assert.Zero(t, oldWS.QueueDepth())
+	assert.Equal(t, 1, p.Status().QueuedCommands)
+	assert.Equal(t, "1", pub.topics()["bt/command/queued"])
+
+	runPrinter(t, p)
+	require.Eventually(t, func() bool { return len(ps.messages()) == 1 }, 2*time.Second, 5*time.Millisecond)
+	assert.Equal(t, `{"method":"set","params":{"lightSw":1}}`, ps.messages()[0])
+	ps.send(t, `{"lightSw":1}`)
+
+	require.Eventually(t, func() bool {
+		results := commandResults(t, pub)
+		return len(results) > 0 && results[len(results)-1].Status == ResultConfirmed
+	}, 2*time.Second, 5*time.Millisecond)
+	var statuses []string
+	for _, r := range commandResults(t, pub) {
+		if r.ID == id {
+			statuses = append(statuses, r.Status)
+		}
+	}
+	assert.Equal(t, []string{ResultQueued, ResultSent, ResultConfirmed}, statuses)
+	assert.Equal(t, "0", pub.topics()["bt/command/queued"])
+}
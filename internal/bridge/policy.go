@@ -0,0 +1,302 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/discovery"
+	"github.com/davidcollom/creality2mqtt/internal/mapper"
+)
+
+var (
+	// ErrReadOnly is returned for every command in read-only mode
+	ErrReadOnly = errors.New("read-only mode")
+	// ErrRateLimited is returned when a command follows the previous one on its topic too quickly
+	ErrRateLimited = errors.New("rate limited")
+)
+
+// rateLimiter remembers when each command was last accepted
+type rateLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{last: map[string]time.Time{}, now: time.Now}
+}
+
+// allow accepts a command unless the previous one was accepted less than interval ago
+func (r *rateLimiter) allow(name string, interval time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if last, ok := r.last[name]; ok && interval > 0 {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return fmt.Errorf("%w: %s accepts one command every %s, retry in %s", ErrRateLimited, name, interval, wait.Round(time.Millisecond))
+		}
+	}
+	r.last[name] = now
+	return nil
+}
+
+// checkPolicy applies the safety interlocks between the command topics and the
+// printer WebSocket, except for the rate limit (checkRate). It returns the
+// payload to send, with heater targets clamped to the limit when configured.
+func (p *Printer) checkPolicy(cmd command, payload string, force bool) (string, error) {
+	cfg := p.commands.get()
+	if cfg.ReadOnly {
+		return "", fmt.Errorf("%w: %s is refused", ErrReadOnly, cmd.name)
+	}
+
+	if cmd.interlocked && !force {
+		if state := mapper.JobState(p.State()); state == mapper.JobPrinting {
+			return "", fmt.Errorf("%w: %s is refused while printing unless forced", ErrJobState, cmd.name)
+		}
+	}
+
+	if cmd.heaterGcode {
+		limited, err := p.limitHeaterGcode(payload, cfg.ClampTemps)
+		if err != nil {
+			return "", err
+		}
+		payload = limited
+	}
+
+	if cmd.limit != nil && cfg.ClampTemps {
+		if v, err := strconv.ParseFloat(strings.TrimSpace(payload), 64); err == nil {
+			if limit := cmd.limit(p); v > limit {
+				log.Warn("Clamping heater target to the limit", "printer", p.Name(), "command", cmd.name, "target", v, "limit", limit)
+				payload = strconv.FormatFloat(limit, 'f', -1, 64)
+			}
+		}
+	}
+	return payload, nil
+}
+
+// checkRate applies the per-topic rate limit. It is checked once a command is
+// built, so commands refused for their payload do not count.
+func (p *Printer) checkRate(cmd command) error {
+	return p.rateLimiter.allow(cmd.name, time.Duration(p.commands.get().MinInterval))
+}
+
+// tempLimits returns the highest nozzle and bed targets accepted, see TempLimits
+func (p *Printer) tempLimits() (nozzle, bed float64) {
+	maxNozzle, maxBed := p.reportedTempLimits()
+	return TempLimits(p.commands.get(), maxNozzle, maxBed)
+}
+
+// TempLimits returns the highest nozzle and bed targets accepted: the maximum
+// reported by the printer (0 while unknown, for the default) capped by the
+// configured limits
+func TempLimits(cfg config.CommandsConfig, reportedNozzle, reportedBed float64) (nozzle, bed float64) {
+	nozzle, bed = discovery.NozzleTempLimit(reportedNozzle), discovery.BedTempLimit(reportedBed)
+	if cfg.MaxNozzleTemp > 0 {
+		nozzle = min(nozzle, cfg.MaxNozzleTemp)
+	}
+	if cfg.MaxBedTemp > 0 {
+		bed = min(bed, cfg.MaxBedTemp)
+	}
+	return nozzle, bed
+}
+
+const (
+	heaterNozzle = "nozzle"
+	heaterBed    = "bed"
+)
+
+// heaterGcodes are the G-code commands setting a heater target with their S
+// (or R, wait for cooling) parameter, by heater
+var heaterGcodes = map[string]string{
+	"M104": heaterNozzle,
+	"M109": heaterNozzle,
+	"M140": heaterBed,
+	"M190": heaterBed,
+}
+
+// heaterMacros are the Klipper commands setting heater targets with KEY=VALUE
+// params: the params holding a target by heater, "" for the heater named by
+// the HEATER param
+var heaterMacros = map[string]map[string]string{
+	"SET_HEATER_TEMPERATURE": {"TARGET": ""},
+	"PID_CALIBRATE":          {"TARGET": ""},
+	"START_PRINT":            {"EXTRUDER_TEMP": heaterNozzle, "BED_TEMP": heaterBed},
+	"PRINT_START":            {"EXTRUDER_TEMP": heaterNozzle, "BED_TEMP": heaterBed},
+}
+
+// uncheckedHeaterGcodes set heater targets the limits can't be applied to
+// (chamber, PID tuning, tool temperature tables). They are refused.
+var uncheckedHeaterGcodes = []string{"M141", "M191", "M303", "M568"}
+
+// klipperHeater returns the heater a Klipper heater name is held to
+func klipperHeater(name string) (string, bool) {
+	name = strings.ToLower(name)
+	switch {
+	case name == "heater_bed":
+		return heaterBed, true
+	case strings.HasPrefix(name, "extruder"):
+		if _, err := strconv.Atoi(strings.TrimPrefix(name, "extruder")); err == nil || name == "extruder" {
+			return heaterNozzle, true
+		}
+	}
+	return "", false
+}
+
+// heaterTarget is a heater target set by a G-code line, at index param of its params
+type heaterTarget struct {
+	heater string
+	param  int
+	value  float64
+}
+
+// gcodeLine is a G-code line split into its command and params. Marlin
+// params are a letter and a value, Klipper ones KEY=VALUE.
+type gcodeLine struct {
+	cmd     string
+	params  []string
+	klipper bool
+}
+
+// format returns the line with the params separated by spaces
+func (l gcodeLine) format() string {
+	return strings.Join(append([]string{l.cmd}, l.params...), " ")
+}
+
+// marlinParam matches a Marlin param: a letter and its value, with or without spaces around
+var marlinParam = regexp.MustCompile(`([A-Za-z])\s*([^A-Za-z\s]*)`)
+
+func parseGcodeLine(line string) gcodeLine {
+	cmd, rest := config.GcodeCommand(line)
+	if _, ok := heaterGcodes[strings.ToUpper(cmd)]; ok {
+		l := gcodeLine{cmd: cmd}
+		for _, m := range marlinParam.FindAllStringSubmatch(rest, -1) {
+			l.params = append(l.params, m[1]+m[2])
+		}
+		return l
+	}
+	return gcodeLine{cmd: cmd, params: strings.Fields(rest), klipper: true}
+}
+
+// heaterTargets returns the heater targets set by a G-code line. It fails for
+// heater commands whose targets can't be checked against the limits.
+func (l gcodeLine) heaterTargets() ([]heaterTarget, error) {
+	name := strings.ToUpper(l.cmd)
+	if slices.Contains(uncheckedHeaterGcodes, name) {
+		return nil, fmt.Errorf("%w: %s sets a heater target the bridge can't check", ErrNotAllowed, l.cmd)
+	}
+
+	if heater, ok := heaterGcodes[name]; ok {
+		var targets []heaterTarget
+		for i, param := range l.params {
+			letter := strings.ToUpper(param[:1])
+			if letter != "S" && letter != "R" {
+				continue
+			}
+			v, err := strconv.ParseFloat(param[1:], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s has an invalid %s target %q", ErrNotAllowed, l.format(), heater, param)
+			}
+			targets = append(targets, heaterTarget{heater: heater, param: i, value: v})
+		}
+		return targets, nil
+	}
+
+	macro, ok := heaterMacros[name]
+	if !ok {
+		return nil, nil
+	}
+	values := map[string]string{}
+	for _, param := range l.params {
+		key, value, _ := strings.Cut(param, "=")
+		values[strings.ToUpper(key)] = value
+	}
+	var targets []heaterTarget
+	for i, param := range l.params {
+		key, value, _ := strings.Cut(param, "=")
+		heater, ok := macro[strings.ToUpper(key)]
+		if !ok {
+			continue
+		}
+		if heater == "" {
+			if heater, ok = klipperHeater(values["HEATER"]); !ok {
+				return nil, fmt.Errorf("%w: %s sets heater %q, which has no limit", ErrNotAllowed, l.format(), values["HEATER"])
+			}
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s has an invalid %s target %q", ErrNotAllowed, l.format(), heater, value)
+		}
+		targets = append(targets, heaterTarget{heater: heater, param: i, value: v})
+	}
+	return targets, nil
+}
+
+// limitHeaterGcode holds the heater targets of G-code lines to tempLimits, see LimitHeaterGcode
+func (p *Printer) limitHeaterGcode(payload string, clamp bool) (string, error) {
+	nozzle, bed := p.tempLimits()
+	limited, err := LimitHeaterGcode(payload, nozzle, bed, clamp)
+	if err == nil && limited != payload {
+		log.Warn("Clamped G-code heater targets to the limits", "printer", p.Name(), "gcode", payload, "limited", limited)
+	}
+	return limited, err
+}
+
+// LimitHeaterGcode holds the heater targets of G-code lines to the nozzle and
+// bed limits. A target above its limit is rejected, or lowered to it with
+// clamp, and heater commands the limits can't be checked for are rejected.
+// The payload is returned unchanged when nothing was lowered.
+func LimitHeaterGcode(payload string, nozzle, bed float64, clamp bool) (string, error) {
+	lines := SplitGcode(payload)
+	clamped := false
+	for i, line := range lines {
+		l := parseGcodeLine(line)
+		targets, err := l.heaterTargets()
+		if err != nil {
+			return "", err
+		}
+		for _, t := range targets {
+			limit := nozzle
+			if t.heater == heaterBed {
+				limit = bed
+			}
+			if t.value <= limit {
+				continue
+			}
+			if !clamp {
+				return "", fmt.Errorf("%w: %s targets %g °C, above the %s limit of %g °C", ErrNotAllowed, line, t.value, t.heater, limit)
+			}
+			v := strconv.FormatFloat(limit, 'f', -1, 64)
+			if key, _, ok := strings.Cut(l.params[t.param], "="); ok && l.klipper {
+				l.params[t.param] = key + "=" + v
+			} else {
+				l.params[t.param] = l.params[t.param][:1] + v
+			}
+			clamped = true
+		}
+		if len(targets) > 0 {
+			lines[i] = l.format()
+		}
+	}
+	if !clamped {
+		return payload, nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func nozzleLimit(p *Printer) float64 {
+	nozzle, _ := p.tempLimits()
+	return nozzle
+}
+
+func bedLimit(p *Printer) float64 {
+	_, bed := p.tempLimits()
+	return bed
+}
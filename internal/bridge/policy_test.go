@@ -0,0 +1,152 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	r := newRateLimiter()
+	r.now = func() time.Time { return now }
+
+	require.NoError(t, r.allow("light", time.Second))
+	// Commands are limited per topic
+	require.NoError(t, r.allow("bed_target", time.Second))
+
+	now = now.Add(400 * time.Millisecond)
+	err := r.allow("light", time.Second)
+	assert.ErrorIs(t, err, ErrRateLimited)
+	assert.EqualError(t, err, "rate limited: light accepts one command every 1s, retry in 600ms")
+
+	now = now.Add(600 * time.Millisecond)
+	assert.NoError(t, r.allow("light", time.Second))
+	// No interval, no limit
+	assert.NoError(t, r.allow("light", 0))
+}
+
+func TestPrinter_CommandPolicy(t *testing.T) {
+	idle := `{"deviceId":"dev","state":0,"maxNozzleTemp":300,"maxBedTemp":100}`
+	printing := `{"deviceId":"dev","state":1,"printProgress":40,"printLeftTime":600,"maxNozzleTemp":300,"maxBedTemp":100}`
+
+	tests := []struct {
+		name    string
+		cfg     config.CommandsConfig
+		frame   string
+		command string
+		payload string
+		force   bool
+		want    string
+		wantErr error
+	}{
+		{"read-only", config.CommandsConfig{ReadOnly: true}, idle, "light", "ON", false, "", ErrReadOnly},
+		{"gcode while idle", config.CommandsConfig{}, idle, "gcode", "G28", false, "G28", nil},
+		{"gcode while printing", config.CommandsConfig{}, printing, "gcode", "G28", false, "", ErrJobState},
+		{"forced gcode while printing", config.CommandsConfig{}, printing, "gcode", "G28", true, "G28", nil},
+		{"tuning while printing", config.CommandsConfig{}, printing, "feedrate", "120", false, "120", nil},
+		{"target below the limit", config.CommandsConfig{MaxNozzleTemp: 250}, idle, "nozzle_target", "240", false, "240", nil},
+		{"target above the limit is clamped", config.CommandsConfig{MaxNozzleTemp: 250, ClampTemps: true}, idle, "nozzle_target", "280", false, "250", nil},
+		{"target above the printer limit is clamped", config.CommandsConfig{ClampTemps: true}, idle, "bed_target", "120", false, "100", nil},
+		{"invalid target is left to the command", config.CommandsConfig{ClampTemps: true}, idle, "bed_target", "hot", false, "hot", nil},
+		{"gcode nozzle target above the limit", config.CommandsConfig{}, idle, "gcode", "M104 S999", false, "", ErrNotAllowed},
+		{"gcode bed target above the configured limit", config.CommandsConfig{MaxBedTemp: 80}, idle, "gcode", "G28\nm190 s90", false, "", ErrNotAllowed},
+		{"forced gcode target above the limit", config.CommandsConfig{}, printing, "gcode", "M109 R400", true, "", ErrNotAllowed},
+		{"gcode targets below the limit", config.CommandsConfig{}, idle, "gcode", "M104 S220 ; warm up\nM140 S60", false, "M104 S220 ; warm up\nM140 S60", nil},
+		{"gcode target above the limit is clamped", config.CommandsConfig{MaxNozzleTemp: 250, ClampTemps: true}, idle, "gcode", "G28\nM109 T0 S280 ; hot\nM190 S120", false, "G28\nM109 T0 S250\nM190 S100", nil},
+		{"unspaced gcode target above the limit", config.CommandsConfig{}, idle, "gcode", "M104S400", false, "", ErrNotAllowed},
+		{"unspaced gcode params above the limit", config.CommandsConfig{}, idle, "gcode", "m140 T0S120", false, "", ErrNotAllowed},
+		{"unspaced gcode target is clamped", config.CommandsConfig{ClampTemps: true}, idle, "gcode", "M104S400", false, "M104 S300", nil},
+		{"unspaced gcode target below the limit", config.CommandsConfig{}, idle, "gcode", "M104S200", false, "M104S200", nil},
+		{"gcode with an invalid target", config.CommandsConfig{}, idle, "gcode", "M104 S{hot}", false, "", ErrNotAllowed},
+		{"klipper nozzle target above the limit", config.CommandsConfig{}, idle, "gcode", "SET_HEATER_TEMPERATURE HEATER=extruder TARGET=400", false, "", ErrNotAllowed},
+		{"klipper bed target above the configured limit", config.CommandsConfig{MaxBedTemp: 80}, idle, "gcode", "set_heater_temperature heater=heater_bed target=90", false, "", ErrNotAllowed},
+		{"klipper target below the limit", config.CommandsConfig{}, idle, "gcode", "SET_HEATER_TEMPERATURE HEATER=extruder TARGET=200", false, "SET_HEATER_TEMPERATURE HEATER=extruder TARGET=200", nil},
+		{"klipper target is clamped", config.CommandsConfig{ClampTemps: true}, idle, "gcode", "SET_HEATER_TEMPERATURE TARGET=130 HEATER=heater_bed", false, "SET_HEATER_TEMPERATURE TARGET=100 HEATER=heater_bed", nil},
+		{"klipper target of an unknown heater", config.CommandsConfig{}, idle, "gcode", "SET_HEATER_TEMPERATURE HEATER=chamber TARGET=40", false, "", ErrNotAllowed},
+		{"klipper target without a heater", config.CommandsConfig{}, idle, "gcode", "SET_HEATER_TEMPERATURE TARGET=40", false, "", ErrNotAllowed},
+		{"klipper invalid target", config.CommandsConfig{}, idle, "gcode", "SET_HEATER_TEMPERATURE HEATER=extruder TARGET=hot", false, "", ErrNotAllowed},
+		{"pid calibration above the limit", config.CommandsConfig{}, idle, "gcode", "PID_CALIBRATE HEATER=extruder1 TARGET=350", false, "", ErrNotAllowed},
+		{"start print macro above the limit", config.CommandsConfig{}, idle, "gcode", "START_PRINT EXTRUDER_TEMP=220 BED_TEMP=150", false, "", ErrNotAllowed},
+		{"start print macro is clamped", config.CommandsConfig{MaxNozzleTemp: 250, ClampTemps: true}, idle, "gcode", "START_PRINT EXTRUDER_TEMP=280 BED_TEMP=60", false, "START_PRINT EXTRUDER_TEMP=250 BED_TEMP=60", nil},
+		{"unchecked heater gcode", config.CommandsConfig{}, idle, "gcode", "M141 S40", false, "", ErrNotAllowed},
+		{"unchecked heater gcode with clamping", config.CommandsConfig{ClampTemps: true}, idle, "gcode", "G28\nM303 E0 S200 C8", false, "", ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", newFakePublisher())
+			p.commands.set(tt.cfg)
+			p.HandleMessage([]byte(tt.frame))
+			cmd, ok := lookupCommand(tt.command)
+			require.True(t, ok)
+
+			got, err := p.checkPolicy(cmd, tt.payload, tt.force)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPrinter_ConfiguredTempLimits(t *testing.T) {
+	pub := newFakePublisher()
+	b := New(pub, "ha", []PrinterConfig{{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}})
+	p := b.Printers()[0]
+	p.HandleMessage([]byte(`{"deviceId":"dev","maxNozzleTemp":300,"maxBedTemp":100}`))
+
+	// Above the configured limit without clamping
+	b.SetCommandsConfig(config.CommandsConfig{MaxNozzleTemp: 250})
+	_, err := p.Command("nozzle_target", "260")
+	assert.ErrorIs(t, err, ErrInvalidPayload)
+	assert.ErrorContains(t, err, "between 0 and 250 °C")
+
+	// The Nozzle Target number follows the configured limit
+	assert.Contains(t, pub.topics()["ha/number/dev/nozzle_temp_setpoint/config"], `"max":250`)
+	assert.Contains(t, pub.topics()["ha/climate/dev/nozzle_climate/config"], `"max_temp":250`)
+
+	// G-code heater targets are held to the same limit
+	_, err = p.Command("gcode", "M104 S999")
+	assert.ErrorIs(t, err, ErrNotAllowed)
+	assert.ErrorContains(t, err, "M104 S999 targets 999 °C, above the nozzle limit of 250 °C")
+
+	// A configured limit above the printer's maximum does not raise it
+	b.SetCommandsConfig(config.CommandsConfig{MaxNozzleTemp: 400})
+	assert.Contains(t, pub.topics()["ha/number/dev/nozzle_temp_setpoint/config"], `"max":300`)
+}
+
+func TestPrinter_CommandRateLimit(t *testing.T) {
+	ps := newPrinterServer(t, `{"deviceId":"dev"}`)
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: ps.wsURL(), BaseTopic: "bt"}, "ha", pub)
+	p.commands.set(config.CommandsConfig{MinInterval: config.Duration(time.Hour)})
+	runPrinter(t, p)
+
+	require.NoError(t, commandErr(p, "light", "ON"))
+	// A rejected command does not use up the topic's slot
+	assert.ErrorIs(t, commandErr(p, "model_fan", "101"), ErrInvalidPayload)
+	require.NoError(t, commandErr(p, "model_fan", "50"))
+	assert.ErrorIs(t, commandErr(p, "light", "OFF"), ErrRateLimited)
+
+	require.Eventually(t, func() bool { return len(ps.messages()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Contains(t, pub.topics()["bt/command/result"], `"status":"rejected"`)
+}
+
+func TestPrinter_ReadOnlySubscribesNothing(t *testing.T) {
+	pub := newFakePublisher()
+	p := NewPrinter(PrinterConfig{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt"}, "ha", pub)
+	p.commands.set(config.CommandsConfig{ReadOnly: true})
+	p.subscribeCommands(p.Topics())
+	assert.Empty(t, pub.subs)
+
+	p.commands.set(config.CommandsConfig{})
+	p.subscribeCommands(p.Topics())
+	assert.True(t, pub.subscribed("bt/gcode/send"))
+	assert.True(t, pub.subscribed("bt/gcode/send/force"))
+	assert.False(t, pub.subscribed("bt/light_sw/set/force"))
+}
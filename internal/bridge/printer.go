@@ -0,0 +1,637 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/config"
+	"github.com/davidcollom/creality2mqtt/internal/discovery"
+	"github.com/davidcollom/creality2mqtt/internal/mapper"
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
+	"github.com/davidcollom/creality2mqtt/internal/state"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	"github.com/davidcollom/creality2mqtt/internal/wsclient"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Publisher is the subset of the MQTT client used by the bridge.
+// It is satisfied by *mqttclient.Client.
+type Publisher interface {
+	Publish(topic, payload string, retain bool)
+	// PublishNow publishes without rate limiting or change-only publishing,
+	// for command feedback where every payload matters
+	PublishNow(topic, payload string)
+	Subscribe(topic string, handler mqtt.MessageHandler) error
+	Unsubscribe(topic string) error
+}
+
+// PrinterConfig holds the settings for a single bridged printer
+type PrinterConfig struct {
+	Name       string // short name used in logs
+	WSURL      string // WebSocket URL of the printer
+	BaseTopic  string // MQTT base topic for this printer
+	DeviceName string // optional Home Assistant device name override
+}
+
+// Printer bridges one printer WebSocket to MQTT.
+// Every printer has its own WebSocket client, topics, discovery set and availability.
+type Printer struct {
+	mqtt Publisher
+
+	// mu guards the settings that can change on reload
+	mu              sync.RWMutex
+	cfg             PrinterConfig
+	discoveryPrefix string
+	topics          *types.TopicBuilder
+	// the bridge's Last Will topic, which discovery availability follows too
+	willTopic string
+	ws        *wsclient.Client
+	cancelWS  context.CancelFunc
+	// set after the first WebSocket connection, later ones count as reconnects
+	wsConnectedOnce atomic.Bool
+
+	// Discovery of the detected device, nil until the first frame
+	discoveryMu sync.Mutex
+	discovery   *discovery.Builder
+	// Device info detected from the first frame
+	detected *detectedDevice
+	// Track published CFS box discovery to avoid duplicates
+	publishedCFS map[int]bool
+	// Whether the discovery messages have been published
+	discoveryPublished bool
+
+	// Command settings, shared with the other printers of the bridge
+	commands *commandSettings
+
+	// Last accepted command per command, for the command rate limit
+	rateLimiter *rateLimiter
+
+	// Replies awaited for the changes sent to the printer, by printer field
+	pendingMu      sync.Mutex
+	pending        map[string]*wsclient.Reply
+	confirmTimeout time.Duration
+	// How long the frames following sent G-code are collected
+	gcodeResponseWindow time.Duration
+
+	// Latest mapped value per topic
+	stateMu sync.Mutex
+	values  map[string]TopicValue
+
+	// The printer fields merged from every frame
+	state *state.PrinterState
+	// Maps the frames to MQTT topics
+	mapper *mapper.Mapper
+}
+
+// TopicValue is the latest payload mapped for a topic
+type TopicValue struct {
+	Topic   string    `json:"topic"`
+	Payload string    `json:"payload"`
+	Updated time.Time `json:"updated"`
+}
+
+// Entity is a Home Assistant entity announced through discovery
+type Entity struct {
+	Component   string `json:"component"`
+	UniqueID    string `json:"unique_id"`
+	Name        string `json:"name"`
+	ConfigTopic string `json:"config_topic"`
+}
+
+// Status is a snapshot of a printer's connection and discovery state
+type Status struct {
+	Name               string `json:"name"`
+	DeviceID           string `json:"device_id,omitempty"`
+	WSConnected        bool   `json:"ws_connected"`
+	DiscoveryPublished bool   `json:"discovery_published"`
+	// Commands waiting for the WebSocket to reconnect
+	QueuedCommands int `json:"queued_commands"`
+}
+
+type detectedDevice struct {
+	id, name, model string
+}
+
+// NewPrinter creates a Printer publishing through the given MQTT publisher
+func NewPrinter(cfg PrinterConfig, discoveryPrefix string, pub Publisher) *Printer {
+	p := &Printer{
+		cfg:                 cfg,
+		discoveryPrefix:     discoveryPrefix,
+		topics:              types.NewTopicBuilder(cfg.BaseTopic, discoveryPrefix),
+		mqtt:                pub,
+		publishedCFS:        map[int]bool{},
+		commands:            &commandSettings{},
+		values:              map[string]TopicValue{},
+		state:               state.New(),
+		mapper:              mapper.New(mapper.DefaultStatusInterval, time.Now),
+		pending:             map[string]*wsclient.Reply{},
+		confirmTimeout:      defaultConfirmTimeout,
+		gcodeResponseWindow: defaultGcodeResponseWindow,
+		rateLimiter:         newRateLimiter(),
+	}
+	p.ws = p.newWSClient(cfg.WSURL)
+	return p
+}
+
+func (p *Printer) newWSClient(url string) *wsclient.Client {
+	ws := wsclient.New(url, p.HandleMessage)
+	ws.SetConnectHandler(func() {
+		if p.wsConnectedOnce.Swap(true) {
+			metrics.WSReconnects.WithLabelValues(p.Name()).Inc()
+		}
+		p.PublishAvailability("online")
+		log.Info("Published birth message", "printer", p.Name(), "topic", p.Topics().Availability())
+		p.publishQueueDepth(ws.QueueDepth())
+	})
+	// Entities go unavailable while the printer is unreachable
+	ws.SetDisconnectHandler(func() { p.PublishAvailability("offline") })
+	ws.SetQueueHandler(p.publishQueueDepth)
+	return ws
+}
+
+// Name returns the printer's short name
+func (p *Printer) Name() string {
+	return p.Config().Name
+}
+
+// Config returns the printer's current settings
+func (p *Printer) Config() PrinterConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.cfg
+}
+
+// Topics returns the topic builder for this printer
+func (p *Printer) Topics() *types.TopicBuilder {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.topics
+}
+
+// Status returns the printer's current connection and discovery state
+func (p *Printer) Status() Status {
+	p.discoveryMu.Lock()
+	s := Status{DiscoveryPublished: p.discoveryPublished}
+	if p.detected != nil {
+		s.DeviceID = p.detected.id
+	}
+	p.discoveryMu.Unlock()
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	s.Name = p.cfg.Name
+	s.WSConnected = p.ws.Connected()
+	s.QueuedCommands = p.ws.QueueDepth()
+	return s
+}
+
+// Run subscribes to the printer's command topics and streams WebSocket
+// messages to MQTT until the context is cancelled. The printer's availability
+// is "online" while its WebSocket is connected and "offline" otherwise.
+func (p *Printer) Run(ctx context.Context) error {
+	topics := p.Topics()
+	// Replaces an "online" left retained by a previous run until the printer is reached
+	p.PublishAvailability("offline")
+	defer p.PublishAvailability("offline")
+
+	p.subscribeCommands(topics)
+	defer p.unsubscribeCommands(p.Topics())
+
+	for {
+		// The WebSocket client is replaced when the printer URL changes on reload
+		wsCtx, cancel := context.WithCancel(ctx)
+		p.mu.Lock()
+		ws, cfg := p.ws, p.cfg
+		p.cancelWS = cancel
+		p.mu.Unlock()
+
+		log.Info("Starting WebSocket connection", "printer", cfg.Name, "url", cfg.WSURL)
+		err := ws.Run(wsCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil && err != context.Canceled {
+			return err
+		}
+	}
+}
+
+// PublishAvailability publishes the printer's availability state ("online"/"offline")
+func (p *Printer) PublishAvailability(state string) {
+	p.mqtt.Publish(p.Topics().Availability(), state, true)
+}
+
+// availability returns the availability state of the printer's WebSocket connection
+func (p *Printer) availability() string {
+	if p.Status().WSConnected {
+		return "online"
+	}
+	return "offline"
+}
+
+// Reconfigure applies new settings in place. The WebSocket is only reconnected
+// when the printer URL changed; command topics are only moved when the base
+// topic changed. Discovery is regenerated and republished when needed.
+func (p *Printer) Reconfigure(cfg PrinterConfig, discoveryPrefix string) {
+	p.mu.Lock()
+	old, oldPrefix, oldTopics := p.cfg, p.discoveryPrefix, p.topics
+	p.cfg = cfg
+	p.discoveryPrefix = discoveryPrefix
+	p.topics = types.NewTopicBuilder(cfg.BaseTopic, discoveryPrefix)
+	newTopics := p.topics
+	var cancelWS context.CancelFunc
+	oldWS, newWS := p.ws, p.ws
+	if cfg.WSURL != old.WSURL {
+		newWS = p.newWSClient(cfg.WSURL)
+		p.ws = newWS
+		cancelWS = p.cancelWS
+	}
+	p.mu.Unlock()
+
+	if newWS != oldWS {
+		p.moveQueue(oldWS, newWS)
+	}
+
+	if cfg.BaseTopic != old.BaseTopic {
+		log.Info("Moving printer topics", "printer", cfg.Name, "from", old.BaseTopic, "to", cfg.BaseTopic)
+		p.stateMu.Lock()
+		p.values = map[string]TopicValue{}
+		p.stateMu.Unlock()
+		p.unsubscribeCommands(oldTopics)
+		p.mqtt.Publish(oldTopics.Availability(), "offline", true)
+		p.subscribeCommands(newTopics)
+		p.mqtt.Publish(newTopics.Availability(), p.availability(), true)
+	}
+
+	if discoveryPrefix != oldPrefix {
+		p.removeDiscovery()
+	}
+	if cfg.BaseTopic != old.BaseTopic || cfg.DeviceName != old.DeviceName ||
+		cfg.WSURL != old.WSURL || discoveryPrefix != oldPrefix {
+		p.refreshDiscovery()
+	}
+
+	if cancelWS != nil {
+		log.Info("Printer URL changed, reconnecting WebSocket", "printer", cfg.Name, "url", cfg.WSURL)
+		cancelWS()
+	}
+}
+
+// moveQueue hands the commands still waiting on the replaced WebSocket client
+// to the new one, which replays them once connected
+func (p *Printer) moveQueue(from, to *wsclient.Client) {
+	queued := from.TakeQueue()
+	if len(queued) > 0 {
+		log.Info("Moving queued commands to the new WebSocket", "printer", p.Name(), "count", len(queued))
+	}
+	for _, out := range queued {
+		// Done only reports queued messages
+		if err := to.Send(out); !errors.Is(err, wsclient.ErrQueued) && out.Done != nil {
+			out.Done(err)
+		}
+	}
+}
+
+// expireQueue reports the commands still waiting for a removed printer as
+// expired, so that none is left to be written or to time out later
+func (p *Printer) expireQueue() {
+	p.mu.RLock()
+	ws := p.ws
+	p.mu.RUnlock()
+	for _, out := range ws.TakeQueue() {
+		if out.Done != nil {
+			out.Done(wsclient.ErrExpired)
+		}
+	}
+}
+
+// SetWillTopic sets the bridge's Last Will topic, republishing discovery when it changed
+func (p *Printer) SetWillTopic(topic string) {
+	p.mu.Lock()
+	changed := p.willTopic != topic
+	p.willTopic = topic
+	p.mu.Unlock()
+
+	if changed {
+		p.refreshDiscovery()
+	}
+}
+
+// PublishDiscovery publishes the printer's discovery messages, if the device is known yet
+func (p *Printer) PublishDiscovery() {
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	if p.discovery != nil && len(p.discovery.Messages()) > 0 {
+		msgs := p.discovery.Messages()
+		log.Info("Publishing MQTT Discovery messages", "printer", p.Name(), "count", len(msgs))
+		for _, m := range msgs {
+			log.Debug("Publishing discovery config", "topic", m.Topic)
+			p.mqtt.Publish(m.Topic, m.Payload, m.Retain)
+		}
+		p.discoveryPublished = true
+		log.Info("MQTT Discovery published - entities should appear in Home Assistant", "printer", p.Name())
+	}
+}
+
+// SendMessage sends a raw message to the printer WebSocket
+func (p *Printer) SendMessage(data []byte) error {
+	p.mu.RLock()
+	ws := p.ws
+	p.mu.RUnlock()
+	return ws.SendMessage(data)
+}
+
+// send writes a command to the printer WebSocket, or queues it while disconnected, see wsclient.Client.Send
+func (p *Printer) send(out wsclient.Outgoing) error {
+	p.mu.RLock()
+	ws := p.ws
+	p.mu.RUnlock()
+	return ws.Send(out)
+}
+
+// publishQueueDepth reports the number of commands waiting for the printer
+func (p *Printer) publishQueueDepth(depth int) {
+	metrics.WSQueuedCommands.WithLabelValues(p.Name()).Set(float64(depth))
+	p.mqtt.PublishNow(p.Topics().CommandQueued(), strconv.Itoa(depth))
+}
+
+// Expect waits for the next printer frame accepted by match, see wsclient.Client.Expect
+func (p *Printer) Expect(match wsclient.MatchFunc) *wsclient.Reply {
+	p.mu.RLock()
+	ws := p.ws
+	p.mu.RUnlock()
+	return ws.Expect(match)
+}
+
+// HandleMessage maps a WebSocket frame to MQTT, publishing discovery on the first frame
+func (p *Printer) HandleMessage(data []byte) {
+	cfg := p.Config()
+	log.Debug("Received WebSocket message", "printer", cfg.Name, "size", len(data))
+	metrics.FramesReceived.WithLabelValues(cfg.Name).Inc()
+
+	var rawMsg map[string]any
+	if err := json.Unmarshal(data, &rawMsg); err != nil {
+		log.Error("Failed to decode message", "printer", cfg.Name, "error", err)
+		metrics.DecodeErrors.WithLabelValues(cfg.Name).Inc()
+		return
+	}
+	p.state.Merge(rawMsg)
+
+	// Extract device info on first message
+	p.discoveryMu.Lock()
+	first := p.detected == nil
+	if first {
+		deviceID, devName, deviceModel := discovery.ExtractDeviceInfo(rawMsg)
+		p.detected = &detectedDevice{id: deviceID, name: devName, model: deviceModel}
+	}
+	deviceID := p.detected.id
+	p.discoveryMu.Unlock()
+	if first {
+		p.setupDiscovery()
+	}
+	p.updateTempLimits()
+	p.publishCFSDiscovery(rawMsg)
+
+	msgs := p.mapper.MapFrame(rawMsg, p.state, cfg.BaseTopic)
+	if deviceID != "" {
+		metrics.ObservePrinter(deviceID, cfg.BaseTopic, msgs)
+	}
+
+	p.recordValues(msgs)
+	for _, m := range msgs {
+		log.Debug("Publishing MQTT message", "topic", m.Topic, "payload", m.Payload)
+		p.mqtt.Publish(m.Topic, m.Payload, m.Retain)
+	}
+}
+
+func (p *Printer) recordValues(msgs []types.MqttMessage) {
+	now := time.Now()
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	for _, m := range msgs {
+		p.values[m.Topic] = TopicValue{Topic: m.Topic, Payload: m.Payload, Updated: now}
+	}
+}
+
+// State returns the printer fields merged from every frame received so far
+func (p *Printer) State() map[string]any {
+	return p.state.Fields()
+}
+
+// reportedTempLimits returns the maximum nozzle and bed temperatures reported
+// by the printer, 0 while unknown.
+func (p *Printer) reportedTempLimits() (nozzle, bed float64) {
+	nozzle, _ = p.state.Float("maxNozzleTemp")
+	bed, _ = p.state.Float("maxBedTemp")
+	return nozzle, bed
+}
+
+// Values returns the latest mapped value of every topic, sorted by topic
+func (p *Printer) Values() []TopicValue {
+	p.stateMu.Lock()
+	defer p.stateMu.Unlock()
+	out := make([]TopicValue, 0, len(p.values))
+	for _, v := range p.values {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+	return out
+}
+
+// Entities returns the Home Assistant entities published through discovery,
+// including CFS boxes seen so far.
+func (p *Printer) Entities() []Entity {
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	if !p.discoveryPublished {
+		return []Entity{}
+	}
+
+	msgs := append([]types.MqttMessage(nil), p.discovery.Messages()...)
+	for id := range p.publishedCFS {
+		msgs = append(msgs, p.discovery.CFSBox(id)...)
+	}
+
+	out := make([]Entity, 0, len(msgs))
+	for _, m := range msgs {
+		if e, ok := parseEntity(p.discovery.Config().DiscoveryPrefix, m); ok {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ConfigTopic < out[j].ConfigTopic })
+	return out
+}
+
+// parseEntity reads an entity from a "<prefix>/<component>/<device>/<id>/config" discovery message
+func parseEntity(prefix string, m types.MqttMessage) (Entity, bool) {
+	rest, ok := strings.CutPrefix(m.Topic, prefix+"/")
+	parts := strings.Split(rest, "/")
+	if !ok || len(parts) != 4 || parts[3] != "config" || m.Payload == "" {
+		return Entity{}, false
+	}
+	var payload struct {
+		Name     string `json:"name"`
+		UniqueID string `json:"unique_id"`
+	}
+	if err := json.Unmarshal([]byte(m.Payload), &payload); err != nil {
+		return Entity{}, false
+	}
+	return Entity{Component: parts[0], UniqueID: payload.UniqueID, Name: payload.Name, ConfigTopic: m.Topic}, true
+}
+
+// buildDiscoveryConfig returns the discovery config from the detected device
+// and the current settings. discoveryMu must be held.
+func (p *Printer) buildDiscoveryConfig() discovery.Config {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	devName := p.detected.name
+	if p.cfg.DeviceName != "" {
+		devName = p.cfg.DeviceName // Use configured override if provided
+	}
+	maxNozzle, maxBed := p.tempLimits()
+	return discovery.Config{
+		DiscoveryPrefix: p.discoveryPrefix,
+		BaseTopic:       p.cfg.BaseTopic,
+		DeviceID:        p.detected.id,
+		DeviceName:      devName,
+		DeviceModel:     p.detected.model,
+		PrinterIP:       config.PrinterHost(p.cfg.WSURL),
+		MaxNozzleTemp:   maxNozzle,
+		MaxBedTemp:      maxBed,
+		WillTopic:       p.willTopic,
+	}
+}
+
+// setupDiscovery builds the discovery config from the detected device, removes
+// old entities and publishes the current discovery messages.
+func (p *Printer) setupDiscovery() {
+	// Keep the discovery builder for later republishing
+	p.discoveryMu.Lock()
+	cfg := p.buildDiscoveryConfig()
+
+	log.Info("Device detected",
+		"printer", p.Name(),
+		"device_id", cfg.DeviceID,
+		"device_name", cfg.DeviceName,
+		"device_model", cfg.DeviceModel,
+	)
+
+	// Generate the current discovery messages
+	p.discovery = discovery.NewBuilder(cfg)
+
+	// First, cleanup old/unused entities
+	cleanupMsgs := p.discovery.Cleanup()
+	if len(cleanupMsgs) > 0 {
+		log.Info("Cleaning up old entities", "printer", p.Name(), "count", len(cleanupMsgs))
+		for _, m := range cleanupMsgs {
+			log.Debug("Removing old entity", "topic", m.Topic)
+			p.mqtt.Publish(m.Topic, m.Payload, m.Retain)
+		}
+	}
+	p.discoveryMu.Unlock()
+
+	// Publish discovery messages
+	p.PublishDiscovery()
+}
+
+// refreshDiscovery regenerates and republishes discovery after a settings change.
+// CFS box discovery is republished when the boxes are next seen.
+func (p *Printer) refreshDiscovery() {
+	p.discoveryMu.Lock()
+	if p.discovery == nil {
+		// Nothing published yet, the first frame will do it
+		p.discoveryMu.Unlock()
+		return
+	}
+	p.discovery.SetConfig(p.buildDiscoveryConfig())
+	p.publishedCFS = map[int]bool{}
+	p.discoveryMu.Unlock()
+
+	p.PublishDiscovery()
+}
+
+// updateTempLimits regenerates discovery and republishes the target temperature
+// controls when the printer reports different maximum temperatures or the
+// configured limits change.
+func (p *Printer) updateTempLimits() {
+	maxNozzle, maxBed := p.tempLimits()
+
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	if p.discovery == nil {
+		return
+	}
+	cfg := p.discovery.Config()
+	if cfg.MaxNozzleTemp == maxNozzle && cfg.MaxBedTemp == maxBed {
+		return
+	}
+	cfg.MaxNozzleTemp, cfg.MaxBedTemp = maxNozzle, maxBed
+	p.discovery.SetConfig(cfg)
+	if !p.discoveryPublished {
+		return
+	}
+	log.Info("Printer temperature limits changed", "printer", p.Name(), "max_nozzle", maxNozzle, "max_bed", maxBed)
+	for _, m := range p.discovery.TemperatureControls() {
+		p.mqtt.Publish(m.Topic, m.Payload, m.Retain)
+	}
+}
+
+// removeDiscovery clears the retained discovery configs published so far
+func (p *Printer) removeDiscovery() {
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+
+	p.discoveryPublished = false
+	if p.discovery == nil {
+		return
+	}
+	for _, m := range p.discovery.Messages() {
+		if strings.HasSuffix(m.Topic, "/config") {
+			p.mqtt.Publish(m.Topic, "", true)
+		}
+	}
+	for id := range p.publishedCFS {
+		for _, m := range p.discovery.CFSBox(id) {
+			p.mqtt.Publish(m.Topic, "", true)
+		}
+	}
+}
+
+// publishCFSDiscovery publishes discovery for CFS box sensors the first time a box is seen
+func (p *Printer) publishCFSDiscovery(rawMsg map[string]any) {
+	bs, ok := rawMsg["boxState"].(map[string]any)
+	if !ok {
+		return
+	}
+	id := 0
+	switch v := bs["id"].(type) {
+	case float64:
+		id = int(v)
+	case int:
+		id = v
+	}
+	if id <= 0 {
+		return
+	}
+
+	p.discoveryMu.Lock()
+	defer p.discoveryMu.Unlock()
+	if p.discovery == nil || p.publishedCFS[id] {
+		return
+	}
+	for _, m := range p.discovery.CFSBox(id) {
+		log.Info("Publishing CFS discovery", "printer", p.Name(), "topic", m.Topic)
+		p.mqtt.Publish(m.Topic, m.Payload, m.Retain)
+	}
+	p.publishedCFS[id] = true
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CommandsConfig controls the inbound command topics
+type CommandsConfig struct {
+	// Params accepted on <base>/command/set, with optional value ranges
+	SetAllowlist map[string]ParamRange `yaml:"set_allowlist,omitempty"`
+	// G-code commands refused on <base>/gcode/send and by the gcode command,
+	// matched case-insensitively against the first word of every line
+	GcodeDenylist []string `yaml:"gcode_denylist,omitempty"`
+
+	// Highest heater targets accepted, below the maximum the printer reports (0 = printer limit)
+	MaxNozzleTemp float64 `yaml:"max_nozzle_temp,omitempty"`
+	MaxBedTemp    float64 `yaml:"max_bed_temp,omitempty"`
+	// ClampTemps lowers heater targets above the limit to the limit instead of rejecting them
+	ClampTemps bool `yaml:"clamp_temps,omitempty"`
+	// MinInterval is the minimum time between two commands on the same topic (0 = no limit)
+	MinInterval Duration `yaml:"min_interval,omitempty"`
+	// QueueTTL is how long a command waits for a disconnected printer before it
+	// expires (0 = refuse commands while disconnected)
+	QueueTTL Duration `yaml:"queue_ttl"`
+	// ReadOnly subscribes to no command topics and refuses API commands
+	ReadOnly bool `yaml:"read_only,omitempty"`
+}
+
+// DefaultGcodeDenylist are G-code commands that change the printer's stored
+// settings or firmware, refused unless the denylist is configured
+func DefaultGcodeDenylist() []string {
+	return []string{"M500", "M502", "M997", "SAVE_CONFIG", "FIRMWARE_RESTART"}
+}
+
+// DeniedGcode returns the command of a G-code line when it is in the denylist
+func (c CommandsConfig) DeniedGcode(line string) (string, bool) {
+	cmd, _ := GcodeCommand(line)
+	if cmd == "" {
+		return "", false
+	}
+	for _, denied := range c.GcodeDenylist {
+		if strings.EqualFold(cmd, denied) {
+			return cmd, true
+		}
+	}
+	return "", false
+}
+
+// numberedGcode matches a G, M or T command number followed by its params,
+// which may follow without a space (M104S200)
+var numberedGcode = regexp.MustCompile(`^([GMTgmt]\d+)([A-Za-z\s].*)?$`)
+
+// GcodeCommand splits a G-code line into its command and the rest of the line
+func GcodeCommand(line string) (cmd, params string) {
+	line = strings.TrimSpace(line)
+	if m := numberedGcode.FindStringSubmatch(line); m != nil {
+		return m[1], strings.TrimSpace(m[2])
+	}
+	if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+		return line[:i], strings.TrimSpace(line[i:])
+	}
+	return line, ""
+}
+
+// ParamRange limits the value of an allowed param. Without min and max any
+// value is accepted; with either one the value must be a number in range.
+type ParamRange struct {
+	Min *float64 `yaml:"min,omitempty"`
+	Max *float64 `yaml:"max,omitempty"`
+}
+
+// Check reports whether a decoded JSON value is within the range
+func (r ParamRange) Check(v any) error {
+	if r.Min == nil && r.Max == nil {
+		return nil
+	}
+	n, ok := v.(float64)
+	if !ok {
+		return fmt.Errorf("must be a number")
+	}
+	if r.Min != nil && n < *r.Min {
+		return fmt.Errorf("must be at least %g (got %g)", *r.Min, n)
+	}
+	if r.Max != nil && n > *r.Max {
+		return fmt.Errorf("must be at most %g (got %g)", *r.Max, n)
+	}
+	return nil
+}
+
+// ParseParamRules parses "key[=min:max]" allowlist entries, as given to --allow-set-param.
+// Either bound may be left empty, e.g. "fanCase=0:" or "lightSw".
+func ParseParamRules(specs []string) (map[string]ParamRange, error) {
+	rules := make(map[string]ParamRange, len(specs))
+	for _, spec := range specs {
+		key, bounds, hasRange := strings.Cut(spec, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("param %q: missing key", spec)
+		}
+
+		var r ParamRange
+		if hasRange {
+			lo, hi, ok := strings.Cut(bounds, ":")
+			if !ok {
+				return nil, fmt.Errorf("param %q: range must be min:max", spec)
+			}
+			var err error
+			if r.Min, err = parseBound(lo); err != nil {
+				return nil, fmt.Errorf("param %q: %w", spec, err)
+			}
+			if r.Max, err = parseBound(hi); err != nil {
+				return nil, fmt.Errorf("param %q: %w", spec, err)
+			}
+		}
+		rules[key] = r
+	}
+	return rules, nil
+}
+
+func parseBound(s string) (*float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bound %q", s)
+	}
+	return &v, nil
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func TestParseParamRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    map[string]ParamRange
+		wantErr string
+	}{
+		{
+			name:  "key only",
+			specs: []string{"lightSw"},
+			want:  map[string]ParamRange{"lightSw": {}},
+		},
+		{
+			name:  "ranges",
+			specs: []string{"lightSw=0:1", "fanCase=0:", "nozzleTemp=:300.5"},
+			want: map[string]ParamRange{
+				"lightSw":    {Min: ptr(0), Max: ptr(1)},
+				"fanCase":    {Min: ptr(0)},
+				"nozzleTemp": {Max: ptr(300.5)},
+			},
+		},
+		{name: "missing key", specs: []string{"=0:1"}, wantErr: "missing key"},
+		{name: "missing colon", specs: []string{"lightSw=1"}, wantErr: "min:max"},
+		{name: "invalid bound", specs: []string{"lightSw=0:on"}, wantErr: `invalid bound "on"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseParamRules(tt.specs)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParamRange_Check(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       ParamRange
+		value   any
+		wantErr string
+	}{
+		{name: "no range accepts anything", r: ParamRange{}, value: map[string]any{"num": 0.0}},
+		{name: "in range", r: ParamRange{Min: ptr(0), Max: ptr(1)}, value: 1.0},
+		{name: "below min", r: ParamRange{Min: ptr(0)}, value: -1.0, wantErr: "at least 0"},
+		{name: "above max", r: ParamRange{Max: ptr(300)}, value: 301.0, wantErr: "at most 300"},
+		{name: "not a number", r: ParamRange{Max: ptr(1)}, value: "1", wantErr: "must be a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.r.Check(tt.value)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestLoad_Commands(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+commands:
+  set_allowlist:
+    lightSw: {min: 0, max: 1}
+    fanCase: {}
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ParamRange{
+		"lightSw": {Min: ptr(0), Max: ptr(1)},
+		"fanCase": {},
+	}, cfg.Commands.SetAllowlist)
+
+	cfg.Commands.SetAllowlist["bad"] = ParamRange{Min: ptr(2), Max: ptr(1)}
+	var verr ValidationError
+	require.ErrorAs(t, cfg.Validate(), &verr)
+	assert.Equal(t, "commands.set_allowlist.bad", verr[0].Field)
+}
+
+func TestApplyEnv_SetAllowlist(t *testing.T) {
+	cfg := Default()
+	require.NoError(t, cfg.ApplyEnv(func(key string) (string, bool) {
+		if key == "CREALITY_SET_ALLOWLIST" {
+			return "lightSw=0:1, fanCase", true
+		}
+		return "", false
+	}))
+	assert.Equal(t, map[string]ParamRange{"lightSw": {Min: ptr(0), Max: ptr(1)}, "fanCase": {}}, cfg.Commands.SetAllowlist)
+
+	err := cfg.ApplyEnv(func(key string) (string, bool) {
+		return "lightSw=1", key == "CREALITY_SET_ALLOWLIST"
+	})
+	assert.ErrorContains(t, err, "CREALITY_SET_ALLOWLIST")
+}
+
+func TestCommandsConfig_DeniedGcode(t *testing.T) {
+	c := CommandsConfig{GcodeDenylist: DefaultGcodeDenylist()}
+
+	tests := []struct {
+		line   string
+		want   string
+		denied bool
+	}{
+		{"G28", "", false},
+		{"M502", "M502", true},
+		{"  m500 ; save", "m500", true},
+		{"save_config", "save_config", true},
+		{"M5020", "", false},
+		{"M502S0", "M502", true},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, denied := c.DeniedGcode(tt.line)
+			assert.Equal(t, tt.denied, denied)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGcodeCommand(t *testing.T) {
+	tests := []struct {
+		line, cmd, params string
+	}{
+		{"G28", "G28", ""},
+		{" M104 S200 T0 ", "M104", "S200 T0"},
+		{"M104S200", "M104", "S200"},
+		{"m140s60", "m140", "s60"},
+		{"SET_HEATER_TEMPERATURE\tHEATER=extruder TARGET=200", "SET_HEATER_TEMPERATURE", "HEATER=extruder TARGET=200"},
+		{"M104_CUSTOM S1", "M104_CUSTOM", "S1"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			cmd, params := GcodeCommand(tt.line)
+			assert.Equal(t, tt.cmd, cmd)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
+
+func TestLoad_GcodeDenylist(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+commands:
+  gcode_denylist: [M84, G28]
+`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"M84", "G28"}, cfg.Commands.GcodeDenylist)
+
+	cfg, err = Load("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultGcodeDenylist(), cfg.Commands.GcodeDenylist)
+
+	cfg.Commands.GcodeDenylist = []string{"M84", ""}
+	var verr ValidationError
+	require.ErrorAs(t, cfg.Validate(), &verr)
+	assert.Equal(t, "commands.gcode_denylist[1]", verr[0].Field)
+}
+
+func TestCommandsConfig_SafetySettings(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+commands:
+  max_nozzle_temp: 260
+  max_bed_temp: 90
+  clamp_temps: true
+  min_interval: 500ms
+  queue_ttl: 0s
+  read_only: true
+`))
+	require.NoError(t, err)
+	assert.Equal(t, CommandsConfig{
+		GcodeDenylist: DefaultGcodeDenylist(),
+		MaxNozzleTemp: 260,
+		MaxBedTemp:    90,
+		ClampTemps:    true,
+		MinInterval:   Duration(500 * time.Millisecond),
+		ReadOnly:      true,
+	}, cfg.Commands)
+	// Queueing is on by default
+	assert.Equal(t, Duration(30*time.Second), Default().Commands.QueueTTL)
+
+	env := map[string]string{
+		"CREALITY_MAX_NOZZLE_TEMP":      "250",
+		"CREALITY_CLAMP_TEMPS":          "false",
+		"CREALITY_COMMAND_MIN_INTERVAL": "2s",
+		"CREALITY_READ_ONLY":            "false",
+		"CREALITY_COMMAND_QUEUE_TTL":    "1m",
+	}
+	require.NoError(t, cfg.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}))
+	assert.Equal(t, float64(250), cfg.Commands.MaxNozzleTemp)
+	assert.Equal(t, float64(90), cfg.Commands.MaxBedTemp)
+	assert.False(t, cfg.Commands.ClampTemps)
+	assert.False(t, cfg.Commands.ReadOnly)
+	assert.Equal(t, Duration(2*time.Second), cfg.Commands.MinInterval)
+	assert.Equal(t, Duration(time.Minute), cfg.Commands.QueueTTL)
+
+	err = cfg.ApplyEnv(func(key string) (string, bool) {
+		switch key {
+		case "CREALITY_MAX_BED_TEMP":
+			return "hot", true
+		case "CREALITY_READ_ONLY":
+			return "maybe", true
+		}
+		return "", false
+	})
+	assert.ErrorContains(t, err, "CREALITY_MAX_BED_TEMP")
+	assert.ErrorContains(t, err, "CREALITY_READ_ONLY")
+
+	cfg.Commands.MaxNozzleTemp = -1
+	var verr ValidationError
+	require.ErrorAs(t, cfg.Validate(), &verr)
+	assert.Equal(t, "commands.max_nozzle_temp", verr[0].Field)
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the bridge.
+//
+// Values are resolved in order of precedence: flags > env > file > defaults.
+type Config struct {
+	LogLevel        string     `yaml:"log_level"`
+	DiscoveryPrefix string     `yaml:"discovery_prefix"`
+	HTTPAddr        string     `yaml:"http_addr"`           // HTTP server, empty disables it
+	APIToken        string     `yaml:"api_token,omitempty"` // bearer token required by the REST API
+	MQTT            MQTTConfig `yaml:"mqtt"`
+
+	Commands CommandsConfig `yaml:"commands,omitempty"`
+
+	// Single printer shorthand (mutually exclusive with Printers)
+	WSURL      string `yaml:"ws_url,omitempty"`
+	DeviceName string `yaml:"device_name,omitempty"`
+
+	Printers []PrinterConfig `yaml:"printers,omitempty"`
+}
+
+// MQTTConfig holds the MQTT broker connection and publishing settings
+type MQTTConfig struct {
+	Broker      string   `yaml:"broker"`
+	ClientID    string   `yaml:"client_id"`
+	Username    string   `yaml:"username,omitempty"`
+	Password    string   `yaml:"password,omitempty"`
+	BaseTopic   string   `yaml:"base_topic"`
+	MinInterval Duration `yaml:"min_interval"`
+
+	// ChangeOnly suppresses payloads identical to the last one published on a topic
+	ChangeOnly bool `yaml:"change_only"`
+	// RefreshInterval publishes an unchanged payload again once the last
+	// publish is this old, so idle topics stay alive (0 = never)
+	RefreshInterval Duration `yaml:"refresh_interval"`
+	// Deadbands suppress small numeric changes by topic pattern, the first match wins
+	Deadbands []Deadband `yaml:"deadbands,omitempty"`
+}
+
+// PrinterConfig holds the settings for one printer
+type PrinterConfig struct {
+	Name       string `yaml:"name"`
+	WSURL      string `yaml:"ws_url"`
+	BaseTopic  string `yaml:"base_topic,omitempty"`
+	DeviceName string `yaml:"device_name,omitempty"`
+}
+
+// Duration is a time.Duration that accepts Go duration strings ("1s", "500ms")
+// as well as a bare number of seconds ("60") for backwards compatibility.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	v, err := ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+// ParseDuration parses a Go duration string or a whole number of seconds
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q (use e.g. 1s, 500ms or a number of seconds)", s)
+	}
+	return d, nil
+}
+
+// Default returns the built-in defaults
+func Default() Config {
+	return Config{
+		LogLevel:        "info",
+		DiscoveryPrefix: "homeassistant",
+		HTTPAddr:        ":8080",
+		Commands: CommandsConfig{
+			GcodeDenylist: DefaultGcodeDenylist(),
+			QueueTTL:      Duration(30 * time.Second),
+		},
+		MQTT: MQTTConfig{
+			Broker:      "tcp://localhost:1883",
+			ClientID:    "creality2mqtt",
+			BaseTopic:   "creality/printer",
+			MinInterval: Duration(60 * time.Second),
+
+			RefreshInterval: Duration(5 * time.Minute),
+		},
+	}
+}
+
+// Load reads a YAML config file on top of the defaults.
+// An empty path returns the defaults. Unknown fields are rejected.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("read config file: %w", err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Write stores the config as YAML. The file may hold credentials, so it is
+// only readable by the owner.
+func Write(path string, cfg Config) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return fmt.Errorf("write config file: %w", err)
+	}
+	return nil
+}
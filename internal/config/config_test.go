@@ -0,0 +1,157 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+log_level: debug
+mqtt:
+  broker: tcp://broker:1883
+  min_interval: 500ms
+printers:
+  - name: k1a
+    ws_url: ws://10.0.0.5:9999/
+  - ws_url: ws://10.0.0.6:9999/
+    base_topic: custom/k1b
+    device_name: K1 B
+`)
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, "tcp://broker:1883", cfg.MQTT.Broker)
+	assert.Equal(t, Duration(500*time.Millisecond), cfg.MQTT.MinInterval)
+	// Defaults are kept for fields the file does not set
+	assert.Equal(t, "creality2mqtt", cfg.MQTT.ClientID)
+	assert.Equal(t, "homeassistant", cfg.DiscoveryPrefix)
+	require.Len(t, cfg.Printers, 2)
+	assert.Equal(t, "K1 B", cfg.Printers[1].DeviceName)
+}
+
+func TestLoad_EmptyPathReturnsDefaults(t *testing.T) {
+	cfg, err := Load("")
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoad_EmptyFile(t *testing.T) {
+	cfg, err := Load(writeConfig(t, ""))
+	require.NoError(t, err)
+	assert.Equal(t, Default(), cfg)
+}
+
+func TestLoad_RejectsUnknownFields(t *testing.T) {
+	_, err := Load(writeConfig(t, "mqtt:\n  brokr: tcp://broker:1883\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "brokr")
+}
+
+func TestLoad_InvalidDuration(t *testing.T) {
+	_, err := Load(writeConfig(t, "mqtt:\n  min_interval: soon\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid duration")
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"1s", time.Second, false},
+		{"500ms", 500 * time.Millisecond, false},
+		{"60", 60 * time.Second, false},
+		{"0", 0, false},
+		{" 2m ", 2 * time.Minute, false},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseDuration(tt.in)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"CREALITY_MQTT_BROKER":       "tcp://env:1883",
+		"CREALITY_MQTT_MIN_INTERVAL": "1s",
+		"CREALITY_PRINTERS":          "a=ws://10.0.0.5:9999/, b=ws://10.0.0.6:9999/",
+		"CREALITY_DEVICE_NAME":       "",
+	}
+	cfg := Default()
+	cfg.DeviceName = "from file"
+	require.NoError(t, cfg.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}))
+	assert.Equal(t, "tcp://env:1883", cfg.MQTT.Broker)
+	assert.Equal(t, Duration(time.Second), cfg.MQTT.MinInterval)
+	assert.Equal(t, "from file", cfg.DeviceName)
+	require.Len(t, cfg.Printers, 2)
+	assert.Equal(t, "b", cfg.Printers[1].Name)
+}
+
+func TestResolvedPrinters(t *testing.T) {
+	cfg := Default()
+	cfg.MQTT.BaseTopic = "3dprinter"
+	cfg.Printers = []PrinterConfig{
+		{WSURL: "ws://10.0.0.5:9999/"},
+		{Name: "K1 Max", WSURL: "ws://10.0.0.6:9999/", BaseTopic: "custom/max"},
+	}
+	got := cfg.ResolvedPrinters()
+	require.Len(t, got, 2)
+	assert.Equal(t, "10_0_0_5", got[0].Name)
+	assert.Equal(t, "3dprinter/10_0_0_5", got[0].BaseTopic)
+	assert.Equal(t, "k1_max", got[1].Name)
+	assert.Equal(t, "custom/max", got[1].BaseTopic)
+}
+
+func TestLoad_ExampleConfigIsValid(t *testing.T) {
+	cfg, err := Load(filepath.Join("..", "..", "config.example.yaml"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	assert.Len(t, cfg.ResolvedPrinters(), 2)
+}
+
+func TestWrite_RoundTrip(t *testing.T) {
+	cfg := Default()
+	cfg.MQTT.MinInterval = Duration(1500 * time.Millisecond)
+	cfg.Printers = []PrinterConfig{{Name: "k1", WSURL: "ws://10.0.0.5:9999/"}}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, Write(path, cfg))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "min_interval: 1.5s")
+	assert.NotContains(t, string(data), "ws_url: \"\"")
+
+	loaded, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, cfg, loaded)
+}
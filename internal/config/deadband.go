@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Deadband suppresses numeric payloads within ±Delta of the last one published
+// on topics matching Topic. The pattern is matched against the last levels of
+// the topic, e.g. "temperature/+/current" or "job/progress"; "+" matches a
+// single level.
+type Deadband struct {
+	Topic string  `yaml:"topic"`
+	Delta float64 `yaml:"delta"`
+}
+
+// ParseDeadbands parses "pattern=delta" entries, as given to --mqtt-deadband,
+// e.g. "temperature/+/current=0.5".
+func ParseDeadbands(specs []string) ([]Deadband, error) {
+	bands := make([]Deadband, 0, len(specs))
+	for _, spec := range specs {
+		topic, delta, ok := strings.Cut(spec, "=")
+		topic = strings.TrimSpace(topic)
+		if !ok || topic == "" {
+			return nil, fmt.Errorf("deadband %q: must be pattern=delta", spec)
+		}
+		d, err := strconv.ParseFloat(strings.TrimSpace(delta), 64)
+		if err != nil {
+			return nil, fmt.Errorf("deadband %q: invalid delta %q", spec, delta)
+		}
+		bands = append(bands, Deadband{Topic: topic, Delta: d})
+	}
+	return bands, nil
+}
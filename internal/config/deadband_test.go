@@ -0,0 +1,78 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeadbands(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []Deadband
+		wantErr string
+	}{
+		{
+			name:  "patterns",
+			specs: []string{"temperature/+/current=0.5", " job/progress = 1 "},
+			want:  []Deadband{{Topic: "temperature/+/current", Delta: 0.5}, {Topic: "job/progress", Delta: 1}},
+		},
+		{name: "none", specs: nil, want: []Deadband{}},
+		{name: "missing delta", specs: []string{"job/progress"}, wantErr: "pattern=delta"},
+		{name: "missing pattern", specs: []string{"=1"}, wantErr: "pattern=delta"},
+		{name: "invalid delta", specs: []string{"job/progress=one"}, wantErr: `invalid delta "one"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDeadbands(tt.specs)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoad_Deadbands(t *testing.T) {
+	cfg, err := Load(writeConfig(t, `
+mqtt:
+  change_only: true
+  refresh_interval: 10m
+  deadbands:
+    - topic: temperature/+/current
+      delta: 0.5
+    - topic: job/progress
+      delta: 1
+`))
+	require.NoError(t, err)
+	assert.True(t, cfg.MQTT.ChangeOnly)
+	assert.Equal(t, Duration(10*time.Minute), cfg.MQTT.RefreshInterval)
+	assert.Equal(t, []Deadband{{Topic: "temperature/+/current", Delta: 0.5}, {Topic: "job/progress", Delta: 1}}, cfg.MQTT.Deadbands)
+}
+
+func TestApplyEnv_Deadbands(t *testing.T) {
+	env := map[string]string{
+		"CREALITY_MQTT_CHANGE_ONLY":      "true",
+		"CREALITY_MQTT_REFRESH_INTERVAL": "0",
+		"CREALITY_MQTT_DEADBANDS":        "temperature/+/current=0.5, job/progress=1",
+	}
+	cfg := Default()
+	require.NoError(t, cfg.ApplyEnv(func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}))
+	assert.True(t, cfg.MQTT.ChangeOnly)
+	assert.Zero(t, cfg.MQTT.RefreshInterval)
+	assert.Len(t, cfg.MQTT.Deadbands, 2)
+
+	cfg = Default()
+	err := cfg.ApplyEnv(func(key string) (string, bool) {
+		return map[string]string{"CREALITY_MQTT_DEADBANDS": "job/progress"}[key], true
+	})
+	assert.ErrorContains(t, err, "CREALITY_MQTT_DEADBANDS")
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LookupFunc looks up an environment variable (os.LookupEnv)
+type LookupFunc func(key string) (string, bool)
+
+// ApplyEnv overlays CREALITY_* environment variables on top of the config.
+// Empty variables are ignored. Invalid values are reported as field errors.
+func (c *Config) ApplyEnv(lookup LookupFunc) error {
+	get := func(key string) (string, bool) {
+		v, ok := lookup(key)
+		if !ok || v == "" {
+			return "", false
+		}
+		return v, true
+	}
+
+	vars := []struct {
+		key string
+		dst *string
+	}{
+		{"CREALITY_LOG_LEVEL", &c.LogLevel},
+		{"CREALITY_DISCOVERY_PREFIX", &c.DiscoveryPrefix},
+		{"CREALITY_HTTP_ADDR", &c.HTTPAddr},
+		{"CREALITY_API_TOKEN", &c.APIToken},
+		{"CREALITY_MQTT_BROKER", &c.MQTT.Broker},
+		{"CREALITY_MQTT_CLIENT_ID", &c.MQTT.ClientID},
+		{"CREALITY_MQTT_USERNAME", &c.MQTT.Username},
+		{"CREALITY_MQTT_PASSWORD", &c.MQTT.Password},
+		{"CREALITY_MQTT_BASE_TOPIC", &c.MQTT.BaseTopic},
+		{"CREALITY_WS_URL", &c.WSURL},
+		{"CREALITY_DEVICE_NAME", &c.DeviceName},
+	}
+	for _, s := range vars {
+		if v, ok := get(s.key); ok {
+			*s.dst = v
+		}
+	}
+
+	// A single printer URL replaces a printers list from the file, and vice versa
+	_, hasWSURL := get("CREALITY_WS_URL")
+	specs, hasSpecs := get("CREALITY_PRINTERS")
+	if hasWSURL && !hasSpecs {
+		c.Printers = nil
+	}
+	if hasSpecs && !hasWSURL {
+		c.WSURL = ""
+	}
+
+	var errs ValidationError
+	if v, ok := get("CREALITY_MQTT_MIN_INTERVAL"); ok {
+		d, err := ParseDuration(v)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_MQTT_MIN_INTERVAL", Message: err.Error()})
+		} else {
+			c.MQTT.MinInterval = Duration(d)
+		}
+	}
+	if v, ok := get("CREALITY_MQTT_REFRESH_INTERVAL"); ok {
+		d, err := ParseDuration(v)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_MQTT_REFRESH_INTERVAL", Message: err.Error()})
+		} else {
+			c.MQTT.RefreshInterval = Duration(d)
+		}
+	}
+	if v, ok := get("CREALITY_MQTT_DEADBANDS"); ok {
+		bands, err := ParseDeadbands(SplitList(v))
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_MQTT_DEADBANDS", Message: err.Error()})
+		} else {
+			c.MQTT.Deadbands = bands
+		}
+	}
+	if v, ok := get("CREALITY_SET_ALLOWLIST"); ok {
+		rules, err := ParseParamRules(SplitList(v))
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_SET_ALLOWLIST", Message: err.Error()})
+		} else {
+			c.Commands.SetAllowlist = rules
+		}
+	}
+	if v, ok := get("CREALITY_GCODE_DENYLIST"); ok {
+		c.Commands.GcodeDenylist = SplitList(v)
+	}
+	for _, f := range []struct {
+		key string
+		dst *float64
+	}{
+		{"CREALITY_MAX_NOZZLE_TEMP", &c.Commands.MaxNozzleTemp},
+		{"CREALITY_MAX_BED_TEMP", &c.Commands.MaxBedTemp},
+	} {
+		if v, ok := get(f.key); ok {
+			n, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, FieldError{Field: f.key, Message: fmt.Sprintf("invalid number %q", v)})
+			} else {
+				*f.dst = n
+			}
+		}
+	}
+	for _, f := range []struct {
+		key string
+		dst *bool
+	}{
+		{"CREALITY_MQTT_CHANGE_ONLY", &c.MQTT.ChangeOnly},
+		{"CREALITY_CLAMP_TEMPS", &c.Commands.ClampTemps},
+		{"CREALITY_READ_ONLY", &c.Commands.ReadOnly},
+	} {
+		if v, ok := get(f.key); ok {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				errs = append(errs, FieldError{Field: f.key, Message: fmt.Sprintf("invalid boolean %q (use true or false)", v)})
+			} else {
+				*f.dst = b
+			}
+		}
+	}
+	if v, ok := get("CREALITY_COMMAND_MIN_INTERVAL"); ok {
+		d, err := ParseDuration(v)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_COMMAND_MIN_INTERVAL", Message: err.Error()})
+		} else {
+			c.Commands.MinInterval = Duration(d)
+		}
+	}
+	if v, ok := get("CREALITY_COMMAND_QUEUE_TTL"); ok {
+		d, err := ParseDuration(v)
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_COMMAND_QUEUE_TTL", Message: err.Error()})
+		} else {
+			c.Commands.QueueTTL = Duration(d)
+		}
+	}
+	if hasSpecs {
+		printers, err := ParsePrinterSpecs(SplitList(specs))
+		if err != nil {
+			errs = append(errs, FieldError{Field: "CREALITY_PRINTERS", Message: err.Error()})
+		} else {
+			c.Printers = printers
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// SplitList splits a comma-separated list into its non-empty, trimmed items
+func SplitList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ParsePrinterSpecs parses "[name=]ws-url" printer specs, as given to --printer
+func ParsePrinterSpecs(specs []string) ([]PrinterConfig, error) {
+	printers := make([]PrinterConfig, 0, len(specs))
+	for _, spec := range specs {
+		name, u, found := strings.Cut(spec, "=")
+		if !found || strings.Contains(name, "://") {
+			// No name given (or the "=" belongs to the URL)
+			name, u = "", spec
+		}
+		u = strings.TrimSpace(u)
+		if u == "" {
+			return nil, fmt.Errorf("printer %q: missing WebSocket URL", spec)
+		}
+		printers = append(printers, PrinterConfig{Name: strings.TrimSpace(name), WSURL: u})
+	}
+	return printers, nil
+}
+
+// ResolvedPrinters returns the printers to bridge with names, base topics and
+// device names filled in.
+//
+// The single-printer ws_url shorthand publishes under the base topic as-is.
+// With a printers list each printer publishes under "<base_topic>/<name>" unless
+// it sets its own base_topic. Names default to the sanitised printer host.
+func (c Config) ResolvedPrinters() []PrinterConfig {
+	if len(c.Printers) == 0 {
+		if c.WSURL == "" {
+			return nil
+		}
+		return []PrinterConfig{{
+			Name:       SanitizeName(PrinterHost(c.WSURL)),
+			WSURL:      c.WSURL,
+			BaseTopic:  c.MQTT.BaseTopic,
+			DeviceName: c.DeviceName,
+		}}
+	}
+
+	out := make([]PrinterConfig, 0, len(c.Printers))
+	for _, p := range c.Printers {
+		if p.Name == "" {
+			p.Name = PrinterHost(p.WSURL)
+		}
+		p.Name = SanitizeName(p.Name)
+		if p.BaseTopic == "" {
+			if len(c.Printers) == 1 {
+				p.BaseTopic = c.MQTT.BaseTopic
+			} else {
+				p.BaseTopic = c.MQTT.BaseTopic + "/" + p.Name
+			}
+		}
+		if p.DeviceName == "" && len(c.Printers) == 1 {
+			p.DeviceName = c.DeviceName
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// PrinterHost extracts the printer host (without port) from a WebSocket URL
+func PrinterHost(wsURL string) string {
+	u, err := url.Parse(wsURL)
+	if err != nil {
+		return ""
+	}
+	return strings.Split(u.Host, ":")[0]
+}
+
+// SanitizeName makes a printer name safe for use in MQTT topics
+func SanitizeName(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ' ', r == '-', r == '.':
+			return '_'
+		default:
+			return -1
+		}
+	}, name)
+}
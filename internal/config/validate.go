@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// FieldError describes an invalid configuration field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// ValidationError collects every invalid field of a config
+type ValidationError []FieldError
+
+func (v ValidationError) Error() string {
+	lines := make([]string, 0, len(v))
+	for _, fe := range v {
+		lines = append(lines, "  - "+fe.Error())
+	}
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+// Validate checks every field and returns a ValidationError listing all problems
+func (c Config) Validate() error {
+	var errs ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, err := log.ParseLevel(c.LogLevel); err != nil {
+		add("log_level", "must be one of debug, info, warn, error (got %q)", c.LogLevel)
+	}
+	if c.DiscoveryPrefix == "" {
+		add("discovery_prefix", "is required")
+	} else if hasWildcard(c.DiscoveryPrefix) {
+		add("discovery_prefix", "must not contain MQTT wildcards")
+	}
+
+	if c.HTTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+			add("http_addr", "must be a listen address like :8080 (got %q)", c.HTTPAddr)
+		}
+	}
+
+	if c.MQTT.Broker == "" {
+		add("mqtt.broker", "is required")
+	} else if u, err := url.Parse(c.MQTT.Broker); err != nil || u.Host == "" || !slices.Contains([]string{"tcp", "ssl", "tls", "mqtt", "mqtts", "ws", "wss"}, u.Scheme) {
+		add("mqtt.broker", "must be a URL like tcp://host:1883 (got %q)", c.MQTT.Broker)
+	}
+	if c.MQTT.ClientID == "" {
+		add("mqtt.client_id", "is required")
+	}
+	if c.MQTT.BaseTopic == "" {
+		add("mqtt.base_topic", "is required")
+	} else if hasWildcard(c.MQTT.BaseTopic) {
+		add("mqtt.base_topic", "must not contain MQTT wildcards")
+	}
+	if c.MQTT.MinInterval < 0 {
+		add("mqtt.min_interval", "must not be negative")
+	}
+	if c.MQTT.RefreshInterval < 0 {
+		add("mqtt.refresh_interval", "must not be negative")
+	}
+	if len(c.MQTT.Deadbands) > 0 && !c.MQTT.ChangeOnly {
+		// Deadbands are part of change-only publishing and do nothing without it
+		add("mqtt.deadbands", "require mqtt.change_only")
+	}
+	for i, band := range c.MQTT.Deadbands {
+		field := fmt.Sprintf("mqtt.deadbands[%d]", i)
+		if band.Topic == "" {
+			add(field+".topic", "is required")
+		} else if strings.Contains(band.Topic, "#") {
+			add(field+".topic", "must not contain # (use + for a single level)")
+		}
+		if band.Delta <= 0 {
+			add(field+".delta", "must be greater than 0")
+		}
+	}
+
+	if c.WSURL != "" {
+		if len(c.Printers) > 0 {
+			add("ws_url", "cannot be combined with printers")
+		} else if !validWSURL(c.WSURL) {
+			add("ws_url", "must be a ws:// or wss:// URL (got %q)", c.WSURL)
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(c.Commands.SetAllowlist)) {
+		r := c.Commands.SetAllowlist[key]
+		field := "commands.set_allowlist." + key
+		if strings.TrimSpace(key) == "" {
+			add("commands.set_allowlist", "param keys must not be empty")
+		} else if r.Min != nil && r.Max != nil && *r.Min > *r.Max {
+			add(field, "min %g is greater than max %g", *r.Min, *r.Max)
+		}
+	}
+
+	if c.Commands.MaxNozzleTemp < 0 {
+		add("commands.max_nozzle_temp", "must not be negative")
+	}
+	if c.Commands.MaxBedTemp < 0 {
+		add("commands.max_bed_temp", "must not be negative")
+	}
+	if c.Commands.MinInterval < 0 {
+		add("commands.min_interval", "must not be negative")
+	}
+	if c.Commands.QueueTTL < 0 {
+		add("commands.queue_ttl", "must not be negative")
+	}
+
+	for i, cmd := range c.Commands.GcodeDenylist {
+		if len(strings.Fields(cmd)) != 1 {
+			add(fmt.Sprintf("commands.gcode_denylist[%d]", i), "must be a single G-code command (got %q)", cmd)
+		}
+	}
+
+	names := map[string]bool{}
+	topics := map[string]bool{}
+	for i, p := range c.ResolvedPrinters() {
+		field := fmt.Sprintf("printers[%d]", i)
+		if len(c.Printers) == 0 {
+			field = "ws_url"
+		}
+		if len(c.Printers) > 0 && !validWSURL(p.WSURL) {
+			add(field+".ws_url", "must be a ws:// or wss:// URL (got %q)", p.WSURL)
+		}
+		if p.Name == "" {
+			add(field+".name", "is required when it cannot be derived from ws_url")
+		} else if names[p.Name] {
+			add(field+".name", "duplicate printer name %q", p.Name)
+			continue // the derived base topic would be reported as duplicate too
+		}
+		names[p.Name] = true
+		if hasWildcard(p.BaseTopic) {
+			add(field+".base_topic", "must not contain MQTT wildcards")
+		} else if topics[p.BaseTopic] {
+			add(field+".base_topic", "duplicate base topic %q", p.BaseTopic)
+		}
+		topics[p.BaseTopic] = true
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func hasWildcard(topic string) bool {
+	return strings.ContainsAny(topic, "+#")
+}
+
+func validWSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Host != "" && (u.Scheme == "ws" || u.Scheme == "wss")
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		mutate     func(c *Config)
+		wantFields []string
+	}{
+		{
+			name:   "defaults are valid",
+			mutate: func(c *Config) {},
+		},
+		{
+			name: "single printer is valid",
+			mutate: func(c *Config) {
+				c.WSURL = "ws://10.0.0.5:9999/"
+			},
+		},
+		{
+			name: "invalid top-level fields",
+			mutate: func(c *Config) {
+				c.LogLevel = "loud"
+				c.HTTPAddr = "8080"
+				c.MQTT.Broker = "localhost"
+				c.MQTT.ClientID = ""
+				c.MQTT.BaseTopic = "printers/#"
+				c.MQTT.MinInterval = -1
+			},
+			wantFields: []string{"log_level", "http_addr", "mqtt.broker", "mqtt.client_id", "mqtt.base_topic", "mqtt.min_interval"},
+		},
+		{
+			name: "invalid deadbands",
+			mutate: func(c *Config) {
+				c.MQTT.ChangeOnly = true
+				c.MQTT.RefreshInterval = -1
+				c.MQTT.Deadbands = []Deadband{
+					{Topic: "temperature/+/current", Delta: 0.5},
+					{Topic: "", Delta: 1},
+					{Topic: "job/#", Delta: 0},
+				}
+			},
+			wantFields: []string{"mqtt.refresh_interval", "mqtt.deadbands[1].topic", "mqtt.deadbands[2].topic", "mqtt.deadbands[2].delta"},
+		},
+		{
+			name: "deadbands without change-only publishing",
+			mutate: func(c *Config) {
+				c.MQTT.Deadbands = []Deadband{{Topic: "job/progress", Delta: 1}}
+			},
+			wantFields: []string{"mqtt.deadbands"},
+		},
+		{
+			name: "http server disabled",
+			mutate: func(c *Config) {
+				c.HTTPAddr = ""
+			},
+		},
+		{
+			name: "ws_url and printers together",
+			mutate: func(c *Config) {
+				c.WSURL = "ws://10.0.0.5:9999/"
+				c.Printers = []PrinterConfig{{Name: "a", WSURL: "ws://10.0.0.6:9999/"}}
+			},
+			wantFields: []string{"ws_url"},
+		},
+		{
+			name: "invalid printers",
+			mutate: func(c *Config) {
+				c.Printers = []PrinterConfig{
+					{Name: "a", WSURL: "http://10.0.0.5/"},
+					{Name: "a", WSURL: "ws://10.0.0.6:9999/"},
+					{Name: "c", WSURL: "ws://10.0.0.7:9999/", BaseTopic: "creality/printer/a"},
+				}
+			},
+			wantFields: []string{"printers[0].ws_url", "printers[1].name", "printers[2].base_topic"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.mutate(&cfg)
+			err := cfg.Validate()
+			if len(tt.wantFields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var verr ValidationError
+			require.True(t, errors.As(err, &verr), "expected ValidationError, got %v", err)
+			fields := make([]string, 0, len(verr))
+			for _, fe := range verr {
+				fields = append(fields, fe.Field)
+			}
+			assert.ElementsMatch(t, tt.wantFields, fields)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Watch polls the file at path and calls onChange whenever its content changes,
+// until the context is cancelled. Polling (rather than inotify) also catches
+// Kubernetes ConfigMap updates, which swap a symlink instead of writing the file.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func()) {
+	last, _ := fileHash(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h, err := fileHash(path)
+			if err != nil {
+				// The file may be mid-replace; keep the last known content
+				log.Debug("Failed to read config file", "path", path, "error", err)
+				continue
+			}
+			if h != last {
+				last = h
+				onChange()
+			}
+		}
+	}
+}
+
+func fileHash(path string) ([sha256.Size]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
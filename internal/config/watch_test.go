@@ -0,0 +1,42 @@
+package config
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	path := writeConfig(t, "log_level: info\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changed := make(chan struct{}, 10)
+	go Watch(ctx, path, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	// No change, no callback
+	select {
+	case <-changed:
+		t.Fatal("unexpected change notification")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte("log_level: debug\n"), 0o600))
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected change notification")
+	}
+
+	// A missing file is not reported as a change
+	require.NoError(t, os.Remove(path))
+	select {
+	case <-changed:
+		t.Fatal("unexpected change notification for missing file")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
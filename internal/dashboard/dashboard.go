@@ -0,0 +1,134 @@
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	"github.com/davidcollom/creality2mqtt/internal/httpserver"
+)
+
+//go:embed static
+var static embed.FS
+
+// MQTTStatus reports the broker connection (*mqttclient.Client)
+type MQTTStatus interface {
+	IsConnected() bool
+	Broker() string
+}
+
+// Snapshot is everything the dashboard shows
+type Snapshot struct {
+	Time     time.Time         `json:"time"`
+	MQTT     MQTTSnapshot      `json:"mqtt"`
+	Printers []PrinterSnapshot `json:"printers"`
+}
+
+// MQTTSnapshot is the broker connection state
+type MQTTSnapshot struct {
+	Broker    string `json:"broker"`
+	Connected bool   `json:"connected"`
+}
+
+// PrinterSnapshot is the state of one bridged printer
+type PrinterSnapshot struct {
+	bridge.Status
+	WSURL     string              `json:"ws_url"`
+	BaseTopic string              `json:"base_topic"`
+	Values    []bridge.TopicValue `json:"values"`
+	Entities  []bridge.Entity     `json:"entities"`
+}
+
+// Dashboard serves the embedded status page and its live data
+type Dashboard struct {
+	bridge   *bridge.Bridge
+	mqtt     MQTTStatus
+	interval time.Duration
+	mux      *http.ServeMux
+}
+
+// New creates a Dashboard for the bridge. Mount it on "/". When token is set,
+// the state and events endpoints need the same bearer token as the REST API;
+// the page itself holds no data and asks for the token.
+func New(b *bridge.Bridge, mqtt MQTTStatus, token string) *Dashboard {
+	d := &Dashboard{
+		bridge:   b,
+		mqtt:     mqtt,
+		interval: 2 * time.Second,
+		mux:      http.NewServeMux(),
+	}
+	assets, _ := fs.Sub(static, "static") // static is always embedded
+	d.mux.Handle("GET /{$}", http.FileServerFS(assets))
+	d.mux.Handle("GET /assets/", http.StripPrefix("/assets/", http.FileServerFS(assets)))
+	d.mux.Handle("GET /dashboard/state", httpserver.RequireToken(token, http.HandlerFunc(d.handleState)))
+	d.mux.Handle("GET /dashboard/events", httpserver.RequireToken(token, http.HandlerFunc(d.handleEvents)))
+	return d
+}
+
+// ServeHTTP implements http.Handler
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// Snapshot collects the current state of the broker connection and every printer
+func (d *Dashboard) Snapshot() Snapshot {
+	s := Snapshot{
+		Time:     time.Now(),
+		MQTT:     MQTTSnapshot{Broker: d.mqtt.Broker(), Connected: d.mqtt.IsConnected()},
+		Printers: []PrinterSnapshot{},
+	}
+	for _, p := range d.bridge.Printers() {
+		cfg := p.Config()
+		s.Printers = append(s.Printers, PrinterSnapshot{
+			Status:    p.Status(),
+			WSURL:     cfg.WSURL,
+			BaseTopic: cfg.BaseTopic,
+			Values:    p.Values(),
+			Entities:  p.Entities(),
+		})
+	}
+	return s
+}
+
+func (d *Dashboard) handleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(d.Snapshot()); err != nil {
+		log.Warn("Failed to write dashboard state", "error", err)
+	}
+}
+
+// handleEvents streams a snapshot as a server-sent event every interval
+func (d *Dashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		data, err := json.Marshal(d.Snapshot())
+		if err != nil {
+			log.Warn("Failed to encode dashboard state", "error", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
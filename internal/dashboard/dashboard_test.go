@@ -0,0 +1,147 @@
+package dashboard
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/bridge"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeMQTT struct{}
+
+func (fakeMQTT) IsConnected() bool                           { return true }
+func (fakeMQTT) Broker() string                              { return "tcp://broker:1883" }
+func (fakeMQTT) Publish(topic, payload string, retain bool)  {}
+func (fakeMQTT) PublishNow(topic, payload string)            {}
+func (fakeMQTT) Subscribe(string, mqtt.MessageHandler) error { return nil }
+func (fakeMQTT) Unsubscribe(string) error                    { return nil }
+
+func newTestDashboard(t *testing.T, token string) *Dashboard {
+	t.Helper()
+	b := bridge.New(fakeMQTT{}, "ha", []bridge.PrinterConfig{
+		{Name: "k1", WSURL: "ws://127.0.0.1:1/", BaseTopic: "bt/k1"},
+	})
+	b.Printers()[0].HandleMessage([]byte(`{"deviceId":"dev","nozzleTemp":"200.0"}`))
+	return New(b, fakeMQTT{}, token)
+}
+
+func TestDashboard_Snapshot(t *testing.T) {
+	s := newTestDashboard(t, "").Snapshot()
+
+	assert.Equal(t, MQTTSnapshot{Broker: "tcp://broker:1883", Connected: true}, s.MQTT)
+	require.Len(t, s.Printers, 1)
+	p := s.Printers[0]
+	assert.Equal(t, "k1", p.Name)
+	assert.Equal(t, "dev", p.DeviceID)
+	assert.True(t, p.DiscoveryPublished)
+	assert.Equal(t, "bt/k1", p.BaseTopic)
+
+	values := map[string]string{}
+	for _, v := range p.Values {
+		values[v.Topic] = v.Payload
+	}
+	assert.Equal(t, "200.000", values["bt/k1/temperature/nozzle/current"])
+
+	assert.Contains(t, p.Entities, bridge.Entity{
+		Component:   "sensor",
+		UniqueID:    "dev_printer_status",
+		Name:        "Printer Status",
+		ConfigTopic: "ha/sensor/dev/printer_status/config",
+	})
+}
+
+func TestDashboard_Routes(t *testing.T) {
+	d := newTestDashboard(t, "")
+
+	tests := []struct {
+		path        string
+		wantCode    int
+		wantType    string
+		wantContent string
+	}{
+		{"/", http.StatusOK, "text/html", "<title>creality2mqtt</title>"},
+		{"/assets/app.js", http.StatusOK, "javascript", "EventSource"},
+		{"/assets/style.css", http.StatusOK, "text/css", "--bg"},
+		{"/dashboard/state", http.StatusOK, "application/json", `"device_id":"dev"`},
+		{"/nope", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			d.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Contains(t, rec.Header().Get("Content-Type"), tt.wantType)
+			assert.Contains(t, rec.Body.String(), tt.wantContent)
+		})
+	}
+}
+
+func TestDashboard_BearerToken(t *testing.T) {
+	d := newTestDashboard(t, "s3cret")
+
+	tests := []struct {
+		path     string
+		token    string
+		wantCode int
+	}{
+		// The page holds no data and stays public
+		{"/", "", http.StatusOK},
+		{"/assets/app.js", "", http.StatusOK},
+		{"/dashboard/state", "", http.StatusUnauthorized},
+		{"/dashboard/state", "wrong", http.StatusUnauthorized},
+		{"/dashboard/state", "s3cret", http.StatusOK},
+		{"/dashboard/events", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.token, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			d.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestDashboard_Events(t *testing.T) {
+	d := newTestDashboard(t, "")
+	d.interval = 10 * time.Millisecond
+	server := httptest.NewServer(d)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/dashboard/events", nil)
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Read two events to see the stream is live
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	events := 0
+	for events < 2 && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var s Snapshot
+		require.NoError(t, json.Unmarshal([]byte(data), &s))
+		assert.Len(t, s.Printers, 1)
+		events++
+	}
+	assert.Equal(t, 2, events)
+}
@@ -0,0 +1,134 @@
+// Live view of the bridge state, fed by server-sent events from /dashboard/events,
+// or by polling /dashboard/state when the bridge requires an API token.
+(function () {
+  "use strict";
+
+  const printersEl = document.getElementById("printers");
+  const mqttEl = document.getElementById("mqtt");
+  const updatedEl = document.getElementById("updated");
+  let previous = {};
+
+  function el(tag, attrs, ...children) {
+    const node = document.createElement(tag);
+    for (const [k, v] of Object.entries(attrs || {})) {
+      node.setAttribute(k, v);
+    }
+    for (const child of children) {
+      node.append(child);
+    }
+    return node;
+  }
+
+  function badge(label, ok) {
+    return el("span", { class: "badge " + (ok ? "ok" : "bad") }, label);
+  }
+
+  function valuesTable(printer) {
+    const seen = previous[printer.name] || {};
+    const next = {};
+    const body = el("tbody");
+    for (const v of printer.values) {
+      next[v.topic] = v.payload;
+      const row = el("tr", {},
+        el("td", { class: "topic" }, v.topic),
+        el("td", { class: "mono" }, v.payload),
+        el("td", { class: "muted" }, new Date(v.updated).toLocaleTimeString()));
+      if (seen[v.topic] !== undefined && seen[v.topic] !== v.payload) {
+        row.classList.add("changed");
+      }
+      body.append(row);
+    }
+    previous[printer.name] = next;
+    return el("table", {},
+      el("thead", {}, el("tr", {}, el("th", {}, "Topic"), el("th", {}, "Value"), el("th", {}, "Updated"))),
+      body);
+  }
+
+  function entitiesTable(printer) {
+    const body = el("tbody");
+    for (const e of printer.entities) {
+      body.append(el("tr", {},
+        el("td", {}, e.component),
+        el("td", {}, e.name),
+        el("td", { class: "mono" }, e.unique_id)));
+    }
+    return el("table", {},
+      el("thead", {}, el("tr", {}, el("th", {}, "Component"), el("th", {}, "Name"), el("th", {}, "Unique ID"))),
+      body);
+  }
+
+  function render(state) {
+    mqttEl.className = "badge " + (state.mqtt.connected ? "ok" : "bad");
+    mqttEl.textContent = "MQTT " + state.mqtt.broker + ": " + (state.mqtt.connected ? "connected" : "disconnected");
+    updatedEl.textContent = "Updated " + new Date(state.time).toLocaleTimeString();
+
+    printersEl.replaceChildren(...state.printers.map((p) => el("section", { class: "printer" },
+      el("h2", {}, p.name + (p.device_id ? " (" + p.device_id + ")" : "")),
+      el("div", { class: "badges" },
+        badge("WebSocket " + (p.ws_connected ? "connected" : "disconnected"), p.ws_connected),
+        badge("Discovery " + (p.discovery_published ? "published" : "pending"), p.discovery_published),
+        el("span", { class: "muted" }, p.ws_url + " → " + p.base_topic)),
+      el("h3", {}, "Latest values (" + p.values.length + ")"),
+      valuesTable(p),
+      el("h3", {}, "Discovery entities (" + p.entities.length + ")"),
+      entitiesTable(p))));
+  }
+
+  // With an api_token set the data endpoints need the bearer token. EventSource
+  // can't send it, so the page asks for the token and polls the state instead.
+  let token = sessionStorage.getItem("token");
+
+  function unreachable() {
+    mqttEl.className = "badge bad";
+    mqttEl.textContent = "Bridge unreachable, retrying…";
+  }
+
+  function poll() {
+    fetch("dashboard/state", { headers: { Authorization: "Bearer " + token } })
+      .then((resp) => {
+        if (resp.status === 401) {
+          token = null;
+          sessionStorage.removeItem("token");
+          return null;
+        }
+        return resp.ok ? resp.json() : Promise.reject(resp.status);
+      })
+      .then((state) => {
+        if (state) {
+          render(state);
+        }
+      })
+      .catch(unreachable)
+      .finally(() => (token ? setTimeout(poll, 2000) : login()));
+  }
+
+  function login() {
+    token = window.prompt("API token");
+    if (token) {
+      sessionStorage.setItem("token", token);
+      poll();
+    } else {
+      mqttEl.className = "badge bad";
+      mqttEl.textContent = "API token required, reload to enter it";
+    }
+  }
+
+  function connect() {
+    const events = new EventSource("dashboard/events");
+    events.onmessage = (msg) => render(JSON.parse(msg.data));
+    events.onerror = () => {
+      unreachable();
+      // EventSource gives up on an error response, e.g. a missing token
+      if (events.readyState === EventSource.CLOSED) {
+        const retry = () => setTimeout(connect, 2000);
+        fetch("dashboard/state").then((resp) => (resp.status === 401 ? login() : retry()), retry);
+      }
+    };
+  }
+
+  if (token) {
+    poll();
+  } else {
+    connect();
+  }
+})();
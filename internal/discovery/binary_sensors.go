@@ -8,19 +8,17 @@ import (
 )
 
 // BuildPrintingSensor creates the printing binary sensor discovery message
-func BuildPrintingSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildPrintingSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	printingTopic := fmt.Sprintf("%s/binary_sensor/%s/printing/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	printingConfig := BinarySensorConfig{
-		Name:              "Printing",
-		UniqueID:          fmt.Sprintf("%s_printing", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/printing", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		PayloadOn:         "true",
-		PayloadOff:        "false",
-		Icon:              "mdi:printer-3d",
-		Device:            device,
+		Name:         "Printing",
+		UniqueID:     fmt.Sprintf("%s_printing", cfg.DeviceID),
+		StateTopic:   fmt.Sprintf("%s/printing", cfg.BaseTopic),
+		Availability: avail,
+		PayloadOn:    "true",
+		PayloadOff:   "false",
+		Icon:         "mdi:printer-3d",
+		Device:       device,
 	}
 
 	payload, _ := json.Marshal(printingConfig)
@@ -32,19 +30,17 @@ func BuildPrintingSensor(cfg Config, device *Device, availTopic string) []types.
 }
 
 // BuildLightSensor creates the light binary sensor discovery message
-func BuildLightSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildLightSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	lightTopic := fmt.Sprintf("%s/binary_sensor/%s/light/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	lightConfig := BinarySensorConfig{
-		Name:              "Light",
-		UniqueID:          fmt.Sprintf("%s_light", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/light_sw", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		PayloadOn:         "1",
-		PayloadOff:        "0",
-		Icon:              "mdi:lightbulb",
-		Device:            device,
+		Name:         "Light",
+		UniqueID:     fmt.Sprintf("%s_light", cfg.DeviceID),
+		StateTopic:   fmt.Sprintf("%s/light_sw", cfg.BaseTopic),
+		Availability: avail,
+		PayloadOn:    "1",
+		PayloadOff:   "0",
+		Icon:         "mdi:lightbulb",
+		Device:       device,
 	}
 
 	payload, _ := json.Marshal(lightConfig)
@@ -56,19 +52,17 @@ func BuildLightSensor(cfg Config, device *Device, availTopic string) []types.Mqt
 }
 
 // BuildPartFanSensor creates the part cooling fan binary sensor discovery message
-func BuildPartFanSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildPartFanSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	partFanTopic := fmt.Sprintf("%s/binary_sensor/%s/part_fan/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	partFanConfig := BinarySensorConfig{
-		Name:              "Part Cooling Fan",
-		UniqueID:          fmt.Sprintf("%s_part_fan", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/fan", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		PayloadOn:         "1",
-		PayloadOff:        "0",
-		Icon:              "mdi:fan",
-		Device:            device,
+		Name:         "Part Cooling Fan",
+		UniqueID:     fmt.Sprintf("%s_part_fan", cfg.DeviceID),
+		StateTopic:   fmt.Sprintf("%s/fan", cfg.BaseTopic),
+		Availability: avail,
+		PayloadOn:    "1",
+		PayloadOff:   "0",
+		Icon:         "mdi:fan",
+		Device:       device,
 	}
 
 	payload, _ := json.Marshal(partFanConfig)
@@ -11,7 +11,7 @@ import (
 func TestBuildPrintingSensor(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildPrintingSensor(cfg, device, "bt/availability")
+	msgs := BuildPrintingSensor(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 1, len(msgs))
 	require.Equal(t, true, msgs[0].Retain)
 	var bc BinarySensorConfig
@@ -24,7 +24,7 @@ func TestBuildPrintingSensor(t *testing.T) {
 func TestBuildPartFanSensor(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildPartFanSensor(cfg, device, "bt/availability")
+	msgs := BuildPartFanSensor(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 1, len(msgs))
 	var bc BinarySensorConfig
 	_ = json.Unmarshal([]byte(msgs[0].Payload), &bc)
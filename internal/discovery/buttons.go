@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// CancelConfirmPayload must be sent to the cancel command topic to cancel a print.
+// The Cancel Print button does not send it: a single tap must not cancel a
+// print, the confirm comes from a confirmed action (see the README).
+const CancelConfirmPayload = "CANCEL"
+
+// ButtonConfig represents a Home Assistant MQTT button configuration
+type ButtonConfig struct {
+	Name         string `json:"name"`
+	UniqueID     string `json:"unique_id"`
+	CommandTopic string `json:"command_topic"`
+	PayloadPress string `json:"payload_press,omitempty"`
+	Availability
+	Icon   string  `json:"icon,omitempty"`
+	Device *Device `json:"device"`
+}
+
+// BuildJobButtons creates the pause, resume and cancel print buttons.
+// The cancel button leaves payload_press out, so pressing it sends Home
+// Assistant's default payload, which the cancel command refuses.
+func BuildJobButtons(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
+	messages := []types.MqttMessage{}
+
+	buttons := []struct {
+		name    string
+		action  string
+		payload string
+		icon    string
+	}{
+		{"Pause Print", "pause", "PRESS", "mdi:pause"},
+		{"Resume Print", "resume", "PRESS", "mdi:play"},
+		{"Cancel Print", "cancel", "", "mdi:stop"},
+	}
+
+	for _, b := range buttons {
+		uniqueID := fmt.Sprintf("%s_print", b.action)
+		config := ButtonConfig{
+			Name:         b.name,
+			UniqueID:     fmt.Sprintf("%s_%s", cfg.DeviceID, uniqueID),
+			CommandTopic: topics.JobCommand(b.action),
+			PayloadPress: b.payload,
+			Availability: avail,
+			Icon:         b.icon,
+			Device:       device,
+		}
+
+		payload, _ := json.Marshal(config)
+		messages = append(messages, types.MqttMessage{
+			Topic:   topics.Discovery("button", cfg.DeviceID, uniqueID),
+			Payload: string(payload),
+			Retain:  true,
+		})
+	}
+
+	return messages
+}
@@ -0,0 +1,45 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestBuildJobButtons(t *testing.T) {
+	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
+	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+	msgs := BuildJobButtons(cfg, device, NewAvailability("bt/status"))
+	require.Len(t, msgs, 3)
+
+	tests := []struct {
+		topic, uniqueID, command, payload string
+	}{
+		{"ha/button/dev/pause_print/config", "dev_pause_print", "bt/command/pause", "PRESS"},
+		{"ha/button/dev/resume_print/config", "dev_resume_print", "bt/command/resume", "PRESS"},
+		// No payload_press: pressing it does not send the confirm
+		{"ha/button/dev/cancel_print/config", "dev_cancel_print", "bt/command/cancel", ""},
+	}
+	for i, tt := range tests {
+		t.Run(tt.uniqueID, func(t *testing.T) {
+			assert.Equal(t, tt.topic, msgs[i].Topic)
+			assert.True(t, msgs[i].Retain)
+			var bc ButtonConfig
+			require.NoError(t, json.Unmarshal([]byte(msgs[i].Payload), &bc))
+			assert.Equal(t, tt.uniqueID, bc.UniqueID)
+			assert.Equal(t, tt.command, bc.CommandTopic)
+			assert.Equal(t, tt.payload, bc.PayloadPress)
+			if tt.payload == "" {
+				assert.NotContains(t, msgs[i].Payload, "payload_press")
+			}
+		})
+	}
+}
+
+func TestCleanupOldEntities_KeepsCancelButton(t *testing.T) {
+	for _, m := range CleanupOldEntities(Config{DiscoveryPrefix: "ha", DeviceID: "dev"}) {
+		assert.NotEqual(t, "ha/button/dev/cancel_print/config", m.Topic)
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // BuildCameraSensors creates camera-related sensor discovery messages
-func BuildCameraSensors(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildCameraSensors(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	if cfg.PrinterIP == "" {
 		return nil
 	}
@@ -21,14 +21,12 @@ func BuildCameraSensors(cfg Config, device *Device, availTopic string) []types.M
 	// Camera stream URL sensor
 	streamURLTopic := topics.Discovery("sensor", cfg.DeviceID, "camera_stream_url")
 	streamConfig := SensorConfig{
-		Name:              "Camera Stream URL",
-		UniqueID:          fmt.Sprintf("%s_camera_stream_url", cfg.DeviceID),
-		StateTopic:        topics.CameraStreamURL(),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		Icon:              "mdi:video",
-		Device:            device,
+		Name:         "Camera Stream URL",
+		UniqueID:     fmt.Sprintf("%s_camera_stream_url", cfg.DeviceID),
+		StateTopic:   topics.CameraStreamURL(),
+		Availability: avail,
+		Icon:         "mdi:video",
+		Device:       device,
 	}
 	payload, _ := json.Marshal(streamConfig)
 	messages = append(messages, types.MqttMessage{
@@ -51,16 +49,14 @@ func BuildCameraSensors(cfg Config, device *Device, availTopic string) []types.M
 	// Video stream active binary sensor
 	videoTopic := fmt.Sprintf("%s/binary_sensor/%s/video_stream/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	videoConfig := BinarySensorConfig{
-		Name:              "Camera Stream Active",
-		UniqueID:          fmt.Sprintf("%s_video_stream", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/video", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		PayloadOn:         "1",
-		PayloadOff:        "0",
-		Icon:              "mdi:video",
-		Device:            device,
+		Name:         "Camera Stream Active",
+		UniqueID:     fmt.Sprintf("%s_video_stream", cfg.DeviceID),
+		StateTopic:   fmt.Sprintf("%s/video", cfg.BaseTopic),
+		Availability: avail,
+		PayloadOn:    "1",
+		PayloadOff:   "0",
+		Icon:         "mdi:video",
+		Device:       device,
 	}
 	payload, _ = json.Marshal(videoConfig)
 	messages = append(messages, types.MqttMessage{
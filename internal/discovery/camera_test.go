@@ -10,7 +10,7 @@ import (
 func TestBuildCameraSensors(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev", PrinterIP: "10.0.0.5"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildCameraSensors(cfg, device, "bt/availability")
+	msgs := BuildCameraSensors(cfg, device, NewAvailability("bt/availability"))
 	require.Equal(t, true, len(msgs) >= 2)
 	// First is discovery for sensor
 	var sc SensorConfig
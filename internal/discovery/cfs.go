@@ -8,7 +8,7 @@ import (
 )
 
 // BuildCFSBoxSensors builds HA discovery for a CFS box id (humidity, temperature)
-func BuildCFSBoxSensors(cfg Config, device *Device, availTopic string, id int) []types.MqttMessage {
+func BuildCFSBoxSensors(cfg Config, device *Device, avail Availability, id int) []types.MqttMessage {
 	messages := []types.MqttMessage{}
 
 	// humidity sensor
@@ -18,9 +18,7 @@ func BuildCFSBoxSensors(cfg Config, device *Device, availTopic string, id int) [
 		Name:              fmt.Sprintf("CFS %d Humidity", id),
 		UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, humUID),
 		StateTopic:        fmt.Sprintf("%s/cfs/%d/humidity", cfg.BaseTopic, id),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
+		Availability:      avail,
 		UnitOfMeasurement: "%",
 		DeviceClass:       "humidity",
 		StateClass:        "measurement",
@@ -37,9 +35,7 @@ func BuildCFSBoxSensors(cfg Config, device *Device, availTopic string, id int) [
 		Name:              fmt.Sprintf("CFS %d Temperature", id),
 		UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, tempUID),
 		StateTopic:        fmt.Sprintf("%s/cfs/%d/temperature", cfg.BaseTopic, id),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
+		Availability:      avail,
 		UnitOfMeasurement: "°C",
 		DeviceClass:       "temperature",
 		StateClass:        "measurement",
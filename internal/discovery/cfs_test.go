@@ -11,7 +11,7 @@ import (
 func TestBuildCFSBoxSensors(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildCFSBoxSensors(cfg, device, "bt/availability", 1)
+	msgs := BuildCFSBoxSensors(cfg, device, NewAvailability("bt/availability"), 1)
 	assert.Equal(t, 2, len(msgs))
 	// Validate humidity
 	var hum SensorConfig
@@ -48,6 +48,9 @@ func CleanupOldEntities(cfg Config) []types.MqttMessage {
 		"camera_stream",     // If camera was previously a sensor
 		"camera_stream_url", // If you had this before camera discovery
 		"last_seen",         // If you had this before camera discovery
+		"model_fan_pct",     // Fan speeds are now fan entities with the same unique_id
+		"auxiliary_fan_pct",
+		"case_fan_pct",
 	}
 
 	// Remove old sensors
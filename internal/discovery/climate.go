@@ -0,0 +1,91 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// Targets set when a heater's climate entity is switched from off to heat
+const (
+	PreheatNozzleTemp = 200
+	PreheatBedTemp    = 60
+)
+
+// ClimateConfig represents a Home Assistant MQTT climate configuration
+type ClimateConfig struct {
+	Name                    string   `json:"name"`
+	UniqueID                string   `json:"unique_id"`
+	Modes                   []string `json:"modes"`
+	ModeStateTopic          string   `json:"mode_state_topic"`
+	ModeStateTemplate       string   `json:"mode_state_template"`
+	ModeCommandTopic        string   `json:"mode_command_topic"`
+	ModeCommandTemplate     string   `json:"mode_command_template"`
+	CurrentTemperatureTopic string   `json:"current_temperature_topic"`
+	TemperatureStateTopic   string   `json:"temperature_state_topic"`
+	TemperatureCommandTopic string   `json:"temperature_command_topic"`
+	Availability
+	MinTemp         float64 `json:"min_temp"`
+	MaxTemp         float64 `json:"max_temp"`
+	TempStep        float64 `json:"temp_step"`
+	Precision       float64 `json:"precision"`
+	TemperatureUnit string  `json:"temperature_unit"`
+	Icon            string  `json:"icon,omitempty"`
+	Device          *Device `json:"device"`
+}
+
+// BuildTemperatureClimates creates the nozzle and bed thermostats. They combine
+// the current and target temperature sensors with the target commands of the
+// temperature numbers: a heater is "heat" while its target is above 0, "off"
+// sets the target to 0 and "heat" to the preheat temperature.
+func BuildTemperatureClimates(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
+	messages := []types.MqttMessage{}
+
+	climates := []struct {
+		name         string
+		heater       string
+		commandTopic string
+		uniqueID     string
+		max          float64
+		preheat      float64
+		icon         string
+	}{
+		{"Nozzle", "nozzle", topics.NozzleTargetCommand(), "nozzle_climate", NozzleTempLimit(cfg.MaxNozzleTemp), PreheatNozzleTemp, "mdi:printer-3d-nozzle-heat"},
+		{"Bed", "bed0", topics.BedTargetCommand(), "bed_climate", BedTempLimit(cfg.MaxBedTemp), PreheatBedTemp, "mdi:radiator"},
+	}
+
+	for _, c := range climates {
+		targetTopic := fmt.Sprintf("%s/temperature/%s/target", cfg.BaseTopic, c.heater)
+		config := ClimateConfig{
+			Name:                    c.name,
+			UniqueID:                fmt.Sprintf("%s_%s", cfg.DeviceID, c.uniqueID),
+			Modes:                   []string{"off", "heat"},
+			ModeStateTopic:          targetTopic,
+			ModeStateTemplate:       "{{ 'heat' if value | float(0) > 0 else 'off' }}",
+			ModeCommandTopic:        c.commandTopic,
+			ModeCommandTemplate:     fmt.Sprintf("{{ %g if value == 'heat' else 0 }}", min(c.preheat, c.max)),
+			CurrentTemperatureTopic: fmt.Sprintf("%s/temperature/%s/current", cfg.BaseTopic, c.heater),
+			TemperatureStateTopic:   targetTopic,
+			TemperatureCommandTopic: c.commandTopic,
+			Availability:            avail,
+			MinTemp:                 0,
+			MaxTemp:                 c.max,
+			TempStep:                1,
+			Precision:               0.1,
+			TemperatureUnit:         "C",
+			Icon:                    c.icon,
+			Device:                  device,
+		}
+
+		payload, _ := json.Marshal(config)
+		messages = append(messages, types.MqttMessage{
+			Topic:   topics.Discovery("climate", cfg.DeviceID, c.uniqueID),
+			Payload: string(payload),
+			Retain:  true,
+		})
+	}
+
+	return messages
+}
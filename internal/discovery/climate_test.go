@@ -0,0 +1,58 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestBuildTemperatureClimates(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        Config
+		nozzleMax  float64
+		bedMax     float64
+		bedPreheat string
+	}{
+		{"defaults", Config{}, DefaultMaxNozzleTemp, DefaultMaxBedTemp, "{{ 60 if value == 'heat' else 0 }}"},
+		{"printer reported", Config{MaxNozzleTemp: 320, MaxBedTemp: 50}, 320, 50, "{{ 50 if value == 'heat' else 0 }}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DiscoveryPrefix, cfg.BaseTopic, cfg.DeviceID = "ha", "bt", "dev"
+			device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+
+			msgs := BuildTemperatureClimates(cfg, device, NewAvailability("bt/status"))
+			require.Len(t, msgs, 2)
+
+			var nozzle, bed ClimateConfig
+			require.NoError(t, json.Unmarshal([]byte(msgs[0].Payload), &nozzle))
+			require.NoError(t, json.Unmarshal([]byte(msgs[1].Payload), &bed))
+
+			assert.Equal(t, "ha/climate/dev/nozzle_climate/config", msgs[0].Topic)
+			assert.True(t, msgs[0].Retain)
+			assert.Equal(t, "dev_nozzle_climate", nozzle.UniqueID)
+			assert.Equal(t, []string{"off", "heat"}, nozzle.Modes)
+			assert.Equal(t, "bt/temperature/nozzle/current", nozzle.CurrentTemperatureTopic)
+			assert.Equal(t, "bt/temperature/nozzle/target", nozzle.TemperatureStateTopic)
+			assert.Equal(t, "bt/temperature/nozzle/target", nozzle.ModeStateTopic)
+			assert.Equal(t, "bt/temperature/nozzle/target/set", nozzle.TemperatureCommandTopic)
+			assert.Equal(t, "bt/temperature/nozzle/target/set", nozzle.ModeCommandTopic)
+			assert.Equal(t, "{{ 200 if value == 'heat' else 0 }}", nozzle.ModeCommandTemplate)
+			assert.Equal(t, tt.nozzleMax, nozzle.MaxTemp)
+			assert.Equal(t, "C", nozzle.TemperatureUnit)
+			assert.Equal(t, []string{"dev"}, nozzle.Device.Identifiers)
+
+			assert.Equal(t, "ha/climate/dev/bed_climate/config", msgs[1].Topic)
+			assert.Equal(t, "bt/temperature/bed0/current", bed.CurrentTemperatureTopic)
+			assert.Equal(t, "bt/temperature/bed0/target", bed.TemperatureStateTopic)
+			assert.Equal(t, "bt/temperature/bed0/target/set", bed.TemperatureCommandTopic)
+			assert.Equal(t, tt.bedPreheat, bed.ModeCommandTemplate)
+			assert.Equal(t, tt.bedMax, bed.MaxTemp)
+		})
+	}
+}
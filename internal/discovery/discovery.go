@@ -5,38 +5,111 @@ import (
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
-var discoverMessages []types.MqttMessage
+// Builder builds the discovery messages of one printer. The message set is
+// built once per config, so regenerating never duplicates entries and every
+// printer owns its own set. It is not safe for concurrent use.
+type Builder struct {
+	cfg      Config
+	messages []types.MqttMessage
+}
 
-// GenerateDiscoveryMessages creates Home Assistant MQTT Discovery messages
-func GenerateDiscoveryMessages(cfg Config) []types.MqttMessage {
-	device := &Device{
-		Identifiers:  []string{cfg.DeviceID},
-		Name:         cfg.DeviceName,
+// NewBuilder returns a Builder for the printer described by cfg
+func NewBuilder(cfg Config) *Builder {
+	b := &Builder{}
+	b.SetConfig(cfg)
+	return b
+}
+
+// Config returns the config the messages are built from
+func (b *Builder) Config() Config {
+	return b.cfg
+}
+
+// SetConfig rebuilds the message set for a new config
+func (b *Builder) SetConfig(cfg Config) {
+	b.cfg = cfg
+	b.messages = b.build()
+	log.Info("Generated MQTT Discovery messages", "count", len(b.messages))
+}
+
+// Device returns the Home Assistant device the entities belong to
+func (b *Builder) Device() *Device {
+	return &Device{
+		Identifiers:  []string{b.cfg.DeviceID},
+		Name:         b.cfg.DeviceName,
 		Manufacturer: "Creality",
-		Model:        cfg.DeviceModel,
+		Model:        b.cfg.DeviceModel,
 	}
+}
+
+// Messages returns the discovery messages of the printer, without CFS boxes.
+// The slice is shared and must not be modified.
+func (b *Builder) Messages() []types.MqttMessage {
+	return b.messages
+}
+
+// TemperatureControls returns the discovery messages that depend on the
+// temperature limits: the target temperature numbers and the climates
+func (b *Builder) TemperatureControls() []types.MqttMessage {
+	device, avail := b.Device(), b.availability()
+	msgs := BuildTemperatureNumbers(b.cfg, device, avail)
+	return append(msgs, BuildTemperatureClimates(b.cfg, device, avail)...)
+}
 
-	// Build availability topic (consistent with LWT)
-	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
-	availTopic := topics.Availability()
+// CFSBox returns the discovery messages of a CFS box
+func (b *Builder) CFSBox(id int) []types.MqttMessage {
+	return BuildCFSBoxSensors(b.cfg, b.Device(), b.availability(), id)
+}
+
+// Cleanup returns the messages removing entities of older versions
+func (b *Builder) Cleanup() []types.MqttMessage {
+	return CleanupOldEntities(b.cfg)
+}
+
+// availability follows the bridge's Last Will and the printer's own status
+func (b *Builder) availability() Availability {
+	printer := types.NewTopicBuilder(b.cfg.BaseTopic, b.cfg.DiscoveryPrefix).Availability()
+	return NewAvailability(b.cfg.WillTopic, printer)
+}
+
+func (b *Builder) build() []types.MqttMessage {
+	cfg, device, avail := b.cfg, b.Device(), b.availability()
+
+	var discoverMessages []types.MqttMessage
 
 	// Build all sensor discovery messages
-	discoverMessages = append(discoverMessages, BuildTemperatureSensors(cfg, device, availTopic)...)
-	discoverMessages = append(discoverMessages, BuildStatusSensor(cfg, device, availTopic)...)
-	discoverMessages = append(discoverMessages, BuildFanSensors(cfg, device, availTopic)...)
-	discoverMessages = append(discoverMessages, BuildProgressSensor(cfg, device, availTopic)...)
-	discoverMessages = append(discoverMessages, BuildFeedStateSensor(cfg, device, availTopic)...)
+	discoverMessages = append(discoverMessages, BuildTemperatureSensors(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildTemperatureClimates(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildStatusSensor(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildLifecycleSensor(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildProgressSensor(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildFeedStateSensor(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildQueueSensor(cfg, device, avail)...)
 
 	// Build binary sensor discovery messages
-	discoverMessages = append(discoverMessages, BuildPrintingSensor(cfg, device, availTopic)...)
-	discoverMessages = append(discoverMessages, BuildPartFanSensor(cfg, device, availTopic)...)
+	discoverMessages = append(discoverMessages, BuildPrintingSensor(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildPartFanSensor(cfg, device, avail)...)
 
 	// Build switch discovery messages (bidirectional control)
-	discoverMessages = append(discoverMessages, BuildLightSwitch(cfg, device, availTopic)...)
+	discoverMessages = append(discoverMessages, BuildLightSwitch(cfg, device, avail)...)
+
+	// Build button discovery messages (print job control)
+	discoverMessages = append(discoverMessages, BuildJobButtons(cfg, device, avail)...)
+
+	// Build fan discovery messages (speed control)
+	discoverMessages = append(discoverMessages, BuildFans(cfg, device, avail)...)
+
+	// Build number discovery messages (target temperatures and print tuning)
+	discoverMessages = append(discoverMessages, BuildTemperatureNumbers(cfg, device, avail)...)
+	discoverMessages = append(discoverMessages, BuildTuningNumbers(cfg, device, avail)...)
 
 	// Build camera-related discovery messages
-	discoverMessages = append(discoverMessages, BuildCameraSensors(cfg, device, availTopic)...)
+	discoverMessages = append(discoverMessages, BuildCameraSensors(cfg, device, avail)...)
 
-	log.Info("Generated MQTT Discovery messages", "count", len(discoverMessages))
 	return discoverMessages
 }
+
+// GenerateDiscoveryMessages creates Home Assistant MQTT Discovery messages
+func GenerateDiscoveryMessages(cfg Config) []types.MqttMessage {
+	return NewBuilder(cfg).Messages()
+}
@@ -38,3 +38,88 @@ func TestGenerateDiscoveryMessages(t *testing.T) {
 	}
 	require.Equal(t, true, found)
 }
+
+func TestGenerateDiscoveryMessages_NoDuplicatesOnRegeneration(t *testing.T) {
+	cfg := Config{DiscoveryPrefix: "homeassistant", BaseTopic: "printer/test", DeviceID: "dev1"}
+	first := GenerateDiscoveryMessages(cfg)
+	second := GenerateDiscoveryMessages(cfg)
+	assert.Equal(t, len(first), len(second))
+}
+
+func TestBuilder(t *testing.T) {
+	k1 := NewBuilder(Config{DiscoveryPrefix: "homeassistant", BaseTopic: "printer/k1", DeviceID: "k1", DeviceName: "K1"})
+	k2 := NewBuilder(Config{DiscoveryPrefix: "homeassistant", BaseTopic: "printer/k2", DeviceID: "k2"})
+
+	msgs := k1.Messages()
+	require.NotEmpty(t, msgs)
+	assert.Equal(t, GenerateDiscoveryMessages(k1.Config()), msgs)
+	assert.Equal(t, &Device{Identifiers: []string{"k1"}, Name: "K1", Manufacturer: "Creality"}, k1.Device())
+
+	// Rebuilding replaces the set
+	cfg := k1.Config()
+	cfg.MaxNozzleTemp = 280
+	k1.SetConfig(cfg)
+	assert.Len(t, k1.Messages(), len(msgs))
+
+	controls := k1.TemperatureControls()
+	require.Len(t, controls, 4)
+	var nozzle NumberConfig
+	require.NoError(t, json.Unmarshal([]byte(controls[0].Payload), &nozzle))
+	assert.Equal(t, 280.0, nozzle.Max)
+	assert.Equal(t, NewAvailability("printer/k1/status"), nozzle.Availability)
+
+	// Every printer has its own set
+	for _, m := range k2.Messages() {
+		assert.Contains(t, m.Topic, "/k2/")
+	}
+
+	box := k2.CFSBox(1)
+	require.NotEmpty(t, box)
+	var sc SensorConfig
+	require.NoError(t, json.Unmarshal([]byte(box[0].Payload), &sc))
+	assert.Equal(t, NewAvailability("printer/k2/status"), sc.Availability)
+	assert.Equal(t, []string{"k2"}, sc.Device.Identifiers)
+}
+
+func TestBuilder_AvailabilityFollowsTheLastWill(t *testing.T) {
+	b := NewBuilder(Config{DiscoveryPrefix: "homeassistant", BaseTopic: "printer/k1", DeviceID: "k1", WillTopic: "printer/status"})
+
+	msgs := append(b.Messages(), b.CFSBox(1)...)
+	require.NotEmpty(t, msgs)
+	for _, m := range msgs {
+		var payload map[string]any
+		require.NoError(t, json.Unmarshal([]byte(m.Payload), &payload), m.Topic)
+		if _, ok := payload["unique_id"]; !ok {
+			continue // camera stream URL
+		}
+		assert.Equal(t, "all", payload["availability_mode"], m.Topic)
+		assert.Equal(t, []any{
+			map[string]any{"topic": "printer/status", "payload_available": "online", "payload_not_available": "offline"},
+			map[string]any{"topic": "printer/k1/status", "payload_available": "online", "payload_not_available": "offline"},
+		}, payload["availability"], m.Topic)
+		assert.NotContains(t, payload, "availability_topic", m.Topic)
+	}
+}
+
+func TestNewAvailability(t *testing.T) {
+	tests := []struct {
+		name   string
+		topics []string
+		want   []string
+	}{
+		{"bridge and printer", []string{"bt/status", "bt/k1/status"}, []string{"bt/status", "bt/k1/status"}},
+		{"printer on the bridge topic", []string{"bt/status", "bt/status"}, []string{"bt/status"}},
+		{"no Last Will", []string{"", "bt/k1/status"}, []string{"bt/k1/status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avail := NewAvailability(tt.topics...)
+			assert.Equal(t, "all", avail.AvailabilityMode)
+			var got []string
+			for _, a := range avail.Availability {
+				got = append(got, a.Topic)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
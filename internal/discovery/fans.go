@@ -0,0 +1,72 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// FanConfig represents a Home Assistant MQTT fan configuration
+type FanConfig struct {
+	Name                   string `json:"name"`
+	UniqueID               string `json:"unique_id"`
+	StateTopic             string `json:"state_topic"`
+	StateValueTemplate     string `json:"state_value_template,omitempty"`
+	CommandTopic           string `json:"command_topic"`
+	PercentageStateTopic   string `json:"percentage_state_topic"`
+	PercentageCommandTopic string `json:"percentage_command_topic"`
+	SpeedRangeMin          int    `json:"speed_range_min"`
+	SpeedRangeMax          int    `json:"speed_range_max"`
+	PayloadOn              string `json:"payload_on"`
+	PayloadOff             string `json:"payload_off"`
+	Availability
+	Icon   string  `json:"icon,omitempty"`
+	Device *Device `json:"device"`
+}
+
+// BuildFans creates the model, auxiliary and case fan discovery messages.
+// They keep the unique IDs of the former read-only fan speed sensors.
+func BuildFans(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
+	messages := []types.MqttMessage{}
+
+	fans := []struct {
+		name string
+		fan  string
+	}{
+		{"Model Fan", "model"},
+		{"Auxiliary Fan", "auxiliary"},
+		{"Case Fan", "case"},
+	}
+
+	for _, f := range fans {
+		uniqueID := fmt.Sprintf("%s_fan_pct", f.fan)
+		config := FanConfig{
+			Name:     f.name,
+			UniqueID: fmt.Sprintf("%s_%s", cfg.DeviceID, uniqueID),
+			// The printer only reports the speed, a fan is on while it is above 0
+			StateTopic:             topics.FanPercentage(f.fan),
+			StateValueTemplate:     "{{ 'ON' if value | float(0) > 0 else 'OFF' }}",
+			CommandTopic:           topics.FanCommand(f.fan),
+			PercentageStateTopic:   topics.FanPercentage(f.fan),
+			PercentageCommandTopic: topics.FanCommand(f.fan),
+			SpeedRangeMin:          1,
+			SpeedRangeMax:          100,
+			PayloadOn:              "ON",
+			PayloadOff:             "OFF",
+			Availability:           avail,
+			Icon:                   "mdi:fan",
+			Device:                 device,
+		}
+
+		payload, _ := json.Marshal(config)
+		messages = append(messages, types.MqttMessage{
+			Topic:   topics.Discovery("fan", cfg.DeviceID, uniqueID),
+			Payload: string(payload),
+			Retain:  true,
+		})
+	}
+
+	return messages
+}
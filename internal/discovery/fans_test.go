@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestBuildFans(t *testing.T) {
+	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
+	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+	msgs := BuildFans(cfg, device, NewAvailability("bt/status"))
+	require.Len(t, msgs, 3)
+
+	tests := []struct {
+		topic, uniqueID, state, command string
+	}{
+		{"ha/fan/dev/model_fan_pct/config", "dev_model_fan_pct", "bt/model_fan_pct", "bt/fan/model/set"},
+		{"ha/fan/dev/auxiliary_fan_pct/config", "dev_auxiliary_fan_pct", "bt/auxiliary_fan_pct", "bt/fan/auxiliary/set"},
+		{"ha/fan/dev/case_fan_pct/config", "dev_case_fan_pct", "bt/case_fan_pct", "bt/fan/case/set"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.uniqueID, func(t *testing.T) {
+			assert.Equal(t, tt.topic, msgs[i].Topic)
+			assert.True(t, msgs[i].Retain)
+			var fc FanConfig
+			require.NoError(t, json.Unmarshal([]byte(msgs[i].Payload), &fc))
+			assert.Equal(t, tt.uniqueID, fc.UniqueID)
+			assert.Equal(t, tt.state, fc.StateTopic)
+			assert.Equal(t, tt.state, fc.PercentageStateTopic)
+			assert.Equal(t, tt.command, fc.CommandTopic)
+			assert.Equal(t, tt.command, fc.PercentageCommandTopic)
+			assert.Equal(t, 1, fc.SpeedRangeMin)
+			assert.Equal(t, 100, fc.SpeedRangeMax)
+		})
+	}
+}
+
+func TestCleanupOldEntities_RemovesFanSensors(t *testing.T) {
+	msgs := CleanupOldEntities(Config{DiscoveryPrefix: "ha", DeviceID: "dev"})
+	topics := map[string]bool{}
+	for _, m := range msgs {
+		topics[m.Topic] = true
+	}
+	assert.True(t, topics["ha/sensor/dev/model_fan_pct/config"])
+	assert.True(t, topics["ha/sensor/dev/auxiliary_fan_pct/config"])
+	assert.True(t, topics["ha/sensor/dev/case_fan_pct/config"])
+	assert.False(t, topics["ha/fan/dev/model_fan_pct/config"])
+}
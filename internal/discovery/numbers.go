@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// Fallback temperature limits for printers that do not report maxNozzleTemp/maxBedTemp
+const (
+	DefaultMaxNozzleTemp = 300
+	DefaultMaxBedTemp    = 100
+)
+
+// TuningControl is a print tuning setting the printer reports and accepts while printing
+type TuningControl struct {
+	Key      string // printer field, e.g. "curFeedratePct"
+	Subtopic string // topic the mapper publishes the field on, under the base topic
+	Name     string
+	Unit     string
+	Min      float64
+	Max      float64
+	Step     float64
+	Icon     string
+}
+
+// TuningControls are the tuning settings announced as number entities
+var TuningControls = []TuningControl{
+	{Key: "curFeedratePct", Subtopic: "cur_feedrate_pct", Name: "Print Speed", Unit: "%", Min: 10, Max: 300, Step: 1, Icon: "mdi:speedometer"},
+	{Key: "curFlowratePct", Subtopic: "cur_flowrate_pct", Name: "Flow Rate", Unit: "%", Min: 50, Max: 150, Step: 1, Icon: "mdi:water-percent"},
+	{Key: "pressureAdvance", Subtopic: "pressure_advance", Name: "Pressure Advance", Unit: "s", Min: 0, Max: 1, Step: 0.001, Icon: "mdi:arrow-collapse-right"},
+	{Key: "velocityLimits", Subtopic: "velocity_limits", Name: "Velocity Limit", Unit: "mm/s", Min: 10, Max: 1000, Step: 1, Icon: "mdi:speedometer-medium"},
+}
+
+// LookupTuningControl returns the tuning control for a printer field
+func LookupTuningControl(key string) (TuningControl, bool) {
+	for _, c := range TuningControls {
+		if c.Key == key {
+			return c, true
+		}
+	}
+	return TuningControl{}, false
+}
+
+// NumberConfig represents a Home Assistant MQTT number configuration
+type NumberConfig struct {
+	Name         string `json:"name"`
+	UniqueID     string `json:"unique_id"`
+	StateTopic   string `json:"state_topic"`
+	CommandTopic string `json:"command_topic"`
+	Availability
+	Min               float64 `json:"min"`
+	Max               float64 `json:"max"`
+	Step              float64 `json:"step"`
+	Mode              string  `json:"mode,omitempty"`
+	UnitOfMeasurement string  `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string  `json:"device_class,omitempty"`
+	Icon              string  `json:"icon,omitempty"`
+	Device            *Device `json:"device"`
+}
+
+// NozzleTempLimit returns the highest nozzle target for a printer-reported maximum (0 if unknown)
+func NozzleTempLimit(reported float64) float64 {
+	if reported > 0 {
+		return reported
+	}
+	return DefaultMaxNozzleTemp
+}
+
+// BedTempLimit returns the highest bed target for a printer-reported maximum (0 if unknown)
+func BedTempLimit(reported float64) float64 {
+	if reported > 0 {
+		return reported
+	}
+	return DefaultMaxBedTemp
+}
+
+// BuildTemperatureNumbers creates the nozzle and bed target temperature controls.
+// They share the device of the temperature sensors and are bounded by the
+// printer-reported maximum temperatures.
+func BuildTemperatureNumbers(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
+	messages := []types.MqttMessage{}
+
+	numbers := []struct {
+		name         string
+		stateTopic   string
+		commandTopic string
+		uniqueID     string
+		max          float64
+		icon         string
+	}{
+		{"Nozzle Target", fmt.Sprintf("%s/temperature/nozzle/target", cfg.BaseTopic), topics.NozzleTargetCommand(), "nozzle_temp_setpoint", NozzleTempLimit(cfg.MaxNozzleTemp), "mdi:printer-3d-nozzle-heat"},
+		{"Bed Target", fmt.Sprintf("%s/temperature/bed0/target", cfg.BaseTopic), topics.BedTargetCommand(), "bed_temp_setpoint", BedTempLimit(cfg.MaxBedTemp), "mdi:radiator"},
+	}
+
+	for _, n := range numbers {
+		config := NumberConfig{
+			Name:              n.name,
+			UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, n.uniqueID),
+			StateTopic:        n.stateTopic,
+			CommandTopic:      n.commandTopic,
+			Availability:      avail,
+			Min:               0,
+			Max:               n.max,
+			Step:              1,
+			Mode:              "box",
+			UnitOfMeasurement: "°C",
+			DeviceClass:       "temperature",
+			Icon:              n.icon,
+			Device:            device,
+		}
+
+		payload, _ := json.Marshal(config)
+		messages = append(messages, types.MqttMessage{
+			Topic:   topics.Discovery("number", cfg.DeviceID, n.uniqueID),
+			Payload: string(payload),
+			Retain:  true,
+		})
+	}
+
+	return messages
+}
+
+// BuildTuningNumbers creates the live print tuning controls (speed, flow,
+// pressure advance and velocity limit)
+func BuildTuningNumbers(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	topics := types.NewTopicBuilder(cfg.BaseTopic, cfg.DiscoveryPrefix)
+	messages := []types.MqttMessage{}
+
+	for _, c := range TuningControls {
+		config := NumberConfig{
+			Name:              c.Name,
+			UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, c.Subtopic),
+			StateTopic:        topics.Data(c.Subtopic),
+			CommandTopic:      topics.TuningCommand(c.Subtopic),
+			Availability:      avail,
+			Min:               c.Min,
+			Max:               c.Max,
+			Step:              c.Step,
+			Mode:              "box",
+			UnitOfMeasurement: c.Unit,
+			Icon:              c.Icon,
+			Device:            device,
+		}
+
+		payload, _ := json.Marshal(config)
+		messages = append(messages, types.MqttMessage{
+			Topic:   topics.Discovery("number", cfg.DeviceID, c.Subtopic),
+			Payload: string(payload),
+			Retain:  true,
+		})
+	}
+
+	return messages
+}
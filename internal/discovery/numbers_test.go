@@ -0,0 +1,89 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+
+	assert "github.com/stretchr/testify/assert"
+	require "github.com/stretchr/testify/require"
+)
+
+func TestBuildTemperatureNumbers(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       Config
+		nozzleMax float64
+		bedMax    float64
+	}{
+		{"defaults", Config{}, DefaultMaxNozzleTemp, DefaultMaxBedTemp},
+		{"printer reported", Config{MaxNozzleTemp: 320, MaxBedTemp: 115}, 320, 115},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.DiscoveryPrefix, cfg.BaseTopic, cfg.DeviceID = "ha", "bt", "dev"
+			device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+
+			msgs := BuildTemperatureNumbers(cfg, device, NewAvailability("bt/status"))
+			require.Len(t, msgs, 2)
+
+			var nozzle, bed NumberConfig
+			require.NoError(t, json.Unmarshal([]byte(msgs[0].Payload), &nozzle))
+			require.NoError(t, json.Unmarshal([]byte(msgs[1].Payload), &bed))
+
+			assert.Equal(t, "ha/number/dev/nozzle_temp_setpoint/config", msgs[0].Topic)
+			assert.True(t, msgs[0].Retain)
+			assert.Equal(t, "dev_nozzle_temp_setpoint", nozzle.UniqueID)
+			assert.Equal(t, "bt/temperature/nozzle/target", nozzle.StateTopic)
+			assert.Equal(t, "bt/temperature/nozzle/target/set", nozzle.CommandTopic)
+			assert.Equal(t, tt.nozzleMax, nozzle.Max)
+			assert.Equal(t, []string{"dev"}, nozzle.Device.Identifiers)
+
+			assert.Equal(t, "ha/number/dev/bed_temp_setpoint/config", msgs[1].Topic)
+			assert.Equal(t, "bt/temperature/bed0/target", bed.StateTopic)
+			assert.Equal(t, "bt/temperature/bed0/target/set", bed.CommandTopic)
+			assert.Equal(t, tt.bedMax, bed.Max)
+			assert.Equal(t, float64(0), bed.Min)
+		})
+	}
+}
+
+func TestBuildTuningNumbers(t *testing.T) {
+	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
+	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+	msgs := BuildTuningNumbers(cfg, device, NewAvailability("bt/status"))
+	require.Len(t, msgs, len(TuningControls))
+
+	tests := []struct {
+		subtopic string
+		unit     string
+		min, max float64
+		step     float64
+	}{
+		{"cur_feedrate_pct", "%", 10, 300, 1},
+		{"cur_flowrate_pct", "%", 50, 150, 1},
+		{"pressure_advance", "s", 0, 1, 0.001},
+		{"velocity_limits", "mm/s", 10, 1000, 1},
+	}
+	for i, tt := range tests {
+		t.Run(tt.subtopic, func(t *testing.T) {
+			assert.Equal(t, "ha/number/dev/"+tt.subtopic+"/config", msgs[i].Topic)
+			var nc NumberConfig
+			require.NoError(t, json.Unmarshal([]byte(msgs[i].Payload), &nc))
+			assert.Equal(t, "dev_"+tt.subtopic, nc.UniqueID)
+			assert.Equal(t, "bt/"+tt.subtopic, nc.StateTopic)
+			assert.Equal(t, "bt/"+tt.subtopic+"/set", nc.CommandTopic)
+			assert.Equal(t, tt.unit, nc.UnitOfMeasurement)
+			assert.Equal(t, tt.min, nc.Min)
+			assert.Equal(t, tt.max, nc.Max)
+			assert.Equal(t, tt.step, nc.Step)
+		})
+	}
+
+	c, ok := LookupTuningControl("pressureAdvance")
+	require.True(t, ok)
+	assert.Equal(t, "pressure_advance", c.Subtopic)
+	_, ok = LookupTuningControl("nozzleTemp")
+	assert.False(t, ok)
+}
@@ -4,11 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/davidcollom/creality2mqtt/internal/mapper"
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
 // BuildTemperatureSensors creates temperature sensor discovery messages
-func BuildTemperatureSensors(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildTemperatureSensors(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	messages := []types.MqttMessage{}
 
 	tempSensors := []struct {
@@ -28,9 +29,7 @@ func BuildTemperatureSensors(cfg Config, device *Device, availTopic string) []ty
 			Name:              ts.name,
 			UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, ts.uniqueID),
 			StateTopic:        ts.stateTopic,
-			AvailabilityTopic: availTopic,
-			PayloadAvailable:  "online",
-			PayloadNotAvail:   "offline",
+			Availability:      avail,
 			UnitOfMeasurement: "°C",
 			DeviceClass:       "temperature",
 			StateClass:        "measurement",
@@ -50,19 +49,17 @@ func BuildTemperatureSensors(cfg Config, device *Device, availTopic string) []ty
 }
 
 // BuildFeedStateSensor creates the extruder feed state sensor
-func BuildFeedStateSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildFeedStateSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	messages := []types.MqttMessage{}
 
 	configTopic := fmt.Sprintf("%s/sensor/%s/feed_state/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	config := SensorConfig{
-		Name:              "Feed State",
-		UniqueID:          fmt.Sprintf("%s_feed_state", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/feed_state", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		Icon:              "mdi:printer-3d-nozzle",
-		Device:            device,
+		Name:         "Feed State",
+		UniqueID:     fmt.Sprintf("%s_feed_state", cfg.DeviceID),
+		StateTopic:   fmt.Sprintf("%s/feed_state", cfg.BaseTopic),
+		Availability: avail,
+		Icon:         "mdi:printer-3d-nozzle",
+		Device:       device,
 	}
 
 	payload, _ := json.Marshal(config)
@@ -76,19 +73,18 @@ func BuildFeedStateSensor(cfg Config, device *Device, availTopic string) []types
 }
 
 // BuildStatusSensor creates printer status sensor (idle/active)
-func BuildStatusSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildStatusSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	messages := []types.MqttMessage{}
 
 	configTopic := fmt.Sprintf("%s/sensor/%s/printer_status/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	config := SensorConfig{
-		Name:              "Printer Status",
-		UniqueID:          fmt.Sprintf("%s_printer_status", cfg.DeviceID),
-		StateTopic:        fmt.Sprintf("%s/printer_status", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
-		Icon:              "mdi:printer-3d",
-		Device:            device,
+		Name:                "Printer Status",
+		UniqueID:            fmt.Sprintf("%s_printer_status", cfg.DeviceID),
+		StateTopic:          fmt.Sprintf("%s/printer_status", cfg.BaseTopic),
+		Availability:        avail,
+		Icon:                "mdi:printer-3d",
+		JSONAttributesTopic: fmt.Sprintf("%s/state", cfg.BaseTopic),
+		Device:              device,
 	}
 
 	payload, _ := json.Marshal(config)
@@ -101,57 +97,65 @@ func BuildStatusSensor(cfg Config, device *Device, availTopic string) []types.Mq
 	return messages
 }
 
-// BuildFanSensors creates fan speed sensor discovery messages
-func BuildFanSensors(cfg Config, device *Device, availTopic string) []types.MqttMessage {
-	messages := []types.MqttMessage{}
-
-	fanSensors := []struct {
-		name       string
-		stateTopic string
-		uniqueID   string
-		icon       string
-	}{
-		{"Model Fan Speed", fmt.Sprintf("%s/model_fan_pct", cfg.BaseTopic), "model_fan_pct", "mdi:fan"},
-		{"Auxiliary Fan Speed", fmt.Sprintf("%s/auxiliary_fan_pct", cfg.BaseTopic), "auxiliary_fan_pct", "mdi:fan"},
-		{"Case Fan Speed", fmt.Sprintf("%s/case_fan_pct", cfg.BaseTopic), "case_fan_pct", "mdi:fan"},
+// BuildLifecycleSensor creates the print lifecycle enum sensor, with the
+// previous state and the time of the last transition as attributes
+func BuildLifecycleSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	configTopic := fmt.Sprintf("%s/sensor/%s/print_lifecycle/config", cfg.DiscoveryPrefix, cfg.DeviceID)
+	config := SensorConfig{
+		Name:                "Print Lifecycle",
+		UniqueID:            fmt.Sprintf("%s_print_lifecycle", cfg.DeviceID),
+		StateTopic:          fmt.Sprintf("%s/lifecycle", cfg.BaseTopic),
+		Availability:        avail,
+		DeviceClass:         "enum",
+		Options:             mapper.LifecycleStates,
+		Icon:                "mdi:printer-3d",
+		JSONAttributesTopic: fmt.Sprintf("%s/lifecycle/attributes", cfg.BaseTopic),
+		Device:              device,
 	}
 
-	for _, fs := range fanSensors {
-		configTopic := fmt.Sprintf("%s/sensor/%s/%s/config", cfg.DiscoveryPrefix, cfg.DeviceID, fs.uniqueID)
-		config := SensorConfig{
-			Name:              fs.name,
-			UniqueID:          fmt.Sprintf("%s_%s", cfg.DeviceID, fs.uniqueID),
-			StateTopic:        fs.stateTopic,
-			AvailabilityTopic: availTopic,
-			PayloadAvailable:  "online",
-			PayloadNotAvail:   "offline",
-			UnitOfMeasurement: "%",
-			Icon:              fs.icon,
-			StateClass:        "measurement",
-			Device:            device,
-		}
+	payload, _ := json.Marshal(config)
+	return []types.MqttMessage{{
+		Topic:   configTopic,
+		Payload: string(payload),
+		Retain:  true,
+	}}
+}
 
-		payload, _ := json.Marshal(config)
-		messages = append(messages, types.MqttMessage{
-			Topic:   configTopic,
-			Payload: string(payload),
-			Retain:  true,
-		})
+// BuildQueueSensor creates the diagnostic sensor counting the commands waiting
+// for the printer WebSocket to reconnect
+func BuildQueueSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
+	messages := []types.MqttMessage{}
+
+	configTopic := fmt.Sprintf("%s/sensor/%s/queued_commands/config", cfg.DiscoveryPrefix, cfg.DeviceID)
+	config := SensorConfig{
+		Name:           "Queued Commands",
+		UniqueID:       fmt.Sprintf("%s_queued_commands", cfg.DeviceID),
+		StateTopic:     fmt.Sprintf("%s/command/queued", cfg.BaseTopic),
+		Availability:   avail,
+		StateClass:     "measurement",
+		Icon:           "mdi:tray-full",
+		EntityCategory: "diagnostic",
+		Device:         device,
 	}
 
+	payload, _ := json.Marshal(config)
+	messages = append(messages, types.MqttMessage{
+		Topic:   configTopic,
+		Payload: string(payload),
+		Retain:  true,
+	})
+
 	return messages
 }
 
 // BuildProgressSensor creates the print progress sensor discovery message
-func BuildProgressSensor(cfg Config, device *Device, availTopic string) []types.MqttMessage {
+func BuildProgressSensor(cfg Config, device *Device, avail Availability) []types.MqttMessage {
 	progressTopic := fmt.Sprintf("%s/sensor/%s/print_progress/config", cfg.DiscoveryPrefix, cfg.DeviceID)
 	progressConfig := SensorConfig{
 		Name:              "Print Progress",
 		UniqueID:          fmt.Sprintf("%s_print_progress", cfg.DeviceID),
 		StateTopic:        fmt.Sprintf("%s/job/progress", cfg.BaseTopic),
-		AvailabilityTopic: availTopic,
-		PayloadAvailable:  "online",
-		PayloadNotAvail:   "offline",
+		Availability:      avail,
 		UnitOfMeasurement: "%",
 		Icon:              "mdi:percent",
 		StateClass:        "measurement",
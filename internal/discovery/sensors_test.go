@@ -11,7 +11,7 @@ import (
 func TestBuildTemperatureSensors(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildTemperatureSensors(cfg, device, "bt/availability")
+	msgs := BuildTemperatureSensors(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 4, len(msgs))
 	// Table output
 	t.Logf("Test Name | Description | Input | Expected Output | Actual Output | Pass/Fail")
@@ -28,26 +28,47 @@ func TestBuildTemperatureSensors(t *testing.T) {
 func TestBuildStatusSensor(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildStatusSensor(cfg, device, "bt/availability")
+	msgs := BuildStatusSensor(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 1, len(msgs))
 	var sc SensorConfig
 	_ = json.Unmarshal([]byte(msgs[0].Payload), &sc)
 	assert.Equal(t, "bt/printer_status", sc.StateTopic)
+	assert.Equal(t, "bt/state", sc.JSONAttributesTopic)
 }
 
-func TestBuildFanSensors(t *testing.T) {
+func TestBuildLifecycleSensor(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildFanSensors(cfg, device, "bt/availability")
-	assert.Equal(t, 3, len(msgs))
+	msgs := BuildLifecycleSensor(cfg, device, NewAvailability("bt/availability"))
+	require.Equal(t, 1, len(msgs))
+	assert.Equal(t, "ha/sensor/dev/print_lifecycle/config", msgs[0].Topic)
+	assert.True(t, msgs[0].Retain)
+	var sc SensorConfig
+	require.NoError(t, json.Unmarshal([]byte(msgs[0].Payload), &sc))
+	assert.Equal(t, "bt/lifecycle", sc.StateTopic)
+	assert.Equal(t, "bt/lifecycle/attributes", sc.JSONAttributesTopic)
+	assert.Equal(t, "enum", sc.DeviceClass)
+	assert.Equal(t, []string{"idle", "heating", "printing", "paused", "finishing", "completed", "cancelled", "error"}, sc.Options)
 }
 
 func TestBuildProgressSensor(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildProgressSensor(cfg, device, "bt/availability")
+	msgs := BuildProgressSensor(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 1, len(msgs))
 	var sc SensorConfig
 	_ = json.Unmarshal([]byte(msgs[0].Payload), &sc)
 	assert.Equal(t, "bt/job/progress", sc.StateTopic)
 }
+
+func TestBuildQueueSensor(t *testing.T) {
+	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
+	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
+	msgs := BuildQueueSensor(cfg, device, NewAvailability("bt/availability"))
+	require.Equal(t, 1, len(msgs))
+	assert.Equal(t, "ha/sensor/dev/queued_commands/config", msgs[0].Topic)
+	var sc SensorConfig
+	require.NoError(t, json.Unmarshal([]byte(msgs[0].Payload), &sc))
+	assert.Equal(t, "bt/command/queued", sc.StateTopic)
+	assert.Equal(t, "diagnostic", sc.EntityCategory)
+}
@@ -11,7 +11,7 @@ import (
 func TestBuildLightSwitch(t *testing.T) {
 	cfg := Config{DiscoveryPrefix: "ha", BaseTopic: "bt", DeviceID: "dev"}
 	device := &Device{Identifiers: []string{"dev"}, Name: "Dev"}
-	msgs := BuildLightSwitch(cfg, device, "bt/availability")
+	msgs := BuildLightSwitch(cfg, device, NewAvailability("bt/availability"))
 	assert.Equal(t, 1, len(msgs))
 	require.Equal(t, true, msgs[0].Retain)
 	var sc SwitchConfig
@@ -10,44 +10,75 @@ type Device struct {
 
 // SensorConfig represents Home Assistant MQTT sensor discovery config
 type SensorConfig struct {
-	Name              string  `json:"name"`
-	UniqueID          string  `json:"unique_id"`
-	StateTopic        string  `json:"state_topic"`
-	AvailabilityTopic string  `json:"availability_topic,omitempty"`
-	PayloadAvailable  string  `json:"payload_available,omitempty"`
-	PayloadNotAvail   string  `json:"payload_not_available,omitempty"`
-	UnitOfMeasurement string  `json:"unit_of_measurement,omitempty"`
-	DeviceClass       string  `json:"device_class,omitempty"`
-	StateClass        string  `json:"state_class,omitempty"`
-	Icon              string  `json:"icon,omitempty"`
-	Device            *Device `json:"device"`
+	Name       string `json:"name"`
+	UniqueID   string `json:"unique_id"`
+	StateTopic string `json:"state_topic"`
+	Availability
+	UnitOfMeasurement   string   `json:"unit_of_measurement,omitempty"`
+	DeviceClass         string   `json:"device_class,omitempty"`
+	StateClass          string   `json:"state_class,omitempty"`
+	Icon                string   `json:"icon,omitempty"`
+	EntityCategory      string   `json:"entity_category,omitempty"`
+	JSONAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	Options             []string `json:"options,omitempty"`
+	Device              *Device  `json:"device"`
 }
 
 // BinarySensorConfig represents Home Assistant MQTT binary sensor discovery config
 type BinarySensorConfig struct {
-	Name              string  `json:"name"`
-	UniqueID          string  `json:"unique_id"`
-	StateTopic        string  `json:"state_topic"`
-	AvailabilityTopic string  `json:"availability_topic,omitempty"`
-	PayloadAvailable  string  `json:"payload_available,omitempty"`
-	PayloadNotAvail   string  `json:"payload_not_available,omitempty"`
-	PayloadOn         string  `json:"payload_on"`
-	PayloadOff        string  `json:"payload_off"`
-	DeviceClass       string  `json:"device_class,omitempty"`
-	Icon              string  `json:"icon,omitempty"`
-	Device            *Device `json:"device"`
+	Name       string `json:"name"`
+	UniqueID   string `json:"unique_id"`
+	StateTopic string `json:"state_topic"`
+	Availability
+	PayloadOn   string  `json:"payload_on"`
+	PayloadOff  string  `json:"payload_off"`
+	DeviceClass string  `json:"device_class,omitempty"`
+	Icon        string  `json:"icon,omitempty"`
+	Device      *Device `json:"device"`
 }
 
 // CameraConfig represents Home Assistant MQTT camera discovery config
 type CameraConfig struct {
-	Name              string  `json:"name"`
-	UniqueID          string  `json:"unique_id"`
-	Topic             string  `json:"topic"`
-	ImageTopic        string  `json:"image_topic,omitempty"`
-	AvailabilityTopic string  `json:"availability_topic,omitempty"`
-	PayloadAvailable  string  `json:"payload_available,omitempty"`
-	PayloadNotAvail   string  `json:"payload_not_available,omitempty"`
-	Device            *Device `json:"device"`
+	Name       string `json:"name"`
+	UniqueID   string `json:"unique_id"`
+	Topic      string `json:"topic"`
+	ImageTopic string `json:"image_topic,omitempty"`
+	Availability
+	Device *Device `json:"device"`
+}
+
+// Availability lists the topics an entity's availability follows. With the
+// "all" mode the entity is only available while every topic says "online", so
+// it goes unavailable when the bridge dies (its Last Will) or loses the printer.
+type Availability struct {
+	Availability     []AvailabilityTopic `json:"availability"`
+	AvailabilityMode string              `json:"availability_mode"`
+}
+
+// AvailabilityTopic is an "online"/"offline" availability topic
+type AvailabilityTopic struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+}
+
+// NewAvailability returns the availability following every given topic,
+// skipping empty and repeated ones
+func NewAvailability(topics ...string) Availability {
+	avail := Availability{AvailabilityMode: "all"}
+	seen := map[string]bool{}
+	for _, topic := range topics {
+		if topic == "" || seen[topic] {
+			continue
+		}
+		seen[topic] = true
+		avail.Availability = append(avail.Availability, AvailabilityTopic{
+			Topic:               topic,
+			PayloadAvailable:    "online",
+			PayloadNotAvailable: "offline",
+		})
+	}
+	return avail
 }
 
 // Config holds discovery configuration
@@ -57,5 +88,8 @@ type Config struct {
 	DeviceID        string
 	DeviceName      string
 	DeviceModel     string
-	PrinterIP       string // IP address for camera stream
+	PrinterIP       string  // IP address for camera stream
+	MaxNozzleTemp   float64 // printer-reported maximum, 0 uses DefaultMaxNozzleTemp
+	MaxBedTemp      float64 // printer-reported maximum, 0 uses DefaultMaxBedTemp
+	WillTopic       string  // the bridge's Last Will topic, "" when there is none
 }
@@ -0,0 +1,162 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Check is the result of one readiness check
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReadinessFunc returns the current readiness checks. The server is ready when all of them pass.
+type ReadinessFunc func() []Check
+
+// VersionInfo is served on /version
+type VersionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+// Server serves the health, readiness and version endpoints.
+// More handlers can be registered with Handle before Run.
+type Server struct {
+	addr      string
+	mux       *http.ServeMux
+	readiness ReadinessFunc
+	version   VersionInfo
+}
+
+// New creates a Server listening on addr
+func New(addr string, version VersionInfo, readiness ReadinessFunc) *Server {
+	s := &Server{
+		addr:      addr,
+		mux:       http.NewServeMux(),
+		readiness: readiness,
+		version:   version,
+	}
+	s.mux.HandleFunc("GET /healthz", s.handleHealth)
+	s.mux.HandleFunc("GET /readyz", s.handleReady)
+	s.mux.HandleFunc("GET /version", s.handleVersion)
+	return s
+}
+
+// Handle registers an additional handler
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the server's HTTP handler
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Run serves HTTP until the context is cancelled, then shuts down gracefully
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve serves HTTP on the listener until the context is cancelled
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		// Cancel long-lived requests (event streams) on shutdown
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Info("HTTP server listening", "addr", ln.Addr().String())
+		errCh <- srv.Serve(ln)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+		if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	var checks []Check
+	if s.readiness != nil {
+		checks = s.readiness()
+	}
+
+	status, code := "ok", http.StatusOK
+	for _, c := range checks {
+		if !c.OK {
+			status, code = "unavailable", http.StatusServiceUnavailable
+			break
+		}
+	}
+	if checks == nil {
+		checks = []Check{}
+	}
+	WriteJSON(w, code, struct {
+		Status string  `json:"status"`
+		Checks []Check `json:"checks"`
+	}{status, checks})
+}
+
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.version)
+}
+
+// RequireToken wraps next so requests need an "Authorization: Bearer <token>"
+// header. With an empty token every request is let through.
+func RequireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="creality2mqtt"`)
+			WriteJSON(w, http.StatusUnauthorized, struct {
+				Error string `json:"error"`
+			}{"missing or invalid bearer token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// WriteJSON writes v as a JSON response with the given status code
+func WriteJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("Failed to write HTTP response", "error", err)
+	}
+}
@@ -0,0 +1,97 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Endpoints(t *testing.T) {
+	ready := []Check{{Name: "mqtt", OK: true}}
+	srv := New(":0", VersionInfo{Version: "1.2.3", Commit: "abc", Date: "today"}, func() []Check {
+		return ready
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		checks   []Check
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "healthz",
+			path:     "/healthz",
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok"}`,
+		},
+		{
+			name:     "version",
+			path:     "/version",
+			wantCode: http.StatusOK,
+			wantBody: `{"version":"1.2.3","commit":"abc","date":"today"}`,
+		},
+		{
+			name:     "ready",
+			path:     "/readyz",
+			checks:   []Check{{Name: "mqtt", OK: true}},
+			wantCode: http.StatusOK,
+			wantBody: `{"status":"ok","checks":[{"name":"mqtt","ok":true}]}`,
+		},
+		{
+			name:     "not ready",
+			path:     "/readyz",
+			checks:   []Check{{Name: "mqtt", OK: true}, {Name: "printer/k1/websocket", Message: "not connected"}},
+			wantCode: http.StatusServiceUnavailable,
+			wantBody: `{"status":"unavailable","checks":[{"name":"mqtt","ok":true},{"name":"printer/k1/websocket","ok":false,"message":"not connected"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ready = tt.checks
+			rec := httptest.NewRecorder()
+			srv.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestServer_ServeShutsDownOnCancel(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := New(ln.Addr().String(), VersionInfo{}, nil)
+	srv.Handle("GET /extra", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, "extra")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ctx, ln) }()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/extra")
+	require.NoError(t, err)
+	var body string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, "extra", body)
+
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
@@ -0,0 +1,183 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/davidcollom/creality2mqtt/internal/state"
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// StateDocument is the normalised printer state published as JSON on <base>/state.
+// Fields the printer has not reported yet are left out.
+type StateDocument struct {
+	Temperatures StateTemperatures `json:"temperatures"`
+	Job          StateJob          `json:"job"`
+	Fans         StateFans         `json:"fans"`
+	Position     *StatePosition    `json:"position,omitempty"`
+	CFS          []StateBox        `json:"cfs,omitempty"`
+}
+
+// StateTemperatures are the heater and chamber temperatures in °C
+type StateTemperatures struct {
+	Nozzle HeaterState `json:"nozzle"`
+	Bed    HeaterState `json:"bed"`
+	Box    *float64    `json:"box,omitempty"`
+}
+
+// HeaterState is the current, target and maximum temperature of a heater
+type HeaterState struct {
+	Current *float64 `json:"current,omitempty"`
+	Target  *float64 `json:"target,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+// StateJob is the current print job
+type StateJob struct {
+	State      string `json:"state"`
+	FileName   string `json:"file_name,omitempty"`
+	Progress   *int64 `json:"progress,omitempty"`
+	LeftTime   *int64 `json:"left_time,omitempty"`
+	JobTime    *int64 `json:"job_time,omitempty"`
+	Layer      *int64 `json:"layer,omitempty"`
+	TotalLayer *int64 `json:"total_layer,omitempty"`
+	FeedState  *int64 `json:"feed_state,omitempty"`
+}
+
+// StateFans are the fan speeds in percent
+type StateFans struct {
+	Model     *float64 `json:"model,omitempty"`
+	Auxiliary *float64 `json:"auxiliary,omitempty"`
+	Case      *float64 `json:"case,omitempty"`
+}
+
+// StatePosition is the toolhead position in mm
+type StatePosition struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+// StateBox is a CFS box
+type StateBox struct {
+	ID          int      `json:"id"`
+	State       *int64   `json:"state,omitempty"`
+	Humidity    *float64 `json:"humidity,omitempty"`
+	Temperature *float64 `json:"temperature,omitempty"`
+}
+
+// NewStateDocument normalises the merged printer state
+func NewStateDocument(st *state.PrinterState) StateDocument {
+	msg := st.Fields()
+	doc := StateDocument{
+		Temperatures: StateTemperatures{
+			Nozzle: HeaterState{
+				Current: floatField(msg, "nozzleTemp"),
+				Target:  floatField(msg, "targetNozzleTemp"),
+				Max:     floatField(msg, "maxNozzleTemp"),
+			},
+			Bed: HeaterState{
+				Current: floatField(msg, "bedTemp0"),
+				Target:  floatField(msg, "targetBedTemp0"),
+				Max:     floatField(msg, "maxBedTemp"),
+			},
+			Box: floatField(msg, "boxTemp"),
+		},
+		Job: StateJob{
+			State:      JobState(msg),
+			Progress:   intField(msg, "printProgress"),
+			LeftTime:   intField(msg, "printLeftTime"),
+			JobTime:    intField(msg, "printJobTime"),
+			Layer:      intField(msg, "layer"),
+			TotalLayer: intField(msg, "TotalLayer"),
+			FeedState:  intField(msg, "feedState"),
+		},
+		Fans: StateFans{
+			Model:     floatField(msg, "modelFanPct"),
+			Auxiliary: floatField(msg, "auxiliaryFanPct"),
+			Case:      floatField(msg, "caseFanPct"),
+		},
+	}
+	if name, ok := msg["printFileName"].(string); ok {
+		doc.Job.FileName = simplifyFileName(name)
+	}
+	if pos, ok := msg["curPosition"].(string); ok {
+		doc.Position = parsePosition(pos)
+	}
+
+	boxes := st.Boxes()
+	for _, id := range slices.Sorted(maps.Keys(boxes)) {
+		box := boxes[id]
+		doc.CFS = append(doc.CFS, StateBox{
+			ID:          id,
+			State:       intField(box, "state"),
+			Humidity:    floatField(box, "humidity"),
+			Temperature: floatField(box, "temp"),
+		})
+	}
+	return doc
+}
+
+// BuildStateDocumentMessage emits the normalised state as JSON on <base>/state
+func BuildStateDocumentMessage(st *state.PrinterState, baseTopic string) []types.MqttMessage {
+	payload, err := json.Marshal(NewStateDocument(st))
+	if err != nil {
+		return nil
+	}
+	return []types.MqttMessage{{
+		Topic:   fmt.Sprintf("%s/state", baseTopic),
+		Payload: string(payload),
+		Retain:  false,
+	}}
+}
+
+// parsePosition parses the printer's "X:142.82 Y:64.43 Z:5.33" position
+func parsePosition(s string) *StatePosition {
+	var pos StatePosition
+	seen := 0
+	for _, field := range strings.Fields(s) {
+		axis, value, ok := strings.Cut(field, ":")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch axis {
+		case "X":
+			pos.X = v
+		case "Y":
+			pos.Y = v
+		case "Z":
+			pos.Z = v
+		default:
+			continue
+		}
+		seen++
+	}
+	if seen == 0 {
+		return nil
+	}
+	return &pos
+}
+
+func floatField(msg map[string]any, key string) *float64 {
+	v, ok := getFloat(msg, key)
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+func intField(msg map[string]any, key string) *int64 {
+	v, ok := getInt(msg, key)
+	if !ok {
+		return nil
+	}
+	return &v
+}
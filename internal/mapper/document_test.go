@@ -0,0 +1,87 @@
+package mapper
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/davidcollom/creality2mqtt/internal/state"
+)
+
+func TestBuildStateDocumentMessage(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		frames []string
+		want   string
+	}{
+		{
+			name:   "nothing reported",
+			frames: []string{`{}`},
+			want:   `{"temperatures":{"nozzle":{},"bed":{}},"job":{"state":"idle"},"fans":{}}`,
+		},
+		{
+			name: "full and delta frames",
+			frames: []string{
+				`{"nozzleTemp":"219.900000","targetNozzleTemp":220,"maxNozzleTemp":300,"bedTemp0":"59.340000","targetBedTemp0":60,"maxBedTemp":100,
+				  "boxTemp":0,"state":1,"printProgress":58,"printLeftTime":767,"printJobTime":1272,"layer":62,"TotalLayer":150,
+				  "printFileName":"/usr/data/printer_data/gcodes/Meta.gcode","feedState":101,
+				  "modelFanPct":100,"auxiliaryFanPct":0,"caseFanPct":50,"curPosition":"X:142.82 Y:64.43 Z:5.33",
+				  "boxState":{"id":1,"state":1,"humidity":28.0,"temp":23.0}}`,
+				`{"nozzleTemp":"220.100000","printProgress":59,"boxState":{"id":2,"humidity":41}}`,
+			},
+			want: `{"temperatures":{"nozzle":{"current":220.1,"target":220,"max":300},"bed":{"current":59.34,"target":60,"max":100},"box":0},` +
+				`"job":{"state":"printing","file_name":"Meta.gcode","progress":59,"left_time":767,"job_time":1272,"layer":62,"total_layer":150,"feed_state":101},` +
+				`"fans":{"model":100,"auxiliary":0,"case":50},"position":{"x":142.82,"y":64.43,"z":5.33},` +
+				`"cfs":[{"id":1,"state":1,"humidity":28,"temperature":23},{"id":2,"humidity":41}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := state.New()
+			for _, frame := range tt.frames {
+				var msg map[string]any
+				if err := json.Unmarshal([]byte(frame), &msg); err != nil {
+					t.Fatalf("failed to unmarshal test json: %v", err)
+				}
+				st.Merge(msg)
+			}
+
+			got := BuildStateDocumentMessage(st, "3dprinter/k1se")
+			if len(got) != 1 {
+				t.Fatalf("expected 1 message, got %d", len(got))
+			}
+			if got[0].Topic != "3dprinter/k1se/state" {
+				t.Errorf("topic = %q, want %q", got[0].Topic, "3dprinter/k1se/state")
+			}
+			if got[0].Payload != tt.want {
+				t.Errorf("payload =\n%s\nwant\n%s", got[0].Payload, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		input string
+		want  *StatePosition
+	}{
+		{"X:142.82 Y:64.43 Z:5.33", &StatePosition{X: 142.82, Y: 64.43, Z: 5.33}},
+		{"X:1 Z:2", &StatePosition{X: 1, Z: 2}},
+		{"", nil},
+		{"homing", nil},
+		{"X:abc", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := parsePosition(tt.input); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePosition(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
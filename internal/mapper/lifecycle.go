@@ -0,0 +1,166 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+)
+
+// Print lifecycle states published on <base>/lifecycle
+const (
+	LifecycleIdle      = "idle"
+	LifecycleHeating   = "heating"
+	LifecyclePrinting  = "printing"
+	LifecyclePaused    = "paused"
+	LifecycleFinishing = "finishing"
+	LifecycleCompleted = "completed"
+	LifecycleCancelled = "cancelled"
+	LifecycleError     = "error"
+)
+
+// LifecycleStates lists every lifecycle state, e.g. as the options of an enum sensor
+var LifecycleStates = []string{
+	LifecycleIdle,
+	LifecycleHeating,
+	LifecyclePrinting,
+	LifecyclePaused,
+	LifecycleFinishing,
+	LifecycleCompleted,
+	LifecycleCancelled,
+	LifecycleError,
+}
+
+// feedStateExtruding is the feedState reported while the extruder is feeding
+const feedStateExtruding = 101
+
+// heatingTolerance is how far below its target a heater may be, in °C, for a
+// starting job to count as printing rather than heating
+const heatingTolerance = 3.0
+
+// Lifecycle is the print lifecycle state and the transition into it
+type Lifecycle struct {
+	State    string
+	Previous string
+	Since    time.Time
+}
+
+// ObserveLifecycle advances the print lifecycle with the merged printer state
+// and returns it. A transition is timestamped with the Mapper's clock; the
+// first observation counts as one, from no state.
+func (m *Mapper) ObserveLifecycle(msg map[string]any) Lifecycle {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := nextLifecycle(m.lifecycle.State, msg)
+	if next != m.lifecycle.State {
+		m.lifecycle = Lifecycle{State: next, Previous: m.lifecycle.State, Since: m.now()}
+	}
+	return m.lifecycle
+}
+
+// BuildLifecycleMessages emits the lifecycle topics:
+//
+//	<base>/lifecycle             -> lifecycle state ("idle", "heating", "printing", ...)
+//	<base>/lifecycle/attributes  -> JSON with the previous state and when the current one began
+func BuildLifecycleMessages(lc Lifecycle, baseTopic string) []types.MqttMessage {
+	out := []types.MqttMessage{{
+		Topic:   fmt.Sprintf("%s/lifecycle", baseTopic),
+		Payload: lc.State,
+		Retain:  false,
+	}}
+
+	attrs, err := json.Marshal(struct {
+		Previous string    `json:"previous,omitempty"`
+		Since    time.Time `json:"since"`
+	}{lc.Previous, lc.Since})
+	if err != nil {
+		return out
+	}
+	return append(out, types.MqttMessage{
+		Topic:   fmt.Sprintf("%s/lifecycle/attributes", baseTopic),
+		Payload: string(attrs),
+		Retain:  false,
+	})
+}
+
+// nextLifecycle returns the lifecycle state following prev. msg should be the
+// merged printer state, a delta frame alone may not carry every field.
+//
+// The printer's "state" code decides how a job ended and whether it is paused.
+// A running job is heating until its heaters reach their targets, printing,
+// and finishing once the progress reaches 100%. A job that is already printing
+// never goes back to heating, so a target raised mid-print is not mistaken for
+// a new warm-up.
+func nextLifecycle(prev string, msg map[string]any) string {
+	switch state, _ := getInt(msg, "state"); state {
+	case PrintStateFailed:
+		return LifecycleError
+	case PrintStateStopped:
+		return LifecycleCancelled
+	case PrintStateCompleted:
+		return LifecycleCompleted
+	case PrintStatePaused:
+		return LifecyclePaused
+	}
+
+	if !jobRunning(msg) {
+		return LifecycleIdle
+	}
+
+	progress, _ := getInt(msg, "printProgress")
+	switch prev {
+	case LifecyclePrinting, LifecyclePaused, LifecycleFinishing:
+		if progress >= 100 {
+			return LifecycleFinishing
+		}
+		return LifecyclePrinting
+	}
+
+	// A job is starting, or was already under way when the bridge started. A
+	// progress of 100% is left over from the last job.
+	if progress > 0 && progress < 100 {
+		return LifecyclePrinting
+	}
+	if heating(msg) {
+		return LifecycleHeating
+	}
+	return LifecyclePrinting
+}
+
+// jobRunning reports whether a job is running or starting. The printer's
+// "state" code is authoritative when it is reported; firmware without it is
+// judged by the G-code, device and feed states and the job's progress.
+func jobRunning(msg map[string]any) bool {
+	if state, ok := getInt(msg, "state"); ok {
+		return state == PrintStatePrinting
+	}
+	if gcode, ok := getInt(msg, "gcodeState"); ok && gcode > 0 {
+		return true
+	}
+	if device, ok := getInt(msg, "deviceState"); ok && device == 1 {
+		return true
+	}
+	if feed, ok := getInt(msg, "feedState"); ok && feed == feedStateExtruding {
+		return true
+	}
+	progress, _ := getInt(msg, "printProgress")
+	left, _ := getInt(msg, "printLeftTime")
+	return progress > 0 && left > 0
+}
+
+// heating reports whether the nozzle or bed is still warming up to its target
+func heating(msg map[string]any) bool {
+	for _, h := range [][2]string{
+		{"nozzleTemp", "targetNozzleTemp"},
+		{"bedTemp0", "targetBedTemp0"},
+	} {
+		current, hasCurrent := getFloat(msg, h[0])
+		target, hasTarget := getFloat(msg, h[1])
+		if hasCurrent && hasTarget && target > 0 && current < target-heatingTolerance {
+			return true
+		}
+	}
+	return false
+}
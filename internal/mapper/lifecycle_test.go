@@ -0,0 +1,156 @@
+package mapper
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/state"
+)
+
+func TestMapper_Lifecycle(t *testing.T) {
+	t.Parallel()
+
+	type frame struct {
+		after time.Duration
+		json  string
+		want  string
+	}
+
+	tests := []struct {
+		name   string
+		frames []frame
+		// transitions are "state@elapsed", in order
+		transitions []string
+	}{
+		{
+			name: "print from warm-up to completion",
+			frames: []frame{
+				{0, `{"state":0,"printProgress":100,"printLeftTime":0,"feedState":102,
+				      "nozzleTemp":"25.100000","targetNozzleTemp":0,"bedTemp0":"24.800000","targetBedTemp0":0}`, LifecycleIdle},
+				{10 * time.Second, `{"state":1,"printFileName":"/usr/data/printer_data/gcodes/Meta.gcode","printProgress":0,
+				                     "targetNozzleTemp":220,"targetBedTemp0":60}`, LifecycleHeating},
+				{time.Minute, `{"bedTemp0":"59.300000","nozzleTemp":"150.000000"}`, LifecycleHeating},
+				{2 * time.Minute, `{"nozzleTemp":"218.500000"}`, LifecyclePrinting},
+				{3 * time.Minute, `{"feedState":101,"printProgress":1,"printLeftTime":1800}`, LifecyclePrinting},
+				{10 * time.Minute, `{"state":5}`, LifecyclePaused},
+				// The nozzle cooled down while paused, resuming is not a new warm-up
+				{12 * time.Minute, `{"state":1,"nozzleTemp":"180.000000"}`, LifecyclePrinting},
+				{20 * time.Minute, `{"nozzleTemp":"220.000000","printProgress":60,"printLeftTime":700}`, LifecyclePrinting},
+				{30 * time.Minute, `{"printProgress":100,"printLeftTime":0}`, LifecycleFinishing},
+				{31 * time.Minute, `{"state":2,"feedState":102,"targetNozzleTemp":0,"targetBedTemp0":0}`, LifecycleCompleted},
+				{40 * time.Minute, `{"state":0}`, LifecycleIdle},
+			},
+			transitions: []string{
+				"idle@0s", "heating@10s", "printing@2m0s", "paused@10m0s", "printing@12m0s",
+				"finishing@30m0s", "completed@31m0s", "idle@40m0s",
+			},
+		},
+		{
+			name: "cancelled and restarted",
+			frames: []frame{
+				{0, `{"state":1,"printProgress":12,"printLeftTime":1500,"feedState":101,
+				      "nozzleTemp":"220.000000","targetNozzleTemp":220}`, LifecyclePrinting},
+				{time.Minute, `{"state":4,"targetNozzleTemp":0}`, LifecycleCancelled},
+				{2 * time.Minute, `{"nozzleTemp":"120.000000"}`, LifecycleCancelled},
+				{3 * time.Minute, `{"state":1,"printProgress":0,"targetNozzleTemp":220}`, LifecycleHeating},
+				{4 * time.Minute, `{"nozzleTemp":"219.000000"}`, LifecyclePrinting},
+			},
+			transitions: []string{"printing@0s", "cancelled@1m0s", "heating@3m0s", "printing@4m0s"},
+		},
+		{
+			name: "failure",
+			frames: []frame{
+				{0, `{"state":1,"printProgress":30,"printLeftTime":900}`, LifecyclePrinting},
+				{time.Minute, `{"state":3}`, LifecycleError},
+				{2 * time.Minute, `{"state":0}`, LifecycleIdle},
+			},
+			transitions: []string{"printing@0s", "error@1m0s", "idle@2m0s"},
+		},
+		{
+			name: "progress left over from the last job",
+			frames: []frame{
+				{0, `{"state":2,"printProgress":100,"printLeftTime":0,
+				      "nozzleTemp":"30.000000","targetNozzleTemp":0,"bedTemp0":"28.000000","targetBedTemp0":0}`, LifecycleCompleted},
+				{10 * time.Second, `{"state":1,"targetNozzleTemp":220,"targetBedTemp0":60}`, LifecycleHeating},
+				{20 * time.Second, `{"printProgress":0,"nozzleTemp":"220.000000","bedTemp0":"60.000000"}`, LifecyclePrinting},
+			},
+			transitions: []string{"completed@0s", "heating@10s", "printing@20s"},
+		},
+		{
+			name: "bridge started mid-print",
+			frames: []frame{
+				{0, `{"state":1,"printProgress":40,"printLeftTime":600,"nozzleTemp":"150.000000","targetNozzleTemp":220}`, LifecyclePrinting},
+				{time.Second, `{"nozzleTemp":"160.000000"}`, LifecyclePrinting},
+			},
+			transitions: []string{"printing@0s"},
+		},
+		{
+			name: "firmware without a state code",
+			frames: []frame{
+				{0, `{"deviceState":0}`, LifecycleIdle},
+				{10 * time.Second, `{"gcodeState":1,"printProgress":0,"nozzleTemp":"100.000000","targetNozzleTemp":220}`, LifecycleHeating},
+				{20 * time.Second, `{"nozzleTemp":"220.000000","printProgress":5,"printLeftTime":900}`, LifecyclePrinting},
+				{30 * time.Second, `{"gcodeState":0,"printProgress":100,"printLeftTime":0}`, LifecycleIdle},
+			},
+			transitions: []string{"idle@0s", "heating@10s", "printing@20s", "idle@30s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+			now := start
+			m := New(DefaultStatusInterval, func() time.Time { return now })
+			st := state.New()
+
+			var transitions []string
+			var last Lifecycle
+			for i, f := range tt.frames {
+				var msg map[string]any
+				if err := json.Unmarshal([]byte(f.json), &msg); err != nil {
+					t.Fatalf("frame %d: failed to unmarshal test json: %v", i, err)
+				}
+				now = start.Add(f.after)
+				st.Merge(msg)
+
+				lc := m.ObserveLifecycle(st.Fields())
+				if lc.State != f.want {
+					t.Errorf("frame %d at %s: lifecycle = %q, want %q", i, f.after, lc.State, f.want)
+				}
+				if lc != last {
+					if lc.Previous != last.State {
+						t.Errorf("frame %d: previous = %q, want %q", i, lc.Previous, last.State)
+					}
+					transitions = append(transitions, fmt.Sprintf("%s@%s", lc.State, lc.Since.Sub(start)))
+					last = lc
+				}
+			}
+
+			if fmt.Sprint(transitions) != fmt.Sprint(tt.transitions) {
+				t.Errorf("transitions = %v, want %v", transitions, tt.transitions)
+			}
+		})
+	}
+}
+
+func TestBuildLifecycleMessages(t *testing.T) {
+	t.Parallel()
+
+	lc := Lifecycle{
+		State:    LifecyclePaused,
+		Previous: LifecyclePrinting,
+		Since:    time.Date(2026, 1, 5, 10, 12, 0, 0, time.UTC),
+	}
+	got := toTopicMap(BuildLifecycleMessages(lc, "3dprinter/k1se"))
+	want := map[string]string{
+		"3dprinter/k1se/lifecycle":            "paused",
+		"3dprinter/k1se/lifecycle/attributes": `{"previous":"printing","since":"2026-01-05T10:12:00Z"}`,
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("BuildLifecycleMessages() = %v, want %v", got, want)
+	}
+}
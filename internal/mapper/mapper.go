@@ -3,10 +3,15 @@ package mapper
 import (
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
+	"github.com/davidcollom/creality2mqtt/internal/state"
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
@@ -49,7 +54,51 @@ func DecodeAndMap(data []byte, baseTopic string) ([]types.MqttMessage, error) {
 	return MapMessageToMqtt(msg, baseTopic), nil
 }
 
+// DefaultStatusInterval is how often an unchanged printer status is published
+// again, and how long an active printer stays active without print activity
+const DefaultStatusInterval = 10 * time.Second
+
+// Mapper maps the frames of one printer to MQTT messages. It keeps what the
+// derived topics need between frames, such as the printer status rate limit
+// and the print lifecycle, so every printer owns one. It is safe for concurrent use.
+type Mapper struct {
+	statusInterval time.Duration
+	now            func() time.Time
+
+	mu        sync.Mutex
+	status    statusCache
+	lifecycle Lifecycle
+}
+
+// statusCache is the last printer status published
+type statusCache struct {
+	lastStatus    string
+	lastPublished time.Time
+	lastUpdate    time.Time
+}
+
+// New returns a Mapper publishing an unchanged printer status every
+// statusInterval, using now as its clock
+func New(statusInterval time.Duration, now func() time.Time) *Mapper {
+	return &Mapper{statusInterval: statusInterval, now: now}
+}
+
+// MapMessageToMqtt maps a single frame on its own, as if it were the first one
 func MapMessageToMqtt(msg map[string]any, baseTopic string) []types.MqttMessage {
+	st := state.New()
+	st.Merge(msg)
+	return MapFrame(msg, st, baseTopic)
+}
+
+// MapFrame maps a frame with a fresh Mapper, see Mapper.MapFrame
+func MapFrame(msg map[string]any, st *state.PrinterState, baseTopic string) []types.MqttMessage {
+	return New(DefaultStatusInterval, time.Now).MapFrame(msg, st, baseTopic)
+}
+
+// MapFrame maps a frame already merged into st: the generic topics of the
+// fields the frame carries, and the derived topics computed from the merged
+// state so a delta frame does not lose the fields it left out.
+func (m *Mapper) MapFrame(msg map[string]any, st *state.PrinterState, baseTopic string) []types.MqttMessage {
 	result := make([]types.MqttMessage, 0, len(msg)+16)
 
 	// --- generic scalar → topic mapping ---
@@ -59,6 +108,9 @@ func MapMessageToMqtt(msg map[string]any, baseTopic string) []types.MqttMessage
 		}
 
 		norm := normaliseKey(key)
+		if topic, ok := renamedKeys[key]; ok {
+			norm = topic
+		}
 
 		switch raw.(type) {
 		case map[string]any, []any:
@@ -77,10 +129,15 @@ func MapMessageToMqtt(msg map[string]any, baseTopic string) []types.MqttMessage
 	}
 
 	// --- domain-specific derived topics ---
-	result = append(result, BuildTempMessages(msg, baseTopic)...)
-	result = append(result, BuildJobMessages(msg, baseTopic)...)
-	result = append(result, BuildStateMessages(msg, baseTopic)...)
-	result = append(result, BuildCFSBoxMessages(msg, baseTopic)...)
+	merged := st.Fields()
+	result = append(result, BuildTempMessages(merged, baseTopic)...)
+	result = append(result, BuildJobMessages(merged, baseTopic)...)
+	result = append(result, m.BuildStateMessages(merged, baseTopic)...)
+	boxes := st.Boxes()
+	for _, id := range slices.Sorted(maps.Keys(boxes)) {
+		result = append(result, BuildCFSBoxMessages(map[string]any{"boxState": boxes[id]}, baseTopic)...)
+	}
+	result = append(result, BuildStateDocumentMessage(st, baseTopic)...)
 
 	return result
 }
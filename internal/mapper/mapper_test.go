@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/davidcollom/creality2mqtt/internal/state"
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
@@ -88,7 +89,7 @@ func TestMapMessageToMqtt_InitialSnapshot(t *testing.T) {
 		baseTopic + "/hostname":           "K1 SE-7E0E",
 		baseTopic + "/model":              "K1 SE",
 		baseTopic + "/device_state":       "1",
-		baseTopic + "/state":              "1",
+		baseTopic + "/print_state":        "1",
 		baseTopic + "/cur_position":       "X:142.82 Y:64.43 Z:5.33",
 		baseTopic + "/target_nozzle_temp": "220",
 		baseTopic + "/target_bed_temp0":   "60",
@@ -155,3 +156,65 @@ func TestMapMessageToMqtt_DeltaMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestMapFrame_MergedState(t *testing.T) {
+	t.Parallel()
+
+	baseTopic := "3dprinter/k1se"
+	st := state.New()
+	mapFrame := func(jsonStr string) map[string]string {
+		var msg map[string]any
+		if err := json.Unmarshal([]byte(jsonStr), &msg); err != nil {
+			t.Fatalf("failed to unmarshal test json: %v", err)
+		}
+		st.Merge(msg)
+		return toTopicMap(MapFrame(msg, st, baseTopic))
+	}
+
+	mapFrame(`{
+        "nozzleTemp": "219.900000",
+        "targetNozzleTemp": 220,
+        "printProgress": 58,
+        "printLeftTime": 767,
+        "state": 1,
+        "boxState": {"id": 1, "state": 1, "humidity": 28.0, "temp": 23.0}
+    }`)
+	tp := mapFrame(`{
+        "nozzleTemp": "219.790000",
+        "printProgress": 59,
+        "boxState": {"id": 2, "humidity": 41.0}
+    }`)
+
+	expectations := map[string]string{
+		// Generic topics of the delta's fields
+		baseTopic + "/nozzle_temp":    "219.790",
+		baseTopic + "/print_progress": "59",
+		// Derived topics from the merged state
+		baseTopic + "/temperature/nozzle/current": "219.790",
+		baseTopic + "/temperature/nozzle/target":  "220.000",
+		baseTopic + "/printing":                   "true",
+		baseTopic + "/job/progress":               "59",
+		baseTopic + "/job/left_time":              "767",
+		baseTopic + "/cfs/1/humidity":             "28",
+		baseTopic + "/cfs/2/humidity":             "41",
+	}
+	for topic, want := range expectations {
+		gotVal, ok := tp[topic]
+		if !ok {
+			t.Errorf("expected topic %s to be present", topic)
+			continue
+		}
+		if gotVal != want {
+			t.Errorf("topic %s payload = %q, want %q", topic, gotVal, want)
+		}
+	}
+	// Fields the delta left out have no generic topic
+	if _, ok := tp[baseTopic+"/target_nozzle_temp"]; ok {
+		t.Errorf("unexpected generic topic for a field missing from the frame")
+	}
+
+	tp = mapFrame(`{"state": 2, "printProgress": 100, "printLeftTime": 0}`)
+	if got := tp[baseTopic+"/printing"]; got != "false" {
+		t.Errorf("printing = %q after the job, want %q", got, "false")
+	}
+}
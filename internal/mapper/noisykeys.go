@@ -8,3 +8,10 @@ var noisyKeys = map[string]struct{}{
 	"video":  {},
 	"video1": {},
 }
+
+// renamedKeys are published under another topic than their normalised key,
+// which the bridge uses for something else
+var renamedKeys = map[string]string{
+	// <base>/state is the JSON state document
+	"state": "print_state",
+}
@@ -2,35 +2,47 @@ package mapper
 
 import (
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
-var printerStatusCache struct {
-	mu            sync.RWMutex
-	lastStatus    string
-	lastPublished time.Time
-	lastUpdate    time.Time
-}
-var statusUpdateInterval = 10 * time.Second
+// Job states returned by JobState
+const (
+	JobIdle     = "idle"
+	JobPrinting = "printing"
+	JobPaused   = "paused"
+)
+
+// Print state codes reported by the printer in the "state" field
+const (
+	PrintStatePrinting  = 1
+	PrintStateCompleted = 2
+	PrintStateFailed    = 3
+	PrintStateStopped   = 4
+	PrintStatePaused    = 5
+)
 
 // BuildStateMessages emits derived MQTT topics around device state:
 //
-//	<base>/printer_status    -> "idle"/"active" (based on print activity)
+//	<base>/printer_status    -> "idle"/"active" (based on the print lifecycle)
+//	<base>/lifecycle         -> print lifecycle state, see BuildLifecycleMessages
 //	<base>/tf_card_present   -> "true"/"false" (based on tfCard)
-func BuildStateMessages(msg map[string]any, baseTopic string) []types.MqttMessage {
+//
+// It advances the print lifecycle, so it is called once per frame.
+func (m *Mapper) BuildStateMessages(msg map[string]any, baseTopic string) []types.MqttMessage {
 	out := make([]types.MqttMessage, 0, 4)
+	lc := m.ObserveLifecycle(msg)
 
 	// Determine printer status with rate limiting
-	if status, shouldPublish := getRateLimitedPrinterStatus(msg); shouldPublish {
+	if status, shouldPublish := m.rateLimitedPrinterStatus(printerStatus(lc.State)); shouldPublish {
 		out = append(out, types.MqttMessage{
 			Topic:   fmt.Sprintf("%s/printer_status", baseTopic),
 			Payload: status,
 			Retain:  false,
 		})
 	}
+	out = append(out, BuildLifecycleMessages(lc, baseTopic)...)
 
 	if tf, ok := getInt(msg, "tfCard"); ok {
 		tfPresent := tf == 1
@@ -47,25 +59,29 @@ func BuildStateMessages(msg map[string]any, baseTopic string) []types.MqttMessag
 	return out
 }
 
-// getRateLimitedPrinterStatus returns status and whether it should be published
-// Only publishes updates every 10 seconds or when status changes significantly
-func getRateLimitedPrinterStatus(msg map[string]any) (string, bool) {
-	printerStatusCache.mu.Lock()
-	defer printerStatusCache.mu.Unlock()
+// BuildStateMessages maps the state topics of a single frame with a fresh Mapper
+func BuildStateMessages(msg map[string]any, baseTopic string) []types.MqttMessage {
+	return New(DefaultStatusInterval, time.Now).BuildStateMessages(msg, baseTopic)
+}
+
+// rateLimitedPrinterStatus returns status and whether it should be published
+// Only publishes updates every status interval or when status changes significantly
+func (m *Mapper) rateLimitedPrinterStatus(currentStatus string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	now := time.Now()
-	currentStatus := derivePrinterStatus(msg)
+	now := m.now()
 
 	// Update last activity time if printer is active
 	if currentStatus == "active" {
-		printerStatusCache.lastUpdate = now
+		m.status.lastUpdate = now
 	}
 
 	// Check if we should mark as idle due to timeout
-	if currentStatus == "idle" && !printerStatusCache.lastUpdate.IsZero() {
-		if now.Sub(printerStatusCache.lastUpdate) < statusUpdateInterval {
+	if currentStatus == "idle" && !m.status.lastUpdate.IsZero() {
+		if now.Sub(m.status.lastUpdate) < m.statusInterval {
 			// Still within timeout period, keep previous status if it was active
-			if printerStatusCache.lastStatus == "active" {
+			if m.status.lastStatus == "active" {
 				currentStatus = "active"
 			}
 		}
@@ -73,49 +89,41 @@ func getRateLimitedPrinterStatus(msg map[string]any) (string, bool) {
 
 	// Only publish if status changed or enough time has passed
 	shouldPublish := false
-	if currentStatus != printerStatusCache.lastStatus {
+	if currentStatus != m.status.lastStatus {
 		shouldPublish = true
-	} else if now.Sub(printerStatusCache.lastPublished) >= statusUpdateInterval {
+	} else if now.Sub(m.status.lastPublished) >= m.statusInterval {
 		shouldPublish = true
 	}
 
 	if shouldPublish {
-		printerStatusCache.lastStatus = currentStatus
-		printerStatusCache.lastPublished = now
+		m.status.lastStatus = currentStatus
+		m.status.lastPublished = now
 	}
 
 	return currentStatus, shouldPublish
 }
 
-// derivePrinterStatus returns "active" when printing, "idle" otherwise
+// printerStatus returns "active" while a job is under way, "idle" otherwise
 // When bridge is offline, HA will show "unavailable" via availability topic
-func derivePrinterStatus(msg map[string]any) string {
-	// Check if actively printing (progress > 0 and time left > 0)
-	if progress, ok := getInt(msg, "printProgress"); ok && progress > 0 {
-		if left, ok := getInt(msg, "leftTime"); ok && left > 0 {
-			return "active"
-		}
-	}
-
-	// If we have a printJobTime greater than 0 we're active
-	if left, ok := getInt(msg, "printJobTime"); ok && left > 0 {
-		return "active"
-	}
-
-	// If we have a print time left greater than 0 we're active
-	if left, ok := getInt(msg, "printLeftTime"); ok && left > 0 {
-		return "active"
-	}
-
-	// If we have a layer greater than 0 we're active
-	if left, ok := getInt(msg, "layer"); ok && left > 0 {
+func printerStatus(lifecycle string) string {
+	switch lifecycle {
+	case LifecycleHeating, LifecyclePrinting, LifecyclePaused, LifecycleFinishing:
 		return "active"
+	default:
+		return "idle"
 	}
+}
 
-	// Check gcode state (printing/paused)
-	if state, ok := getInt(msg, "gcodeState"); ok && state > 0 {
-		return "active"
+// JobState returns whether a job is printing, paused or idle, from the print
+// lifecycle the printer state implies on its own. msg should be the merged
+// printer state, a delta frame alone may not carry every field.
+func JobState(msg map[string]any) string {
+	switch nextLifecycle("", msg) {
+	case LifecyclePaused:
+		return JobPaused
+	case LifecycleHeating, LifecyclePrinting, LifecycleFinishing:
+		return JobPrinting
+	default:
+		return JobIdle
 	}
-
-	return "idle"
 }
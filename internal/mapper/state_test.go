@@ -19,11 +19,12 @@ func TestBuildStateMessages(t *testing.T) {
 			name: "printer active and tfCard present",
 			input: map[string]any{
 				"printProgress": 10,
-				"leftTime":      100,
+				"printLeftTime": 100,
 				"tfCard":        1,
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status":  "active",
+				baseTopic + "/lifecycle":       "printing",
 				baseTopic + "/tf_card_present": "true",
 			},
 		},
@@ -34,6 +35,7 @@ func TestBuildStateMessages(t *testing.T) {
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status": "idle",
+				baseTopic + "/lifecycle":      "idle",
 			},
 		},
 		{
@@ -43,6 +45,7 @@ func TestBuildStateMessages(t *testing.T) {
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status":  "idle",
+				baseTopic + "/lifecycle":       "idle",
 				baseTopic + "/tf_card_present": "false",
 			},
 		},
@@ -53,6 +56,7 @@ func TestBuildStateMessages(t *testing.T) {
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status":  "idle",
+				baseTopic + "/lifecycle":       "idle",
 				baseTopic + "/tf_card_present": "true",
 			},
 		},
@@ -60,10 +64,11 @@ func TestBuildStateMessages(t *testing.T) {
 			name: "active when printing heuristics",
 			input: map[string]any{
 				"printProgress": 50,
-				"leftTime":      200,
+				"printLeftTime": 200,
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status": "active",
+				baseTopic + "/lifecycle":      "printing",
 			},
 		},
 		{
@@ -73,6 +78,19 @@ func TestBuildStateMessages(t *testing.T) {
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status": "active",
+				baseTopic + "/lifecycle":      "printing",
+			},
+		},
+		{
+			name: "idle once the job is over",
+			input: map[string]any{
+				"state":        2,
+				"printJobTime": 1272,
+				"layer":        62,
+			},
+			expected: map[string]string{
+				baseTopic + "/printer_status": "idle",
+				baseTopic + "/lifecycle":      "completed",
 			},
 		},
 		{
@@ -80,6 +98,7 @@ func TestBuildStateMessages(t *testing.T) {
 			input: map[string]any{},
 			expected: map[string]string{
 				baseTopic + "/printer_status": "idle",
+				baseTopic + "/lifecycle":      "idle",
 			},
 		},
 		{
@@ -91,21 +110,17 @@ func TestBuildStateMessages(t *testing.T) {
 			},
 			expected: map[string]string{
 				baseTopic + "/printer_status": "idle",
+				baseTopic + "/lifecycle":      "idle",
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Reset rate-limit cache to ensure first publish per test case
-			printerStatusCache.mu.Lock()
-			printerStatusCache.lastStatus = ""
-			printerStatusCache.lastPublished = time.Time{}
-			printerStatusCache.lastUpdate = time.Time{}
-			printerStatusCache.mu.Unlock()
-
 			got := BuildStateMessages(tt.input, baseTopic)
 			tp := toTopicMap(got)
+			// The transition time is covered by TestMapper_Lifecycle
+			delete(tp, baseTopic+"/lifecycle/attributes")
 
 			// Check that we got the expected number of topics
 			if len(tp) != len(tt.expected) {
@@ -124,7 +139,7 @@ func TestBuildStateMessages(t *testing.T) {
 				}
 			}
 
-			// Ensure no unexpected topics were generated beyond printer_status/tf_card_present
+			// Ensure no unexpected topics were generated beyond printer_status/lifecycle/tf_card_present
 			for topic := range tp {
 				if _, expected := tt.expected[topic]; !expected {
 					t.Errorf("unexpected topic generated: %s", topic)
@@ -134,6 +149,78 @@ func TestBuildStateMessages(t *testing.T) {
 	}
 }
 
+func TestMapper_PrinterStatusRateLimit(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	now := start
+	clock := func() time.Time { return now }
+	k1, k2 := New(10*time.Second, clock), New(10*time.Second, clock)
+
+	active := map[string]any{"printProgress": 10, "printLeftTime": 100}
+	idle := map[string]any{}
+
+	steps := []struct {
+		after  time.Duration
+		mapper *Mapper
+		msg    map[string]any
+		want   string // "" when not published
+	}{
+		{0, k1, active, "active"},
+		{time.Second, k1, active, ""},
+		// Every printer has its own rate limit
+		{time.Second, k2, idle, "idle"},
+		// Stays active for an interval after the last activity
+		{5 * time.Second, k1, idle, ""},
+		{11 * time.Second, k1, active, "active"},
+		{12 * time.Second, k1, idle, ""},
+		{21 * time.Second, k1, idle, "idle"},
+		{25 * time.Second, k2, idle, "idle"},
+	}
+
+	for i, s := range steps {
+		now = start.Add(s.after)
+		got := ""
+		for _, m := range s.mapper.BuildStateMessages(s.msg, "bt") {
+			if m.Topic == "bt/printer_status" {
+				got = m.Payload
+			}
+		}
+		if got != s.want {
+			t.Errorf("step %d at %s: printer_status = %q, want %q", i, s.after, got, s.want)
+		}
+	}
+}
+
+func TestJobState(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input map[string]any
+		want  string
+	}{
+		{"empty", map[string]any{}, JobIdle},
+		{"idle after a print", map[string]any{"state": 2, "printProgress": 100, "printLeftTime": 0}, JobIdle},
+		{"printing by state", map[string]any{"state": 1}, JobPrinting},
+		{"printing by progress", map[string]any{"printProgress": 40, "printLeftTime": 600}, JobPrinting},
+		{"printing by gcodeState", map[string]any{"gcodeState": 1}, JobPrinting},
+		{"heating", map[string]any{"state": 1, "nozzleTemp": "25.0", "targetNozzleTemp": 220}, JobPrinting},
+		{"printing by state with progress", map[string]any{"state": 1, "printProgress": 40}, JobPrinting},
+		{"cancelled", map[string]any{"state": 4, "printProgress": 40, "printLeftTime": 600}, JobIdle},
+		{"paused", map[string]any{"state": 5, "printProgress": 40, "printLeftTime": 600}, JobPaused},
+		{"paused as string", map[string]any{"state": "5"}, JobPaused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := JobState(tt.input); got != tt.want {
+				t.Errorf("JobState() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // func TestDeriveOnline(t *testing.T) {
 // 	t.Parallel()
 
@@ -2,8 +2,8 @@ package mapper
 
 import (
 	"fmt"
-	"strconv"
 
+	"github.com/davidcollom/creality2mqtt/internal/state"
 	"github.com/davidcollom/creality2mqtt/internal/types"
 )
 
@@ -76,23 +76,5 @@ func getFloat(msg map[string]any, key string) (float64, bool) {
 	if !ok {
 		return 0, false
 	}
-
-	switch v := raw.(type) {
-	case float64:
-		return v, true
-	case int:
-		return float64(v), true
-	case int32:
-		return float64(v), true
-	case int64:
-		return float64(v), true
-	case string:
-		f, err := strconv.ParseFloat(v, 64)
-		if err != nil {
-			return 0, false
-		}
-		return f, true
-	default:
-		return 0, false
-	}
+	return state.FloatValue(raw)
 }
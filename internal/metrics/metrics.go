@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "creality2mqtt"
+
+// Registry holds every metric of the bridge
+var Registry = prometheus.NewRegistry()
+
+// Bridge internals
+var (
+	WSReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_reconnects_total",
+		Help:      "Printer WebSocket reconnections after the first connection.",
+	}, []string{"printer"})
+
+	FramesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_frames_received_total",
+		Help:      "Frames received from the printer WebSocket.",
+	}, []string{"printer"})
+
+	DecodeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_decode_errors_total",
+		Help:      "Printer frames that could not be decoded.",
+	}, []string{"printer"})
+
+	WSQueuedCommands = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_queued_commands",
+		Help:      "Commands waiting for the printer WebSocket to reconnect.",
+	}, []string{"printer"})
+
+	MQTTPublishes = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publishes_total",
+		Help:      "Messages published to the MQTT broker.",
+	})
+
+	MQTTDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_publishes_dropped_total",
+		Help:      "Messages dropped because the MQTT broker was not connected.",
+	})
+
+	MQTTCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_coalesced_total",
+		Help:      "Messages held back by the per-topic rate limiter.",
+	})
+
+	MQTTDeduplicated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mqtt_messages_deduplicated_total",
+		Help:      "Messages not published because they did not change, or changed less than the topic's deadband.",
+	})
+)
+
+// Printer gauges, labelled by device ID
+var (
+	Temperature = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "printer_temperature_celsius",
+		Help:      "Printer temperatures by heater (nozzle, bed0, box) and kind (current, target).",
+	}, []string{"device_id", "heater", "kind"})
+
+	Progress = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "printer_job_progress_percent",
+		Help:      "Progress of the current print job.",
+	}, []string{"device_id"})
+
+	Layer = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "printer_job_layer",
+		Help:      "Current and total layer of the print job.",
+	}, []string{"device_id", "kind"})
+
+	FanSpeed = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "printer_fan_speed_percent",
+		Help:      "Fan speeds (model, auxiliary, case).",
+	}, []string{"device_id", "fan"})
+
+	CFSHumidity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "printer_cfs_humidity_percent",
+		Help:      "Relative humidity inside each CFS box.",
+	}, []string{"device_id", "box"})
+)
+
+var printerGauges = []*prometheus.GaugeVec{Temperature, Progress, Layer, FanSpeed, CFSHumidity}
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		WSReconnects, FramesReceived, DecodeErrors, WSQueuedCommands,
+		MQTTPublishes, MQTTDropped, MQTTCoalesced, MQTTDeduplicated,
+	)
+	for _, g := range printerGauges {
+		Registry.MustRegister(g)
+	}
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObservePrinter updates the printer gauges from the messages the mapper produced
+func ObservePrinter(deviceID, baseTopic string, msgs []types.MqttMessage) {
+	for _, m := range msgs {
+		sub, ok := strings.CutPrefix(m.Topic, baseTopic+"/")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(m.Payload, 64)
+		if err != nil {
+			continue
+		}
+
+		parts := strings.Split(sub, "/")
+		switch {
+		case len(parts) == 3 && parts[0] == "temperature":
+			Temperature.WithLabelValues(deviceID, parts[1], parts[2]).Set(v)
+		case sub == "job/progress":
+			Progress.WithLabelValues(deviceID).Set(v)
+		case len(parts) == 3 && parts[0] == "job" && parts[1] == "layer":
+			Layer.WithLabelValues(deviceID, parts[2]).Set(v)
+		case sub == "model_fan_pct":
+			FanSpeed.WithLabelValues(deviceID, "model").Set(v)
+		case sub == "auxiliary_fan_pct":
+			FanSpeed.WithLabelValues(deviceID, "auxiliary").Set(v)
+		case sub == "case_fan_pct":
+			FanSpeed.WithLabelValues(deviceID, "case").Set(v)
+		case len(parts) == 3 && parts[0] == "cfs" && parts[2] == "humidity":
+			CFSHumidity.WithLabelValues(deviceID, parts[1]).Set(v)
+		}
+	}
+}
+
+// ForgetPrinter removes every series of a printer that is no longer bridged
+func ForgetPrinter(name, deviceID string) {
+	for _, c := range []*prometheus.CounterVec{WSReconnects, FramesReceived, DecodeErrors} {
+		c.DeleteLabelValues(name)
+	}
+	WSQueuedCommands.DeleteLabelValues(name)
+	if deviceID == "" {
+		return
+	}
+	for _, g := range printerGauges {
+		g.DeletePartialMatch(prometheus.Labels{"device_id": deviceID})
+	}
+}
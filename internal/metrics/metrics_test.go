@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidcollom/creality2mqtt/internal/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservePrinter(t *testing.T) {
+	msgs := []types.MqttMessage{
+		{Topic: "bt/temperature/nozzle/current", Payload: "215.500"},
+		{Topic: "bt/temperature/bed0/target", Payload: "60.000"},
+		{Topic: "bt/job/progress", Payload: "42"},
+		{Topic: "bt/job/layer/current", Payload: "12"},
+		{Topic: "bt/job/layer/total", Payload: "200"},
+		{Topic: "bt/model_fan_pct", Payload: "100"},
+		{Topic: "bt/case_fan_pct", Payload: "30"},
+		{Topic: "bt/cfs/1/humidity", Payload: "35.2"},
+		// Ignored: not numeric, not a gauge, other printer
+		{Topic: "bt/job/file_name", Payload: "benchy.gcode"},
+		{Topic: "bt/printer_status", Payload: "idle"},
+		{Topic: "other/job/progress", Payload: "99"},
+	}
+	ObservePrinter("dev_obs", "bt", msgs)
+
+	assert.Equal(t, 215.5, testutil.ToFloat64(Temperature.WithLabelValues("dev_obs", "nozzle", "current")))
+	assert.Equal(t, 60.0, testutil.ToFloat64(Temperature.WithLabelValues("dev_obs", "bed0", "target")))
+	assert.Equal(t, 42.0, testutil.ToFloat64(Progress.WithLabelValues("dev_obs")))
+	assert.Equal(t, 12.0, testutil.ToFloat64(Layer.WithLabelValues("dev_obs", "current")))
+	assert.Equal(t, 200.0, testutil.ToFloat64(Layer.WithLabelValues("dev_obs", "total")))
+	assert.Equal(t, 100.0, testutil.ToFloat64(FanSpeed.WithLabelValues("dev_obs", "model")))
+	assert.Equal(t, 30.0, testutil.ToFloat64(FanSpeed.WithLabelValues("dev_obs", "case")))
+	assert.Equal(t, 35.2, testutil.ToFloat64(CFSHumidity.WithLabelValues("dev_obs", "1")))
+	assert.Equal(t, 1, testutil.CollectAndCount(Progress))
+}
+
+func TestForgetPrinter(t *testing.T) {
+	FramesReceived.WithLabelValues("gone").Inc()
+	WSQueuedCommands.WithLabelValues("gone").Set(2)
+	ObservePrinter("dev_gone", "bt", []types.MqttMessage{
+		{Topic: "bt/temperature/nozzle/current", Payload: "200"},
+		{Topic: "bt/cfs/2/humidity", Payload: "40"},
+	})
+	ObservePrinter("dev_kept", "bt", []types.MqttMessage{{Topic: "bt/temperature/nozzle/current", Payload: "190"}})
+
+	ForgetPrinter("gone", "dev_gone")
+
+	body := scrape(t)
+	assert.NotContains(t, body, `printer="gone"`)
+	assert.NotContains(t, body, `device_id="dev_gone"`)
+	assert.Contains(t, body, `creality2mqtt_printer_temperature_celsius{device_id="dev_kept",heater="nozzle",kind="current"} 190`)
+}
+
+func TestHandler(t *testing.T) {
+	body := scrape(t)
+	assert.Contains(t, body, "creality2mqtt_mqtt_publishes_total")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
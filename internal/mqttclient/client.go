@@ -2,27 +2,75 @@ package mqttclient
 
 import (
 	"fmt"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 type Client struct {
 	client mqtt.Client
+	broker string
 	mu     sync.RWMutex
 
 	// rate limiting
 	minInterval   time.Duration
 	lastPublished map[string]time.Time
-	// pending payload to coalesce within interval
+	// pending payload to coalesce within interval, published by a flush at
+	// the end of the window
 	lastPayload map[string]string
+	timers      map[string]*pendingFlush
+	// change-only publishing, and the refreshes of the topics published
+	dedup     deduper
+	refreshes map[string]*pendingFlush
+	// subscriptions to restore after Reconnect
+	subs map[string]mqtt.MessageHandler
+	// reconnecting serializes Reconnect, which dials without holding mu
+	reconnecting sync.Mutex
 	// test hook to bypass IsConnected checks
 	testBypassConnection bool
+
+	// clock and broker dialer, replaced in tests
+	now       func() time.Time
+	afterFunc func(d time.Duration, f func()) (stop func() bool)
+	dial      func(brokerURL, clientID, username, password, willTopic, willPayload string) (mqtt.Client, error)
+}
+
+// pendingFlush is a scheduled flush of a topic's pending payload, or refresh of its last one
+type pendingFlush struct {
+	stop func() bool
 }
 
 func New(brokerURL, clientID, username, password, willTopic, willPayload string) (*Client, error) {
+	c, err := connect(brokerURL, clientID, username, password, willTopic, willPayload)
+	if err != nil {
+		return nil, err
+	}
+	return NewWithClient(c, brokerURL), nil
+}
+
+// NewWithClient wraps an already connected paho client, brokerURL is what
+// Broker reports
+func NewWithClient(c mqtt.Client, brokerURL string) *Client {
+	return &Client{
+		client:        c,
+		broker:        brokerURL,
+		lastPublished: make(map[string]time.Time),
+		lastPayload:   make(map[string]string),
+		subs:          make(map[string]mqtt.MessageHandler),
+		now:           time.Now,
+		afterFunc: func(d time.Duration, f func()) func() bool {
+			return time.AfterFunc(d, f).Stop
+		},
+		dial: connect,
+	}
+}
+
+func connect(brokerURL, clientID, username, password, willTopic, willPayload string) (mqtt.Client, error) {
 	opts := mqtt.NewClientOptions().
 		AddBroker(brokerURL).
 		SetClientID(clientID).
@@ -48,12 +96,85 @@ func New(brokerURL, clientID, username, password, willTopic, willPayload string)
 	}
 
 	log.Info("MQTT connected", "broker", brokerURL)
-	return &Client{client: c, minInterval: 0, lastPublished: make(map[string]time.Time), lastPayload: make(map[string]string)}, nil
+	return c, nil
+}
+
+// Reconnect replaces the broker connection with one using the new settings and
+// restores all subscriptions. The old connection is closed cleanly first so its
+// Last Will is not published; if the new one can't be established the old one
+// is connected again. Rate limiting state is kept; change-only publishing
+// starts over on a new connection.
+//
+// The lock is only held to swap the connection in: publishes while dialing are
+// dropped as they would be for any disconnected client.
+func (c *Client) Reconnect(brokerURL, clientID, username, password, willTopic, willPayload string) error {
+	c.reconnecting.Lock()
+	defer c.reconnecting.Unlock()
+
+	c.mu.RLock()
+	old := c.client
+	c.mu.RUnlock()
+
+	if old.IsConnected() {
+		log.Debug("Disconnecting MQTT client for reconnect")
+		old.Disconnect(250)
+	}
+
+	nc, err := c.dial(brokerURL, clientID, username, password, willTopic, willPayload)
+	if err != nil {
+		// Try to get the previous connection back
+		token := old.Connect()
+		if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+			log.Error("Failed to restore previous MQTT connection", "error", token.Error())
+		}
+		nc = old
+	}
+
+	c.mu.Lock()
+	if err == nil {
+		c.client = nc
+		c.broker = brokerURL
+		// The new broker has not seen any payload yet
+		c.dedup.reset()
+	}
+	subs := maps.Clone(c.subs)
+	c.mu.Unlock()
+
+	// Restore outside the lock: handlers of retained messages may publish
+	for topic, handler := range subs {
+		token := nc.Subscribe(topic, 0, handler)
+		if ok := token.WaitTimeout(5 * time.Second); !ok || token.Error() != nil {
+			log.Error("Failed to restore MQTT subscription", "topic", topic, "error", token.Error())
+		}
+	}
+	return err
+}
+
+// IsConnected reports whether the broker connection is up
+func (c *Client) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client.IsConnected()
+}
+
+// Broker returns the URL of the broker currently used
+func (c *Client) Broker() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.broker
 }
 
+// Disconnect publishes the payloads held back by the rate limiter and closes
+// the broker connection
 func (c *Client) Disconnect() {
+	c.Flush()
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	for _, pf := range c.refreshes {
+		pf.stop()
+	}
+	c.refreshes = nil
 
 	if c.client.IsConnected() {
 		log.Debug("Disconnecting MQTT client")
@@ -64,64 +185,252 @@ func (c *Client) Disconnect() {
 func (c *Client) Publish(topic, payload string, retain bool) {
 	// Shortcut for disconnected client
 	c.mu.RLock()
-	connected := c.testBypassConnection || c.client.IsConnected()
+	cl := c.client
+	connected := c.testBypassConnection || cl.IsConnected()
+	minInterval := c.minInterval
 	c.mu.RUnlock()
 	if !connected {
 		log.Warn("MQTT not connected, dropping message", "topic", topic, "payload", payload)
+		metrics.MQTTDropped.Inc()
+		return
+	}
+
+	// Do not throttle or deduplicate retained messages (discovery, availability)
+	if retain {
+		publish(cl, topic, retain, payload)
 		return
 	}
 
-	// Do not throttle retained messages (discovery, availability)
-	if retain || c.minInterval <= 0 {
-		token := c.client.Publish(topic, 0, retain, payload)
-		ok := token.WaitTimeout(5 * time.Second)
-		if !ok || token.Error() != nil {
-			log.Error("MQTT publish failed", "topic", topic, "error", token.Error())
+	now := c.now()
+
+	// Critical section to decide whether to publish now, defer or drop
+	c.mu.Lock()
+	if minInterval > 0 {
+		// Rate limiting with coalescing: at most one publish per interval per topic
+		last := c.lastPublished[topic]
+		if wait := last.Add(minInterval).Sub(now); wait > 0 {
+			// Within interval: keep the latest payload for the end of the window
+			c.lastPayload[topic] = payload
+			c.scheduleFlush(topic, wait)
+			c.mu.Unlock()
+			metrics.MQTTCoalesced.Inc()
+			return
 		}
+	}
+	// Interval elapsed: this payload supersedes any pending one
+	c.dropPending(topic)
+	send := c.admit(topic, payload, now)
+	c.mu.Unlock()
+
+	if send {
+		c.send(cl, topic, payload)
+	}
+}
+
+// PublishNow publishes a non-retained payload right away, bypassing the rate
+// limit and change-only publishing, for feedback such as command results where
+// every payload matters. It supersedes a payload of the topic held back by the
+// rate limiter, and later payloads are compared against it.
+func (c *Client) PublishNow(topic, payload string) {
+	c.mu.Lock()
+	cl := c.client
+	if !c.testBypassConnection && !cl.IsConnected() {
+		c.mu.Unlock()
+		log.Warn("MQTT not connected, dropping message", "topic", topic, "payload", payload)
+		metrics.MQTTDropped.Inc()
+		return
+	}
+	c.dropPending(topic)
+	c.dedup.record(topic, payload, c.now())
+	c.mu.Unlock()
+
+	c.send(cl, topic, payload)
+}
+
+// scheduleFlush publishes the pending payload of a topic at the end of its
+// window, unless a flush is scheduled already. c.mu must be held.
+func (c *Client) scheduleFlush(topic string, wait time.Duration) {
+	if _, ok := c.timers[topic]; ok {
 		return
 	}
+	if c.timers == nil {
+		c.timers = make(map[string]*pendingFlush)
+	}
+	pf := &pendingFlush{}
+	// The flush waits for c.mu, so stop is set before it runs
+	pf.stop = c.afterFunc(wait, func() { c.flush(topic, pf) })
+	c.timers[topic] = pf
+}
 
-	// Rate limiting with coalescing: at most one publish per interval per topic
-	now := time.Now()
+// dropPending discards the pending payload of a topic and its flush. c.mu must be held.
+func (c *Client) dropPending(topic string) {
+	if pf, ok := c.timers[topic]; ok {
+		pf.stop()
+		delete(c.timers, topic)
+	}
+	delete(c.lastPayload, topic)
+}
 
-	// Critical section to decide whether to publish now or defer
+// flush publishes the pending payload of a topic. pf is the scheduled flush
+// that fired, nil when flushing on demand.
+func (c *Client) flush(topic string, pf *pendingFlush) {
 	c.mu.Lock()
-	last := c.lastPublished[topic]
-	elapsed := now.Sub(last)
-	if elapsed < c.minInterval {
-		// Within interval: store latest payload and skip publish
-		c.lastPayload[topic] = payload
+	if pf != nil && c.timers[topic] != pf {
+		// Superseded by a publish or a Flush while waiting for the lock
 		c.mu.Unlock()
 		return
 	}
+	delete(c.timers, topic)
+	payload, ok := c.lastPayload[topic]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.lastPayload, topic)
+	cl := c.client
+	if !c.testBypassConnection && !cl.IsConnected() {
+		c.mu.Unlock()
+		log.Warn("MQTT not connected, dropping message", "topic", topic, "payload", payload)
+		metrics.MQTTDropped.Inc()
+		return
+	}
+	send := c.admit(topic, payload, c.now())
+	c.mu.Unlock()
+
+	if send {
+		c.send(cl, topic, payload)
+	}
+}
+
+// Flush publishes every payload held back by the rate limiter now
+func (c *Client) Flush() {
+	c.mu.Lock()
+	for _, pf := range c.timers {
+		pf.stop()
+	}
+	c.timers = nil
+	topics := slices.Sorted(maps.Keys(c.lastPayload))
+	c.mu.Unlock()
+
+	for _, topic := range topics {
+		c.flush(topic, nil)
+	}
+}
+
+// admit applies change-only publishing and records the publish for the rate
+// limiter. It reports whether the payload is to be sent. c.mu must be held.
+func (c *Client) admit(topic, payload string, now time.Time) bool {
+	if c.dedup.suppress(topic, payload, now) {
+		metrics.MQTTDeduplicated.Inc()
+		return false
+	}
+	c.dedup.record(topic, payload, now)
+	if c.minInterval > 0 {
+		c.lastPublished[topic] = now
+	}
+	c.scheduleRefresh(topic, c.dedup.cfg.Refresh)
+	return true
+}
+
+// scheduleRefresh publishes the last payload of a topic again once it is as
+// old as the refresh interval, so a topic the printer stopped sending stays
+// alive. Nothing is scheduled without change-only publishing or when a
+// refresh is scheduled already. c.mu must be held.
+func (c *Client) scheduleRefresh(topic string, wait time.Duration) {
+	if !c.dedup.cfg.Enabled || c.dedup.cfg.Refresh <= 0 {
+		return
+	}
+	if _, ok := c.refreshes[topic]; ok {
+		return
+	}
+	if c.refreshes == nil {
+		c.refreshes = make(map[string]*pendingFlush)
+	}
+	pf := &pendingFlush{}
+	// The refresh waits for c.mu, so stop is set before it runs
+	pf.stop = c.afterFunc(wait, func() { c.refresh(topic, pf) })
+	c.refreshes[topic] = pf
+}
 
-	// Interval elapsed: choose the latest known payload for this topic
-	toSend := payload
-	if pending, ok := c.lastPayload[topic]; ok && pending != "" {
-		toSend = pending
-		delete(c.lastPayload, topic)
+// refresh publishes the last payload of a topic again, unless something was
+// published on it since the refresh was scheduled
+func (c *Client) refresh(topic string, pf *pendingFlush) {
+	c.mu.Lock()
+	if c.refreshes[topic] != pf {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.refreshes, topic)
+	last, ok := c.dedup.sent[topic]
+	if !ok {
+		// Forgotten, e.g. on a new broker: the next publish starts over
+		c.mu.Unlock()
+		return
+	}
+	now := c.now()
+	if wait := last.at.Add(c.dedup.cfg.Refresh).Sub(now); wait > 0 {
+		// Published since, or the interval changed
+		c.scheduleRefresh(topic, wait)
+		c.mu.Unlock()
+		return
+	}
+	if _, pending := c.lastPayload[topic]; pending {
+		// The rate limiter publishes a newer payload at the end of its window
+		c.scheduleRefresh(topic, c.dedup.cfg.Refresh)
+		c.mu.Unlock()
+		return
+	}
+	cl := c.client
+	if !c.testBypassConnection && !cl.IsConnected() {
+		// Try again later rather than dropping the topic's refreshes
+		c.scheduleRefresh(topic, c.dedup.cfg.Refresh)
+		c.mu.Unlock()
+		return
+	}
+	c.dedup.record(topic, last.payload, now)
+	if c.minInterval > 0 {
+		c.lastPublished[topic] = now
 	}
-	// Update lastPublished before unlock to avoid duplicate sends by racers
-	c.lastPublished[topic] = now
+	c.scheduleRefresh(topic, c.dedup.cfg.Refresh)
 	c.mu.Unlock()
 
-	token := c.client.Publish(topic, 0, retain, toSend)
+	log.Debug("Refreshing idle MQTT topic", "topic", topic, "payload", last.payload)
+	c.send(cl, topic, last.payload)
+}
+
+// send publishes a non-retained payload admitted by the rate limiter
+func (c *Client) send(cl mqtt.Client, topic, payload string) {
+	if !publish(cl, topic, false, payload) {
+		// Let the next identical payload through
+		c.mu.Lock()
+		c.dedup.forget(topic, payload)
+		c.mu.Unlock()
+	}
+}
+
+func publish(cl mqtt.Client, topic string, retain bool, payload string) bool {
+	token := cl.Publish(topic, 0, retain, payload)
 	ok := token.WaitTimeout(5 * time.Second)
 	if !ok || token.Error() != nil {
 		log.Error("MQTT publish failed", "topic", topic, "error", token.Error())
+		return false
 	}
+	metrics.MQTTPublishes.Inc()
+	return true
 }
 
-// Subscribe subscribes to an MQTT topic with a message handler
+// Subscribe subscribes to an MQTT topic with a message handler.
+// The subscription is restored after Reconnect.
 func (c *Client) Subscribe(topic string, handler mqtt.MessageHandler) error {
 	c.mu.RLock()
-	defer c.mu.RUnlock()
+	cl := c.client
+	c.mu.RUnlock()
 
-	if !c.client.IsConnected() {
+	if !cl.IsConnected() {
 		return fmt.Errorf("mqtt not connected")
 	}
 
-	token := c.client.Subscribe(topic, 0, handler)
+	token := cl.Subscribe(topic, 0, handler)
 	ok := token.WaitTimeout(5 * time.Second)
 	if !ok {
 		return fmt.Errorf("subscribe timeout for topic: %s", topic)
@@ -130,10 +439,38 @@ func (c *Client) Subscribe(topic string, handler mqtt.MessageHandler) error {
 		return fmt.Errorf("subscribe failed for topic %s: %w", topic, token.Error())
 	}
 
+	c.mu.Lock()
+	c.subs[topic] = handler
+	c.mu.Unlock()
+
 	log.Info("Subscribed to MQTT topic", "topic", topic)
 	return nil
 }
 
+// Unsubscribe removes a subscription
+func (c *Client) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	cl := c.client
+	delete(c.subs, topic)
+	c.mu.Unlock()
+
+	if !cl.IsConnected() {
+		return nil
+	}
+
+	token := cl.Unsubscribe(topic)
+	ok := token.WaitTimeout(5 * time.Second)
+	if !ok {
+		return fmt.Errorf("unsubscribe timeout for topic: %s", topic)
+	}
+	if token.Error() != nil {
+		return fmt.Errorf("unsubscribe failed for topic %s: %w", topic, token.Error())
+	}
+
+	log.Info("Unsubscribed from MQTT topic", "topic", topic)
+	return nil
+}
+
 // SetMinInterval sets a minimum interval between publishes per topic.
 // If set to 0, rate limiting is disabled.
 func (c *Client) SetMinInterval(d time.Duration) {
@@ -142,6 +479,14 @@ func (c *Client) SetMinInterval(d time.Duration) {
 	c.minInterval = d
 }
 
+// SetDedup configures change-only publishing of non-retained messages.
+// The payloads published so far are kept.
+func (c *Client) SetDedup(cfg DedupConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dedup.cfg = cfg
+}
+
 // SetTestBypassConnection enables bypassing IsConnected checks (for unit tests only).
 func (c *Client) SetTestBypassConnection(b bool) {
 	c.mu.Lock()
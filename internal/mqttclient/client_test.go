@@ -50,12 +50,7 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   0,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
 
 				return client, func() {}
 			},
@@ -79,12 +74,8 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   1 * time.Second,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
+				client.minInterval = 1 * time.Second
 
 				return client, func() {}
 			},
@@ -107,12 +98,7 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   0,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
 
 				return client, func() {}
 			},
@@ -136,12 +122,8 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   1 * time.Second,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
+				client.minInterval = 1 * time.Second
 
 				return client, func() {}
 			},
@@ -166,12 +148,9 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   1 * time.Second,
-					lastPublished: map[string]time.Time{"test/ratelimited": time.Now()},
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
+				client.minInterval = 1 * time.Second
+				client.lastPublished = map[string]time.Time{"test/ratelimited": time.Now()}
 
 				return client, func() {}
 			},
@@ -194,12 +173,7 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   0,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
 
 				return client, func() {}
 			},
@@ -222,12 +196,7 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   0,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
 
 				return client, func() {}
 			},
@@ -250,12 +219,7 @@ func TestClient_Publish(t *testing.T) {
 				opts := mqtt.NewClientOptions().SetClientID("test-client")
 				mockClient := mqtt.NewClient(opts)
 
-				client := &Client{
-					client:        mockClient,
-					minInterval:   0,
-					lastPublished: make(map[string]time.Time),
-					lastPayload:   make(map[string]string),
-				}
+				client := NewWithClient(mockClient, "")
 
 				return client, func() {}
 			},
@@ -267,6 +231,8 @@ func TestClient_Publish(t *testing.T) {
 			// Setup
 			client, cleanup := tt.setup()
 			defer cleanup()
+			// Publish what is still pending instead of leaving a flush behind
+			defer client.Flush()
 
 			// Set min interval if specified
 			if tt.input.minInterval > 0 {
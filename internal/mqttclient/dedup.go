@@ -0,0 +1,121 @@
+package mqttclient
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DedupConfig controls change-only publishing of non-retained messages
+type DedupConfig struct {
+	// Enabled suppresses payloads identical to the last one published on a topic
+	Enabled bool
+	// Refresh publishes an unchanged payload again once the last publish is
+	// this old, so idle topics stay alive (0 = never)
+	Refresh time.Duration
+	// Deadbands suppress small numeric changes, the first match wins
+	Deadbands []Deadband
+}
+
+// Deadband suppresses numeric payloads within ±Delta of the last one published
+// on topics matching Pattern. The pattern is matched against the last levels
+// of the topic, so "temperature/+/current" applies to every printer; "+"
+// matches a single level.
+type Deadband struct {
+	Pattern string
+	Delta   float64
+}
+
+// Matches reports whether the pattern matches the end of the topic
+func (d Deadband) Matches(topic string) bool {
+	pattern := strings.Split(d.Pattern, "/")
+	levels := strings.Split(topic, "/")
+	if len(pattern) > len(levels) {
+		return false
+	}
+	levels = levels[len(levels)-len(pattern):]
+	for i, p := range pattern {
+		if p != "+" && p != levels[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// sentPayload is the last payload published on a topic
+type sentPayload struct {
+	payload string
+	at      time.Time
+}
+
+// deduper decides whether a payload differs enough from the last one
+// published on its topic. It is guarded by the client's mutex.
+type deduper struct {
+	cfg  DedupConfig
+	sent map[string]sentPayload
+}
+
+// suppress reports whether the payload should not be published. A payload that
+// is published has to be recorded with sent.
+func (d *deduper) suppress(topic, payload string, now time.Time) bool {
+	if !d.cfg.Enabled {
+		return false
+	}
+	last, ok := d.sent[topic]
+	if !ok {
+		return false
+	}
+	if d.cfg.Refresh > 0 && now.Sub(last.at) >= d.cfg.Refresh {
+		return false
+	}
+	if payload == last.payload {
+		return true
+	}
+	band, ok := d.deadband(topic)
+	if !ok {
+		return false
+	}
+	prev, err := strconv.ParseFloat(strings.TrimSpace(last.payload), 64)
+	if err != nil {
+		return false
+	}
+	next, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+	if err != nil {
+		return false
+	}
+	return math.Abs(next-prev) <= band.Delta
+}
+
+// record remembers a published payload. The deadband is measured from it, so
+// a slow drift is published once it adds up to more than the deadband.
+func (d *deduper) record(topic, payload string, now time.Time) {
+	if !d.cfg.Enabled {
+		return
+	}
+	if d.sent == nil {
+		d.sent = make(map[string]sentPayload)
+	}
+	d.sent[topic] = sentPayload{payload: payload, at: now}
+}
+
+// forget drops a payload that could not be published
+func (d *deduper) forget(topic, payload string) {
+	if last, ok := d.sent[topic]; ok && last.payload == payload {
+		delete(d.sent, topic)
+	}
+}
+
+func (d *deduper) deadband(topic string) (Deadband, bool) {
+	for _, band := range d.cfg.Deadbands {
+		if band.Matches(topic) {
+			return band, true
+		}
+	}
+	return Deadband{}, false
+}
+
+// reset forgets every published payload, e.g. for a new broker
+func (d *deduper) reset() {
+	d.sent = nil
+}
@@ -0,0 +1,151 @@
+package mqttclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadband_Matches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		want    bool
+	}{
+		{"temperature/+/current", "3dprinter/k1/temperature/nozzle/current", true},
+		{"temperature/+/current", "3dprinter/temperature/bed0/current", true},
+		{"temperature/+/current", "3dprinter/k1/temperature/nozzle/target", false},
+		{"job/progress", "creality/printer/job/progress", true},
+		{"job/progress", "job/progress", true},
+		{"job/progress", "progress", false},
+		{"cfs/+/humidity", "bt/cfs/1/humidity", true},
+		{"+", "bt/anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.topic, func(t *testing.T) {
+			assert.Equal(t, tt.want, Deadband{Pattern: tt.pattern, Delta: 1}.Matches(tt.topic))
+		})
+	}
+}
+
+func TestDeduper(t *testing.T) {
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	cfg := DedupConfig{
+		Enabled: true,
+		Refresh: 5 * time.Minute,
+		Deadbands: []Deadband{
+			{Pattern: "temperature/+/current", Delta: 0.5},
+			{Pattern: "job/progress", Delta: 1},
+		},
+	}
+
+	type publish struct {
+		after   time.Duration
+		topic   string
+		payload string
+		want    bool // published
+	}
+	tests := []struct {
+		name    string
+		cfg     DedupConfig
+		publish []publish
+	}{
+		{
+			name: "disabled",
+			cfg:  DedupConfig{},
+			publish: []publish{
+				{0, "bt/printer_status", "idle", true},
+				{time.Second, "bt/printer_status", "idle", true},
+			},
+		},
+		{
+			name: "identical payloads",
+			cfg:  cfg,
+			publish: []publish{
+				{0, "bt/printer_status", "idle", true},
+				{time.Second, "bt/printer_status", "idle", false},
+				{2 * time.Second, "bt/printer_status", "printing", true},
+				{3 * time.Second, "bt/other", "printing", true},
+			},
+		},
+		{
+			name: "temperature deadband",
+			cfg:  cfg,
+			publish: []publish{
+				{0, "bt/temperature/nozzle/current", "219.9", true},
+				{time.Second, "bt/temperature/nozzle/current", "220.1", false},
+				{2 * time.Second, "bt/temperature/nozzle/current", "220.4", false},
+				// Measured from the last published value
+				{3 * time.Second, "bt/temperature/nozzle/current", "220.5", true},
+				{4 * time.Second, "bt/temperature/nozzle/current", "220.0", false},
+				// No deadband on the target
+				{5 * time.Second, "bt/temperature/nozzle/target", "220", true},
+				{6 * time.Second, "bt/temperature/nozzle/target", "219.9", true},
+			},
+		},
+		{
+			name: "progress deadband",
+			cfg:  cfg,
+			publish: []publish{
+				{0, "bt/job/progress", "58", true},
+				{time.Second, "bt/job/progress", "59", false},
+				{2 * time.Second, "bt/job/progress", "60", true},
+				// Not a number
+				{3 * time.Second, "bt/job/progress", "unknown", true},
+				{4 * time.Second, "bt/job/progress", "61", true},
+			},
+		},
+		{
+			name: "refresh",
+			cfg:  cfg,
+			publish: []publish{
+				{0, "bt/printer_status", "idle", true},
+				{4 * time.Minute, "bt/printer_status", "idle", false},
+				{5 * time.Minute, "bt/printer_status", "idle", true},
+				{6 * time.Minute, "bt/temperature/nozzle/current", "25", true},
+				{11 * time.Minute, "bt/temperature/nozzle/current", "25.2", true},
+			},
+		},
+		{
+			name: "no refresh",
+			cfg:  DedupConfig{Enabled: true},
+			publish: []publish{
+				{0, "bt/printer_status", "idle", true},
+				{24 * time.Hour, "bt/printer_status", "idle", false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := deduper{cfg: tt.cfg}
+			for i, p := range tt.publish {
+				now := start.Add(p.after)
+				published := !d.suppress(p.topic, p.payload, now)
+				assert.Equal(t, p.want, published, "publish %d: %s=%s", i, p.topic, p.payload)
+				if published {
+					d.record(p.topic, p.payload, now)
+				}
+			}
+		})
+	}
+}
+
+func TestDeduper_ForgetAndReset(t *testing.T) {
+	now := time.Now()
+	d := deduper{cfg: DedupConfig{Enabled: true}}
+
+	d.record("bt/a", "1", now)
+	d.record("bt/b", "2", now)
+	// A newer payload was recorded meanwhile
+	d.forget("bt/b", "1")
+	assert.True(t, d.suppress("bt/b", "2", now))
+
+	d.forget("bt/a", "1")
+	assert.False(t, d.suppress("bt/a", "1", now))
+
+	d.reset()
+	assert.False(t, d.suppress("bt/b", "2", now))
+}
@@ -0,0 +1,29 @@
+package mqttclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davidcollom/creality2mqtt/internal/metrics"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_PublishMetrics(t *testing.T) {
+	client := NewWithClient(mqtt.NewClient(mqtt.NewClientOptions().SetClientID("test-client")), "")
+	client.lastPublished["test/topic"] = time.Now()
+	defer client.Flush()
+	client.SetMinInterval(time.Minute)
+
+	dropped := testutil.ToFloat64(metrics.MQTTDropped)
+	client.Publish("test/topic", "1", false)
+	assert.Equal(t, dropped+1, testutil.ToFloat64(metrics.MQTTDropped))
+
+	client.SetTestBypassConnection(true)
+	coalesced := testutil.ToFloat64(metrics.MQTTCoalesced)
+	client.Publish("test/topic", "2", false)
+	client.Publish("test/topic", "3", false)
+	assert.Equal(t, coalesced+2, testutil.ToFloat64(metrics.MQTTCoalesced))
+	assert.Equal(t, "3", client.lastPayload["test/topic"])
+}
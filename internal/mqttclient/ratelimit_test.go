@@ -0,0 +1,343 @@
+package mqttclient
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock runs timers synchronously when the time is advanced past them
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		active := !t.stopped && slices.Contains(c.timers, t)
+		t.stopped = true
+		return active
+	}
+}
+
+// Advance moves the time forward, running due timers in order at their time
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		slices.SortStableFunc(c.timers, func(a, b *fakeTimer) int { return a.at.Compare(b.at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.stopped {
+			continue
+		}
+		c.now = t.at
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+// recordingClient is a connected broker connection that records publishes
+type recordingClient struct {
+	mqtt.Client
+	clock *fakeClock
+	start time.Time
+
+	mu        sync.Mutex
+	published []string
+}
+
+func (r *recordingClient) IsConnected() bool { return true }
+
+func (r *recordingClient) Disconnect(quiesce uint) {}
+
+func (r *recordingClient) Publish(topic string, qos byte, retained bool, payload any) mqtt.Token {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.published = append(r.published, fmt.Sprintf("%s=%v@%s", topic, payload, r.clock.Now().Sub(r.start)))
+	return &mqtt.DummyToken{}
+}
+
+func (r *recordingClient) Published() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.published)
+}
+
+func newTestClient(minInterval time.Duration, dedup DedupConfig) (*Client, *recordingClient, *fakeClock) {
+	start := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	broker := &recordingClient{clock: clock, start: start}
+	c := NewWithClient(broker, "tcp://test:1883")
+	c.now = clock.Now
+	c.afterFunc = clock.AfterFunc
+	c.SetMinInterval(minInterval)
+	c.SetDedup(dedup)
+	return c, broker, clock
+}
+
+func TestClient_RateLimitFlush(t *testing.T) {
+	type step struct {
+		at      time.Duration
+		op      string // publish (default), retain, now, flush or wait
+		topic   string
+		payload string
+	}
+
+	tests := []struct {
+		name        string
+		minInterval time.Duration
+		dedup       DedupConfig
+		steps       []step
+		want        []string
+	}{
+		{
+			name:        "last value of a burst is published at the end of the window",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, topic: "bt/temperature/nozzle/target", payload: "220"},
+				{at: 2 * time.Second, topic: "bt/temperature/nozzle/target", payload: "180"},
+				{at: 5 * time.Second, topic: "bt/temperature/nozzle/target", payload: "0"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{
+				"bt/temperature/nozzle/target=220@0s",
+				"bt/temperature/nozzle/target=0@10s",
+			},
+		},
+		{
+			name:        "windows follow the flushed publish",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, topic: "bt/job/progress", payload: "1"},
+				{at: time.Second, topic: "bt/job/progress", payload: "2"},
+				{at: 12 * time.Second, topic: "bt/job/progress", payload: "3"},
+				{at: 19 * time.Second, topic: "bt/job/progress", payload: "4"},
+				{at: 25 * time.Second, topic: "bt/job/progress", payload: "5"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{
+				"bt/job/progress=1@0s",
+				"bt/job/progress=2@10s",
+				"bt/job/progress=4@20s",
+				"bt/job/progress=5@30s",
+			},
+		},
+		{
+			name:        "publish after the window supersedes nothing pending",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, topic: "bt/layer", payload: "1"},
+				{at: 10 * time.Second, topic: "bt/layer", payload: "2"},
+				{at: 25 * time.Second, topic: "bt/layer", payload: "3"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{"bt/layer=1@0s", "bt/layer=2@10s", "bt/layer=3@25s"},
+		},
+		{
+			name:        "topics are limited independently",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, topic: "bt/a", payload: "1"},
+				{at: 3 * time.Second, topic: "bt/b", payload: "1"},
+				{at: 4 * time.Second, topic: "bt/a", payload: "2"},
+				{at: 5 * time.Second, topic: "bt/b", payload: "2"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{"bt/a=1@0s", "bt/b=1@3s", "bt/a=2@10s", "bt/b=2@13s"},
+		},
+		{
+			name:        "retained messages are not limited",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, op: "retain", topic: "bt/status", payload: "online"},
+				{at: time.Second, op: "retain", topic: "bt/status", payload: "offline"},
+			},
+			want: []string{"bt/status=online@0s", "bt/status=offline@1s"},
+		},
+		{
+			name:        "flush on shutdown",
+			minInterval: 10 * time.Second,
+			steps: []step{
+				{at: 0, topic: "bt/a", payload: "1"},
+				{at: time.Second, topic: "bt/a", payload: "2"},
+				{at: 2 * time.Second, topic: "bt/b", payload: "1"},
+				{at: 3 * time.Second, topic: "bt/b", payload: "2"},
+				{at: 4 * time.Second, op: "flush"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{"bt/a=1@0s", "bt/b=1@2s", "bt/a=2@4s", "bt/b=2@4s"},
+		},
+		{
+			name:        "flushed payload is deduplicated",
+			minInterval: 10 * time.Second,
+			dedup:       DedupConfig{Enabled: true},
+			steps: []step{
+				{at: 0, topic: "bt/printer_status", payload: "idle"},
+				{at: time.Second, topic: "bt/printer_status", payload: "printing"},
+				{at: 2 * time.Second, topic: "bt/printer_status", payload: "idle"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{"bt/printer_status=idle@0s"},
+		},
+		{
+			name:        "published now without rate limit or deduplication",
+			minInterval: 10 * time.Second,
+			dedup:       DedupConfig{Enabled: true},
+			steps: []step{
+				{at: 0, op: "now", topic: "bt/command/result", payload: "sent"},
+				{at: 0, op: "now", topic: "bt/command/result", payload: "confirmed"},
+				{at: time.Second, op: "now", topic: "bt/command/result", payload: "confirmed"},
+			},
+			want: []string{"bt/command/result=sent@0s", "bt/command/result=confirmed@0s", "bt/command/result=confirmed@1s"},
+		},
+		{
+			name:        "published now supersedes the pending payload",
+			minInterval: 10 * time.Second,
+			dedup:       DedupConfig{Enabled: true},
+			steps: []step{
+				{at: 0, topic: "bt/fan/model", payload: "0"},
+				{at: time.Second, topic: "bt/fan/model", payload: "50"},
+				{at: 2 * time.Second, op: "now", topic: "bt/fan/model", payload: "100"},
+				// Compared against the payload published now
+				{at: 15 * time.Second, topic: "bt/fan/model", payload: "100"},
+				{at: 16 * time.Second, topic: "bt/fan/model", payload: "0"},
+				{at: time.Minute, op: "wait"},
+			},
+			want: []string{"bt/fan/model=0@0s", "bt/fan/model=100@2s", "bt/fan/model=0@16s"},
+		},
+		{
+			name: "no rate limit",
+			steps: []step{
+				{at: 0, topic: "bt/a", payload: "1"},
+				{at: 0, topic: "bt/a", payload: "2"},
+				{at: time.Second, topic: "bt/a", payload: "3"},
+			},
+			want: []string{"bt/a=1@0s", "bt/a=2@0s", "bt/a=3@1s"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, broker, clock := newTestClient(tt.minInterval, tt.dedup)
+			var elapsed time.Duration
+			for _, s := range tt.steps {
+				clock.Advance(s.at - elapsed)
+				elapsed = s.at
+				switch s.op {
+				case "":
+					c.Publish(s.topic, s.payload, false)
+				case "retain":
+					c.Publish(s.topic, s.payload, true)
+				case "now":
+					c.PublishNow(s.topic, s.payload)
+				case "flush":
+					c.Flush()
+				}
+			}
+			assert.Equal(t, tt.want, broker.Published())
+		})
+	}
+}
+
+func TestClient_DisconnectFlushes(t *testing.T) {
+	c, broker, clock := newTestClient(10*time.Second, DedupConfig{})
+	c.Publish("bt/temperature/nozzle/target", "220", false)
+	clock.Advance(time.Second)
+	c.Publish("bt/temperature/nozzle/target", "0", false)
+
+	c.Disconnect()
+	clock.Advance(time.Minute)
+	assert.Equal(t, []string{
+		"bt/temperature/nozzle/target=220@0s",
+		"bt/temperature/nozzle/target=0@1s",
+	}, broker.Published())
+}
+
+func TestClient_DedupPublish(t *testing.T) {
+	c, broker, clock := newTestClient(0, DedupConfig{
+		Enabled:   true,
+		Refresh:   30 * time.Second,
+		Deadbands: []Deadband{{Pattern: "temperature/+/current", Delta: 0.5}},
+	})
+	for _, payload := range []string{"25.0", "25.2", "25.6", "25.6"} {
+		c.Publish("bt/temperature/nozzle/current", payload, false)
+		clock.Advance(20 * time.Second)
+	}
+	c.Publish("bt/temperature/nozzle/current", "25.6", false)
+
+	assert.Equal(t, []string{
+		"bt/temperature/nozzle/current=25.0@0s",
+		// Refreshed: 25.2 was within the deadband
+		"bt/temperature/nozzle/current=25.0@30s",
+		"bt/temperature/nozzle/current=25.6@40s",
+		// Refreshed, so the 25.6 at 1m20s is not published
+		"bt/temperature/nozzle/current=25.6@1m10s",
+	}, broker.Published())
+}
+
+func TestClient_DedupRefreshesIdleTopics(t *testing.T) {
+	c, broker, clock := newTestClient(10*time.Second, DedupConfig{Enabled: true, Refresh: time.Minute})
+	c.Publish("bt/printer_status", "idle", false)
+	clock.Advance(30 * time.Second)
+	c.Publish("bt/job/progress", "10", false)
+	clock.Advance(40 * time.Second)
+	c.Publish("bt/job/progress", "11", false)
+	// Neither topic is published again by the printer
+	clock.Advance(2 * time.Minute)
+
+	assert.Equal(t, []string{
+		"bt/printer_status=idle@0s",
+		"bt/job/progress=10@30s",
+		"bt/printer_status=idle@1m0s",
+		"bt/job/progress=11@1m10s",
+		"bt/printer_status=idle@2m0s",
+		"bt/job/progress=11@2m10s",
+		"bt/printer_status=idle@3m0s",
+		"bt/job/progress=11@3m10s",
+	}, broker.Published())
+
+	// Payloads published now are not refreshed
+	c.PublishNow("bt/command/result", "sent")
+	// Nor anything after Disconnect
+	c.Disconnect()
+	clock.Advance(5 * time.Minute)
+	assert.Len(t, broker.Published(), 9)
+}
+
+func TestClient_DedupRefreshWithoutInterval(t *testing.T) {
+	c, broker, clock := newTestClient(0, DedupConfig{Enabled: true})
+	c.Publish("bt/printer_status", "idle", false)
+	clock.Advance(time.Hour)
+	assert.Equal(t, []string{"bt/printer_status=idle@0s"}, broker.Published())
+}
@@ -0,0 +1,119 @@
+package mqttclient
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connClient is a broker connection that can be dropped and connected again
+type connClient struct {
+	mqtt.Client
+
+	mu         sync.Mutex
+	connected  bool
+	subscribed []string
+}
+
+func (c *connClient) IsConnected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+func (c *connClient) Connect() mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = true
+	return &mqtt.DummyToken{}
+}
+
+func (c *connClient) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected = false
+}
+
+func (c *connClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.subscribed = append(c.subscribed, topic)
+	return &mqtt.DummyToken{}
+}
+
+func (c *connClient) Subscribed() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.subscribed)
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	tests := []struct {
+		name       string
+		dialErr    error
+		wantBroker string
+		wantNew    bool
+	}{
+		{name: "new connection", wantBroker: "tcp://new:1883", wantNew: true},
+		{name: "dial fails", dialErr: errors.New("connection refused"), wantBroker: "tcp://old:1883"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &connClient{connected: true}
+			nc := &connClient{connected: true}
+			c := NewWithClient(old, "tcp://old:1883")
+			require.NoError(t, c.Subscribe("bt/command/#", func(mqtt.Client, mqtt.Message) {}))
+
+			dialing, release := make(chan struct{}), make(chan struct{})
+			c.dial = func(brokerURL, clientID, username, password, willTopic, willPayload string) (mqtt.Client, error) {
+				close(dialing)
+				<-release
+				if tt.dialErr != nil {
+					return nil, tt.dialErr
+				}
+				return nc, nil
+			}
+
+			done := make(chan error, 1)
+			go func() {
+				done <- c.Reconnect("tcp://new:1883", "id", "", "", "bt/availability", "offline")
+			}()
+			<-dialing
+
+			// The client stays usable while dialing; publishes are dropped
+			// like on any disconnected client
+			assert.False(t, old.IsConnected(), "old connection closed before dialing")
+			assert.Equal(t, "tcp://old:1883", c.Broker())
+			assert.False(t, c.IsConnected())
+			c.Publish("bt/status", "x", false)
+
+			close(release)
+			select {
+			case err := <-done:
+				if tt.dialErr != nil {
+					assert.ErrorIs(t, err, tt.dialErr)
+				} else {
+					assert.NoError(t, err)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Reconnect did not return")
+			}
+
+			assert.Equal(t, tt.wantBroker, c.Broker())
+			assert.True(t, c.IsConnected())
+			active := old
+			if tt.wantNew {
+				active = nc
+			}
+			assert.Equal(t, "bt/command/#", active.Subscribed()[len(active.Subscribed())-1],
+				"subscriptions restored on the active connection")
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package scanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/davidcollom/creality2mqtt/internal/discovery"
+	"github.com/gorilla/websocket"
+)
+
+// DefaultPort is the port of the Creality printer WebSocket
+const DefaultPort = 9999
+
+// maxHosts limits how many addresses a single scan may probe (a /16)
+const maxHosts = 1 << 16
+
+// Result describes a printer found on the network
+type Result struct {
+	IP          string `json:"ip"`
+	WSURL       string `json:"ws_url"`
+	DeviceID    string `json:"device_id"`
+	DeviceName  string `json:"device_name"`
+	DeviceModel string `json:"device_model"`
+}
+
+// Options control a scan
+type Options struct {
+	Port        int           // WebSocket port (default 9999)
+	Timeout     time.Duration // per-host connect and first-frame timeout (default 2s)
+	Concurrency int           // number of hosts probed in parallel (default 64)
+}
+
+func (o Options) withDefaults() Options {
+	if o.Port == 0 {
+		o.Port = DefaultPort
+	}
+	if o.Timeout == 0 {
+		o.Timeout = 2 * time.Second
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = 64
+	}
+	return o
+}
+
+// Scan probes every host and returns the printers found, sorted by IP
+func Scan(ctx context.Context, hosts []netip.Addr, opts Options) []Result {
+	opts = opts.withDefaults()
+
+	jobs := make(chan netip.Addr)
+	var (
+		mu      sync.Mutex
+		results []Result
+		wg      sync.WaitGroup
+	)
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for addr := range jobs {
+				u := fmt.Sprintf("ws://%s/", net.JoinHostPort(addr.String(), strconv.Itoa(opts.Port)))
+				res, err := Probe(ctx, u, opts.Timeout)
+				if err != nil {
+					log.Debug("No printer found", "url", u, "error", err)
+					continue
+				}
+				res.IP = addr.String()
+				log.Info("Found printer", "ip", res.IP, "device_id", res.DeviceID, "model", res.DeviceModel)
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, addr := range hosts {
+		select {
+		case jobs <- addr:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		a, _ := netip.ParseAddr(results[i].IP)
+		b, _ := netip.ParseAddr(results[j].IP)
+		return a.Less(b)
+	})
+	return results
+}
+
+// Probe connects to a printer WebSocket, reads the first frame and extracts the
+// device information, the same way the device-info command does.
+func Probe(ctx context.Context, wsURL string, timeout time.Duration) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = timeout
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return Result{}, err
+	}
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		return Result{}, fmt.Errorf("read first frame: %w", err)
+	}
+
+	var rawMsg map[string]any
+	if err := json.Unmarshal(data, &rawMsg); err != nil {
+		return Result{}, fmt.Errorf("not a printer frame: %w", err)
+	}
+
+	deviceID, deviceName, deviceModel := discovery.ExtractDeviceInfo(rawMsg)
+	return Result{
+		WSURL:       wsURL,
+		DeviceID:    deviceID,
+		DeviceName:  deviceName,
+		DeviceModel: deviceModel,
+	}, nil
+}
+
+// Hosts expands IPv4 prefixes into host addresses, skipping the network and
+// broadcast addresses. Prefixes larger than a /16 are rejected.
+func Hosts(prefixes []netip.Prefix) ([]netip.Addr, error) {
+	var hosts []netip.Addr
+	seen := map[netip.Addr]bool{}
+	for _, p := range prefixes {
+		p = p.Masked()
+		if !p.Addr().Is4() {
+			return nil, fmt.Errorf("%s: only IPv4 ranges can be scanned", p)
+		}
+		if 32-p.Bits() > 16 {
+			return nil, fmt.Errorf("%s: range too large, use a /16 or smaller", p)
+		}
+
+		size := 1 << (32 - p.Bits())
+		addr := p.Addr()
+		for i := 0; i < size; i++ {
+			// Skip network and broadcast addresses for ranges with room for hosts
+			if size > 2 && (i == 0 || i == size-1) {
+				addr = addr.Next()
+				continue
+			}
+			if !seen[addr] {
+				seen[addr] = true
+				hosts = append(hosts, addr)
+			}
+			addr = addr.Next()
+		}
+		if len(hosts) > maxHosts {
+			return nil, fmt.Errorf("too many hosts to scan (%d), narrow the ranges", len(hosts))
+		}
+	}
+	return hosts, nil
+}
+
+// ParsePrefixes parses CIDR ranges ("192.168.1.0/24") or single addresses
+func ParsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		if p, err := netip.ParsePrefix(c); err == nil {
+			prefixes = append(prefixes, p)
+			continue
+		}
+		addr, err := netip.ParseAddr(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q: use CIDR notation like 192.168.1.0/24", c)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// LocalSubnets returns the IPv4 subnets of the local, up, non-loopback interfaces.
+// Subnets larger than a /22 are narrowed to the /24 around the interface address.
+func LocalSubnets() ([]netip.Prefix, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			ipNet, ok := a.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			p, ok := prefixFromIPNet(ipNet)
+			if !ok {
+				continue
+			}
+			if p.Bits() < 22 {
+				log.Info("Narrowing large subnet to /24", "interface", iface.Name, "subnet", p)
+				p = netip.PrefixFrom(p.Addr(), 24)
+			}
+			prefixes = append(prefixes, p.Masked())
+		}
+	}
+	return prefixes, nil
+}
+
+func prefixFromIPNet(ipNet *net.IPNet) (netip.Prefix, bool) {
+	ip4 := ipNet.IP.To4()
+	if ip4 == nil {
+		return netip.Prefix{}, false
+	}
+	addr, _ := netip.AddrFromSlice(ip4)
+	ones, bits := ipNet.Mask.Size()
+	if bits != 32 {
+		return netip.Prefix{}, false
+	}
+	return netip.PrefixFrom(addr, ones), true
+}
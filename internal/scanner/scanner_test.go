@@ -0,0 +1,161 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// printerServer serves a WebSocket that sends one frame on connect
+func printerServer(t *testing.T, frame string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(frame))
+		// Hold the connection until the client hangs up
+		_, _, _ = conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/"
+}
+
+func TestProbe(t *testing.T) {
+	server := printerServer(t, `{"deviceId":"K1-ABC","deviceName":"Workshop K1","deviceModel":"K1 Max"}`)
+
+	res, err := Probe(context.Background(), wsURL(server), time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Result{
+		WSURL:       wsURL(server),
+		DeviceID:    "k1_abc",
+		DeviceName:  "Workshop K1",
+		DeviceModel: "K1 Max",
+	}, res)
+}
+
+func TestProbe_NotAPrinter(t *testing.T) {
+	server := printerServer(t, "hello")
+
+	_, err := Probe(context.Background(), wsURL(server), time.Second)
+	assert.ErrorContains(t, err, "not a printer frame")
+}
+
+func TestProbe_NoFrameTimesOut(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _, _ = conn.ReadMessage()
+	}))
+	t.Cleanup(server.Close)
+
+	_, err := Probe(context.Background(), wsURL(server), 100*time.Millisecond)
+	assert.ErrorContains(t, err, "read first frame")
+}
+
+func TestScan(t *testing.T) {
+	server := printerServer(t, `{"deviceId":"k1"}`)
+	_, portStr, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStr)
+	require.NoError(t, err)
+
+	// 127.0.0.2 has nothing listening on the test server port
+	hosts := []netip.Addr{netip.MustParseAddr("127.0.0.2"), netip.MustParseAddr("127.0.0.1")}
+	results := Scan(context.Background(), hosts, Options{Port: port, Timeout: 500 * time.Millisecond})
+
+	require.Len(t, results, 1)
+	assert.Equal(t, "127.0.0.1", results[0].IP)
+	assert.Equal(t, "k1", results[0].DeviceID)
+	assert.Equal(t, "ws://127.0.0.1:"+portStr+"/", results[0].WSURL)
+}
+
+func TestHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		cidrs   []string
+		want    []string
+		wantLen int
+		wantErr string
+	}{
+		{
+			name:  "skips network and broadcast",
+			cidrs: []string{"192.168.1.0/30"},
+			want:  []string{"192.168.1.1", "192.168.1.2"},
+		},
+		{
+			name:  "single address",
+			cidrs: []string{"192.168.1.50"},
+			want:  []string{"192.168.1.50"},
+		},
+		{
+			name:  "unmasked prefix and duplicates",
+			cidrs: []string{"10.0.0.7/30", "10.0.0.5"},
+			want:  []string{"10.0.0.5", "10.0.0.6"},
+		},
+		{
+			name:    "slash 24",
+			cidrs:   []string{"192.168.1.0/24"},
+			wantLen: 254,
+		},
+		{
+			name:    "too large",
+			cidrs:   []string{"10.0.0.0/8"},
+			wantErr: "range too large",
+		},
+		{
+			name:    "ipv6",
+			cidrs:   []string{"fd00::/120"},
+			wantErr: "only IPv4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefixes, err := ParsePrefixes(tt.cidrs)
+			require.NoError(t, err)
+
+			hosts, err := Hosts(prefixes)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			if tt.wantLen > 0 {
+				assert.Len(t, hosts, tt.wantLen)
+				return
+			}
+			got := make([]string, 0, len(hosts))
+			for _, h := range hosts {
+				got = append(got, h.String())
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParsePrefixes_Invalid(t *testing.T) {
+	_, err := ParsePrefixes([]string{"printer.local"})
+	assert.ErrorContains(t, err, "invalid range")
+}
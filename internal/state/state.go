@@ -0,0 +1,130 @@
+package state
+
+import (
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PrinterState is the printer's current state, merged from the full and delta
+// snapshots it sends. A delta only carries the fields that changed, so a field
+// missing from a frame keeps its last value. It is safe for concurrent use.
+type PrinterState struct {
+	mu      sync.RWMutex
+	fields  map[string]any
+	updated map[string]time.Time
+	// CFS boxes by id: every boxState frame reports a single box
+	boxes map[int]map[string]any
+	now   func() time.Time
+}
+
+// New returns an empty printer state
+func New() *PrinterState {
+	return &PrinterState{
+		fields:  map[string]any{},
+		updated: map[string]time.Time{},
+		boxes:   map[int]map[string]any{},
+		now:     time.Now,
+	}
+}
+
+// Merge merges a decoded frame into the state. Every field of the frame is
+// marked updated, whether its value changed or not.
+func (s *PrinterState) Merge(frame map[string]any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for key, v := range frame {
+		s.fields[key] = v
+		s.updated[key] = now
+	}
+	if box, ok := frame["boxState"].(map[string]any); ok {
+		if id, ok := intValue(box["id"]); ok {
+			merged := maps.Clone(s.boxes[int(id)])
+			if merged == nil {
+				merged = map[string]any{}
+			}
+			maps.Copy(merged, box)
+			s.boxes[int(id)] = merged
+		}
+	}
+}
+
+// Fields returns a copy of the merged fields. Nested values are shared and
+// must not be modified.
+func (s *PrinterState) Fields() map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.fields)
+}
+
+// Get returns the last value reported for a field
+func (s *PrinterState) Get(key string) (any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.fields[key]
+	return v, ok
+}
+
+// Float returns a numeric field; the printer sends numbers and numeric strings
+func (s *PrinterState) Float(key string) (float64, bool) {
+	v, _ := s.Get(key)
+	return FloatValue(v)
+}
+
+// Int returns a numeric field truncated to an integer
+func (s *PrinterState) Int(key string) (int64, bool) {
+	v, _ := s.Get(key)
+	return intValue(v)
+}
+
+// Updated returns when a field was last reported
+func (s *PrinterState) Updated(key string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.updated[key]
+	return t, ok
+}
+
+// UpdatedAll returns when every field was last reported
+func (s *PrinterState) UpdatedAll() map[string]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return maps.Clone(s.updated)
+}
+
+// Boxes returns the merged state of every CFS box reported, by box id
+func (s *PrinterState) Boxes() map[int]map[string]any {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[int]map[string]any, len(s.boxes))
+	for id, box := range s.boxes {
+		out[id] = maps.Clone(box)
+	}
+	return out
+}
+
+// FloatValue converts a frame value to float64; the printer sends numbers
+// as well as numeric strings ("219.900000")
+func FloatValue(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func intValue(v any) (int64, bool) {
+	f, ok := FloatValue(v)
+	return int64(f), ok
+}
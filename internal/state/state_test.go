@@ -0,0 +1,101 @@
+package state
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrinterState_Merge(t *testing.T) {
+	now := time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)
+	s := New()
+	s.now = func() time.Time { return now }
+
+	// Full snapshot, then a delta with the changed fields only
+	s.Merge(map[string]any{"nozzleTemp": 25.0, "targetNozzleTemp": 0.0, "state": 0.0})
+	now = now.Add(time.Second)
+	s.Merge(map[string]any{"nozzleTemp": "180.500000", "state": 1.0})
+
+	assert.Equal(t, map[string]any{"nozzleTemp": "180.500000", "targetNozzleTemp": 0.0, "state": 1.0}, s.Fields())
+
+	v, ok := s.Float("nozzleTemp")
+	require.True(t, ok)
+	assert.Equal(t, 180.5, v)
+	n, ok := s.Int("state")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), n)
+	_, ok = s.Float("bedTemp0")
+	assert.False(t, ok)
+
+	updated, ok := s.Updated("nozzleTemp")
+	require.True(t, ok)
+	assert.Equal(t, now, updated)
+	updated, _ = s.Updated("targetNozzleTemp")
+	assert.Equal(t, now.Add(-time.Second), updated)
+	assert.Len(t, s.UpdatedAll(), 3)
+	_, ok = s.Updated("bedTemp0")
+	assert.False(t, ok)
+}
+
+func TestPrinterState_Boxes(t *testing.T) {
+	s := New()
+	s.Merge(map[string]any{"boxState": map[string]any{"id": 1.0, "humidity": 30.0, "temp": 24.0}})
+	s.Merge(map[string]any{"boxState": map[string]any{"id": 2.0, "humidity": 45.0}})
+	s.Merge(map[string]any{"boxState": map[string]any{"id": 1.0, "humidity": 31.0}})
+	// Not a box
+	s.Merge(map[string]any{"boxState": "none"})
+
+	assert.Equal(t, map[int]map[string]any{
+		1: {"id": 1.0, "humidity": 31.0, "temp": 24.0},
+		2: {"id": 2.0, "humidity": 45.0},
+	}, s.Boxes())
+
+	// The copies are the caller's
+	s.Boxes()[1]["humidity"] = 99.0
+	s.Fields()["nozzleTemp"] = 1.0
+	assert.Equal(t, 31.0, s.Boxes()[1]["humidity"])
+	_, ok := s.Get("nozzleTemp")
+	assert.False(t, ok)
+}
+
+func TestPrinterState_Concurrent(t *testing.T) {
+	s := New()
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			s.Merge(map[string]any{"layer": float64(i), "boxState": map[string]any{"id": 1.0, "temp": float64(i)}})
+			_ = s.Fields()
+			_ = s.Boxes()
+		})
+	}
+	wg.Wait()
+	_, ok := s.Int("layer")
+	assert.True(t, ok)
+}
+
+func TestFloatValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     any
+		want   float64
+		wantOK bool
+	}{
+		{"number", 219.9, 219.9, true},
+		{"numeric string", "219.900000", 219.9, true},
+		{"int", 3, 3, true},
+		{"int32", int32(4), 4, true},
+		{"int64", int64(5), 5, true},
+		{"text", "idle", 0, false},
+		{"missing", nil, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := FloatValue(tt.in)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
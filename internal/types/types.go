@@ -43,6 +43,67 @@ func (tb *TopicBuilder) LightCommand() string {
 	return fmt.Sprintf("%s/light_sw/set", tb.BaseTopic)
 }
 
+// CommandSet returns the generic printer "set" command topic
+func (tb *TopicBuilder) CommandSet() string {
+	return fmt.Sprintf("%s/command/set", tb.BaseTopic)
+}
+
+// GcodeSend returns the topic G-code lines are sent to the printer on
+func (tb *TopicBuilder) GcodeSend() string {
+	return fmt.Sprintf("%s/gcode/send", tb.BaseTopic)
+}
+
+// GcodeResponse returns the topic the outcome of sent G-code, with the frames
+// the printer sent after it, is published on
+func (tb *TopicBuilder) GcodeResponse() string {
+	return fmt.Sprintf("%s/gcode/response", tb.BaseTopic)
+}
+
+// CommandError returns the topic rejected commands are reported on
+func (tb *TopicBuilder) CommandError() string {
+	return fmt.Sprintf("%s/command/error", tb.BaseTopic)
+}
+
+// CommandResult returns the topic the outcome of every command is published on
+func (tb *TopicBuilder) CommandResult() string {
+	return fmt.Sprintf("%s/command/result", tb.BaseTopic)
+}
+
+// CommandQueued returns the topic the number of commands waiting for the printer is published on
+func (tb *TopicBuilder) CommandQueued() string {
+	return fmt.Sprintf("%s/command/queued", tb.BaseTopic)
+}
+
+// JobCommand returns the command topic of a print job action ("pause", "resume" or "cancel")
+func (tb *TopicBuilder) JobCommand(action string) string {
+	return fmt.Sprintf("%s/command/%s", tb.BaseTopic, action)
+}
+
+// NozzleTargetCommand returns the nozzle target temperature command topic
+func (tb *TopicBuilder) NozzleTargetCommand() string {
+	return fmt.Sprintf("%s/temperature/nozzle/target/set", tb.BaseTopic)
+}
+
+// BedTargetCommand returns the bed target temperature command topic
+func (tb *TopicBuilder) BedTargetCommand() string {
+	return fmt.Sprintf("%s/temperature/bed0/target/set", tb.BaseTopic)
+}
+
+// FanPercentage returns the speed topic (0-100) of a fan ("model", "auxiliary" or "case")
+func (tb *TopicBuilder) FanPercentage(fan string) string {
+	return fmt.Sprintf("%s/%s_fan_pct", tb.BaseTopic, fan)
+}
+
+// FanCommand returns the command topic of a fan, accepting ON/OFF or a percentage
+func (tb *TopicBuilder) FanCommand(fan string) string {
+	return fmt.Sprintf("%s/fan/%s/set", tb.BaseTopic, fan)
+}
+
+// TuningCommand returns the command topic of a print tuning setting published on <base>/<subtopic>
+func (tb *TopicBuilder) TuningCommand(subtopic string) string {
+	return fmt.Sprintf("%s/%s/set", tb.BaseTopic, subtopic)
+}
+
 // CameraStreamURL returns the camera stream URL topic
 func (tb *TopicBuilder) CameraStreamURL() string {
 	return fmt.Sprintf("%s/camera_stream_url", tb.BaseTopic)
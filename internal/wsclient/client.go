@@ -2,6 +2,9 @@ package wsclient
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"slices"
 	"sync"
 	"time"
 
@@ -11,23 +14,97 @@ import (
 
 type HandlerFunc func([]byte)
 
+// MatchFunc reports whether a decoded frame is the reply a caller waits for
+type MatchFunc func(frame map[string]any) bool
+
+var (
+	// ErrTimeout is returned when no reply arrives before the deadline
+	ErrTimeout = errors.New("timed out waiting for reply")
+	// ErrCanceled is returned by Reply.Wait once the reply was cancelled
+	ErrCanceled = errors.New("reply cancelled")
+	// ErrQueued is returned by Send when the message waits for the connection
+	ErrQueued = errors.New("queued until reconnected")
+	// ErrExpired is reported for a queued message whose TTL passed before it was written
+	ErrExpired = errors.New("expired before the connection was back")
+	// ErrQueueFull is returned by Send when MaxQueued messages are already waiting
+	ErrQueueFull = errors.New("send queue full")
+)
+
+const (
+	// writeTimeout bounds a single write to the connection
+	writeTimeout = 10 * time.Second
+	// MaxQueued is the most messages held while disconnected
+	MaxQueued = 100
+	// defaultReplayDelay is how long the queue waits for the first frame of a
+	// new connection before it is replayed anyway
+	defaultReplayDelay = time.Second
+)
+
+// writeRequest is a message for the write pump and the channel its result is sent on
+type writeRequest struct {
+	data []byte
+	done chan error
+}
+
+// Outgoing is a message sent with Send
+type Outgoing struct {
+	Data []byte
+	// TTL is how long the message may wait for a connection. Without one,
+	// Send fails while disconnected like SendMessage.
+	TTL time.Duration
+	// BeforeWrite is called right before the message is written, e.g. to
+	// register an Expect for its reply. Optional.
+	BeforeWrite func()
+	// Done is called once a queued message was written (nil), failed to
+	// write or expired (ErrExpired). It is only called when Send returned
+	// ErrQueued. Optional.
+	Done func(err error)
+}
+
+// queuedMessage is an Outgoing message waiting for the connection
+type queuedMessage struct {
+	out     Outgoing
+	timer   *time.Timer
+	expires time.Time
+}
+
 type Client struct {
-	url        string
-	handler    HandlerFunc
-	dialer     *websocket.Dialer
-	retryDelay time.Duration
-	conn       *websocket.Conn
-	connMu     sync.RWMutex
+	url          string
+	handler      HandlerFunc
+	onConnect    func()
+	onDisconnect func()
+	dialer       *websocket.Dialer
+	retryDelay   time.Duration
+	conn         *websocket.Conn
+	// outbox feeds the write pump of the current connection, closed is
+	// closed when the connection ends
+	outbox chan writeRequest
+	closed chan struct{}
+	connMu sync.RWMutex
+
+	// Replies waited for, in registration order
+	repliesMu sync.Mutex
+	replies   []*Reply
+
+	// Messages sent while disconnected, replayed in order by flushQueue
+	queueMu  sync.Mutex
+	queue    []*queuedMessage
+	flushing bool
+	onQueue  func(depth int)
+	// replayDelay bounds the wait for the first frame before the queue is replayed
+	replayDelay time.Duration
 }
 
 func New(url string, handler HandlerFunc) *Client {
-	dialer := websocket.DefaultDialer
+	// Copy the default dialer rather than modifying the shared instance
+	dialer := *websocket.DefaultDialer
 	dialer.HandshakeTimeout = 10 * time.Second
 	return &Client{
-		url:        url,
-		handler:    handler,
-		dialer:     dialer,
-		retryDelay: 5 * time.Second,
+		url:         url,
+		handler:     handler,
+		dialer:      &dialer,
+		retryDelay:  5 * time.Second,
+		replayDelay: defaultReplayDelay,
 	}
 }
 
@@ -58,35 +135,57 @@ func (c *Client) runOnce(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	closed := make(chan struct{})
 	defer func() {
 		c.connMu.Lock()
-		c.conn = nil
+		c.conn, c.outbox, c.closed = nil, nil, nil
+		close(closed)
 		c.connMu.Unlock()
 
 		if err := conn.Close(); err != nil {
 			log.Warn("Failed to close WebSocket connection", "error", err)
 		}
+		if c.onDisconnect != nil {
+			c.onDisconnect()
+		}
 	}()
 
-	// Store connection for sending messages
+	// Store connection for sending messages; the write pump is its only writer
+	outbox := make(chan writeRequest)
 	c.connMu.Lock()
-	c.conn = conn
+	c.conn, c.outbox, c.closed = conn, outbox, closed
 	c.connMu.Unlock()
+	go writePump(conn, outbox, closed)
 
 	log.Info("WebSocket connected", "url", c.url)
+	if c.onConnect != nil {
+		c.onConnect()
+	}
+
+	// The queue is replayed once the first frame was handled: the printer
+	// reports its full state on connect, which must not be taken for the reply
+	// to a replayed message
+	replay := time.AfterFunc(c.replayDelay, c.startFlush)
+	defer replay.Stop()
 
 	// Channel to signal read errors
 	errCh := make(chan error, 1)
 
 	// Start reading in a goroutine
 	go func() {
+		first := true
 		for {
 			_, data, err := conn.ReadMessage()
 			if err != nil {
 				errCh <- err
 				return
 			}
+			c.deliver(data)
 			c.handler(data)
+			if first && replay.Stop() {
+				c.startFlush()
+			}
+			first = false
 		}
 	}()
 
@@ -95,28 +194,292 @@ func (c *Client) runOnce(ctx context.Context) error {
 	case err := <-errCh:
 		return err
 	case <-ctx.Done():
-		// Close connection to unblock the read goroutine
+		// Close connection to unblock the read goroutine, and wait for it so
+		// that no frame is still being handled once Run returned
 		if err := conn.Close(); err != nil {
 			log.Warn("Failed to close WebSocket connection", "error", err)
 		}
+		<-errCh
 		return ctx.Err()
 	}
 }
 
-// SendMessage sends a message to the WebSocket connection
-// Returns error if not connected
+// writePump writes the queued messages to the connection until it is closed
+func writePump(conn *websocket.Conn, outbox <-chan writeRequest, closed <-chan struct{}) {
+	for {
+		select {
+		case req := <-outbox:
+			if err := conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+				req.done <- err
+				continue
+			}
+			req.done <- conn.WriteMessage(websocket.TextMessage, req.data)
+		case <-closed:
+			return
+		}
+	}
+}
+
+// SendMessage sends a message to the WebSocket connection through the write pump
+// and waits until it is written. Returns error if not connected
 func (c *Client) SendMessage(data []byte) error {
 	c.connMu.RLock()
-	defer c.connMu.RUnlock()
+	outbox, closed := c.outbox, c.closed
+	c.connMu.RUnlock()
 
-	if c.conn == nil {
+	if outbox == nil {
 		return websocket.ErrCloseSent
 	}
 
-	return c.conn.WriteMessage(websocket.TextMessage, data)
+	req := writeRequest{data: data, done: make(chan error, 1)}
+	select {
+	case outbox <- req:
+	case <-closed:
+		return websocket.ErrCloseSent
+	}
+	// The pump answers every request it took
+	return <-req.done
+}
+
+// Send writes a message like SendMessage. While disconnected, a message with a
+// TTL is queued instead: Send returns ErrQueued and the message is written
+// after the queued ones once the connection is back, or dropped with
+// ErrExpired when its TTL passes first. Either way out.Done reports it.
+func (c *Client) Send(out Outgoing) error {
+	c.queueMu.Lock()
+	// Messages queue behind the ones still waiting, to keep their order
+	if out.TTL <= 0 || (len(c.queue) == 0 && !c.flushing && c.Connected()) {
+		c.queueMu.Unlock()
+		return c.write(out)
+	}
+	if len(c.queue) >= MaxQueued {
+		c.queueMu.Unlock()
+		return ErrQueueFull
+	}
+	m := &queuedMessage{out: out, expires: time.Now().Add(out.TTL)}
+	m.timer = time.AfterFunc(out.TTL, func() { c.expire(m) })
+	c.queue = append(c.queue, m)
+	depth := len(c.queue)
+	// Replayed by the connection's first frame (startFlush), even when the
+	// connection is already back: the frame may still be on its way
+	c.queueMu.Unlock()
+
+	c.queueChanged(depth)
+	return ErrQueued
+}
+
+// write calls the message's BeforeWrite hook and sends it
+func (c *Client) write(out Outgoing) error {
+	if out.BeforeWrite != nil {
+		out.BeforeWrite()
+	}
+	return c.SendMessage(out.Data)
+}
+
+// startFlush replays the queued messages on a new connection
+func (c *Client) startFlush() {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	if c.flushing || len(c.queue) == 0 {
+		return
+	}
+	log.Info("Replaying queued WebSocket messages", "url", c.url, "count", len(c.queue))
+	c.flushing = true
+	go c.flushQueue()
+}
+
+// flushQueue writes the queued messages in order until the queue is empty or
+// the connection is lost. A message that fails to write is not retried.
+func (c *Client) flushQueue() {
+	for {
+		c.queueMu.Lock()
+		if len(c.queue) == 0 || !c.Connected() {
+			c.flushing = false
+			c.queueMu.Unlock()
+			return
+		}
+		m := c.queue[0]
+		c.queue = c.queue[1:]
+		depth := len(c.queue)
+		// expire skips messages no longer in the queue
+		m.timer.Stop()
+		c.queueMu.Unlock()
+
+		c.queueChanged(depth)
+		err := c.write(m.out)
+		if err != nil {
+			log.Warn("Failed to write queued WebSocket message", "url", c.url, "error", err)
+		}
+		if m.out.Done != nil {
+			m.out.Done(err)
+		}
+	}
+}
+
+// expire drops a queued message once its TTL has passed
+func (c *Client) expire(m *queuedMessage) {
+	c.queueMu.Lock()
+	i := slices.Index(c.queue, m)
+	if i < 0 {
+		c.queueMu.Unlock()
+		return
+	}
+	c.queue = slices.Delete(c.queue, i, i+1)
+	depth := len(c.queue)
+	c.queueMu.Unlock()
+
+	c.queueChanged(depth)
+	if m.out.Done != nil {
+		m.out.Done(ErrExpired)
+	}
+}
+
+func (c *Client) queueChanged(depth int) {
+	if c.onQueue != nil {
+		c.onQueue(depth)
+	}
+}
+
+// TakeQueue removes the messages waiting for the connection and returns them
+// in order, each with the TTL it has left, e.g. to send them on the client
+// replacing this one. Messages whose TTL has passed are expired instead.
+func (c *Client) TakeQueue() []Outgoing {
+	c.queueMu.Lock()
+	queue := c.queue
+	c.queue = nil
+	c.queueMu.Unlock()
+	if len(queue) == 0 {
+		return nil
+	}
+	c.queueChanged(0)
+
+	var out []Outgoing
+	for _, m := range queue {
+		// expire skips messages no longer in the queue
+		m.timer.Stop()
+		ttl := time.Until(m.expires)
+		if ttl <= 0 {
+			if m.out.Done != nil {
+				m.out.Done(ErrExpired)
+			}
+			continue
+		}
+		m.out.TTL = ttl
+		out = append(out, m.out)
+	}
+	return out
+}
+
+// QueueDepth returns the number of messages waiting for the connection
+func (c *Client) QueueDepth() int {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+	return len(c.queue)
+}
+
+// Reply is a frame waited for with Expect
+type Reply struct {
+	c        *Client
+	match    MatchFunc
+	frame    chan map[string]any
+	canceled chan struct{}
+	once     sync.Once
+}
+
+// Expect registers a wait for the next frame accepted by match. Register it
+// before sending the request so a fast reply is not missed.
+func (c *Client) Expect(match MatchFunc) *Reply {
+	r := &Reply{
+		c:        c,
+		match:    match,
+		frame:    make(chan map[string]any, 1),
+		canceled: make(chan struct{}),
+	}
+	c.repliesMu.Lock()
+	c.replies = append(c.replies, r)
+	c.repliesMu.Unlock()
+	return r
+}
+
+// Wait returns the matched frame, which is shared with other replies and must
+// not be modified. It returns ErrTimeout when the context
+// deadline passes first and ErrCanceled once the reply was cancelled.
+func (r *Reply) Wait(ctx context.Context) (map[string]any, error) {
+	select {
+	case frame := <-r.frame:
+		return frame, nil
+	case <-r.canceled:
+		return nil, ErrCanceled
+	case <-ctx.Done():
+		r.Cancel()
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel stops waiting for the reply
+func (r *Reply) Cancel() {
+	r.once.Do(func() {
+		r.c.removeReply(r)
+		close(r.canceled)
+	})
+}
+
+func (c *Client) removeReply(r *Reply) {
+	c.repliesMu.Lock()
+	defer c.repliesMu.Unlock()
+	c.replies = slices.DeleteFunc(c.replies, func(other *Reply) bool { return other == r })
+}
+
+// deliver hands a frame to every reply it matches. Frames that are not JSON
+// objects match nothing.
+func (c *Client) deliver(data []byte) {
+	c.repliesMu.Lock()
+	defer c.repliesMu.Unlock()
+	if len(c.replies) == 0 {
+		return
+	}
+	var frame map[string]any
+	if err := json.Unmarshal(data, &frame); err != nil {
+		return
+	}
+	c.replies = slices.DeleteFunc(c.replies, func(r *Reply) bool {
+		if !r.match(frame) {
+			return false
+		}
+		r.frame <- frame
+		return true
+	})
+}
+
+// Connected reports whether the WebSocket connection is currently established
+func (c *Client) Connected() bool {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.conn != nil
 }
 
 // SetHandler updates the message handler function
 func (c *Client) SetHandler(handler HandlerFunc) {
 	c.handler = handler
 }
+
+// SetQueueHandler sets a function called with the queue depth every time a
+// message is queued or leaves the queue. It must be set before Run.
+func (c *Client) SetQueueHandler(fn func(depth int)) {
+	c.onQueue = fn
+}
+
+// SetConnectHandler sets a function called every time the connection is established.
+// It must be set before Run.
+func (c *Client) SetConnectHandler(fn func()) {
+	c.onConnect = fn
+}
+
+// SetDisconnectHandler sets a function called every time an established
+// connection ends, once Connected reports false. It must be set before Run.
+func (c *Client) SetDisconnectHandler(fn func()) {
+	c.onDisconnect = fn
+}
@@ -2,14 +2,18 @@ package wsclient
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
@@ -281,3 +285,359 @@ func TestClient_runOnce(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Connected(t *testing.T) {
+	connected := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("hello")))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) {
+		select {
+		case <-connected:
+		default:
+			close(connected)
+		}
+	})
+	require.False(t, client.Connected())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = client.runOnce(ctx)
+	}()
+
+	<-connected
+	require.True(t, client.Connected())
+	cancel()
+	<-done
+	require.False(t, client.Connected())
+}
+
+func TestClient_DisconnectHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		// Close right away, as a printer going down would
+		_ = conn.Close()
+	}))
+	defer server.Close()
+
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) {})
+	var events []string
+	client.SetConnectHandler(func() { events = append(events, "connect") })
+	client.SetDisconnectHandler(func() {
+		assert.False(t, client.Connected())
+		events = append(events, "disconnect")
+	})
+
+	require.Error(t, client.runOnce(context.Background()))
+	assert.Equal(t, []string{"connect", "disconnect"}, events)
+
+	// A failed dial was never connected, so it is not reported
+	server.Close()
+	require.Error(t, client.runOnce(context.Background()))
+	assert.Equal(t, []string{"connect", "disconnect"}, events)
+}
+
+// echoServer answers every message it receives with the frames reply returns
+func echoServer(t *testing.T, reply func(msg map[string]any) []string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg map[string]any
+			if err := json.Unmarshal(data, &msg); err != nil {
+				continue
+			}
+			for _, frame := range reply(msg) {
+				if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runClient runs the client until the test ends and waits for its connection
+func runClient(t *testing.T, client *Client) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = client.runOnce(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	require.Eventually(t, client.Connected, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestClient_Expect(t *testing.T) {
+	server := echoServer(t, func(msg map[string]any) []string {
+		params, _ := msg["params"].(map[string]any)
+		if params["lightSw"] != nil {
+			// An unrelated frame arrives before the reply
+			return []string{`{"nozzleTemp":"210.0"}`, `{"lightSw":1}`}
+		}
+		return nil
+	})
+
+	var handled atomic.Int32
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) { handled.Add(1) })
+	runClient(t, client)
+
+	hasField := func(field string) MatchFunc {
+		return func(frame map[string]any) bool {
+			_, ok := frame[field]
+			return ok
+		}
+	}
+	tests := []struct {
+		name    string
+		request string
+		field   string
+		timeout time.Duration
+		want    map[string]any
+		wantErr error
+	}{
+		{"reply", `{"method":"set","params":{"lightSw":1}}`, "lightSw", time.Second, map[string]any{"lightSw": float64(1)}, nil},
+		{"no reply", `{"method":"set","params":{"pause":1}}`, "pause", 50 * time.Millisecond, nil, ErrTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			reply := client.Expect(hasField(tt.field))
+			require.NoError(t, client.SendMessage([]byte(tt.request)))
+			got, err := reply.Wait(ctx)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Every frame still reaches the handler, and answered or expired replies are dropped
+	assert.Eventually(t, func() bool { return handled.Load() == 2 }, time.Second, 5*time.Millisecond)
+	client.repliesMu.Lock()
+	assert.Empty(t, client.replies)
+	client.repliesMu.Unlock()
+}
+
+func TestReply_Cancel(t *testing.T) {
+	client := New("ws://127.0.0.1:1/", func([]byte) {})
+	reply := client.Expect(func(map[string]any) bool { return true })
+	reply.Cancel()
+	reply.Cancel()
+
+	_, err := reply.Wait(context.Background())
+	assert.ErrorIs(t, err, ErrCanceled)
+	client.deliver([]byte(`{"lightSw":1}`))
+	assert.Empty(t, client.replies)
+}
+
+func TestClient_ConcurrentSendMessage(t *testing.T) {
+	var mu sync.Mutex
+	received := 0
+	server := echoServer(t, func(map[string]any) []string {
+		mu.Lock()
+		defer mu.Unlock()
+		received++
+		return nil
+	})
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) {})
+	runClient(t, client)
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Go(func() {
+			assert.NoError(t, client.SendMessage(fmt.Appendf(nil, `{"method":"set","params":{"n":%d}}`, i)))
+		})
+	}
+	wg.Wait()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return received == 20
+	}, 2*time.Second, 5*time.Millisecond)
+}
+
+func TestClient_SendQueuesWhileDisconnected(t *testing.T) {
+	received := make(chan string, 10)
+	server := echoServer(t, func(msg map[string]any) []string {
+		received <- fmt.Sprint(msg["n"])
+		return nil
+	})
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) {})
+	// The server sends no frame on connect to wait for
+	client.replayDelay = 10 * time.Millisecond
+	var depths []int
+	var depthMu sync.Mutex
+	client.SetQueueHandler(func(depth int) {
+		depthMu.Lock()
+		defer depthMu.Unlock()
+		depths = append(depths, depth)
+	})
+
+	// Without a TTL a message is not queued
+	assert.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{"n":0}`)}), websocket.ErrCloseSent)
+
+	done := make(chan error, 3)
+	var written atomic.Int32
+	for i := 1; i <= 3; i++ {
+		err := client.Send(Outgoing{
+			Data:        fmt.Appendf(nil, `{"n":%d}`, i),
+			TTL:         time.Minute,
+			BeforeWrite: func() { written.Add(1) },
+			Done:        func(err error) { done <- err },
+		})
+		require.ErrorIs(t, err, ErrQueued)
+	}
+	assert.Equal(t, 3, client.QueueDepth())
+	assert.Zero(t, written.Load())
+
+	runClient(t, client)
+	for i := 1; i <= 3; i++ {
+		select {
+		case err := <-done:
+			assert.NoError(t, err)
+		case <-time.After(2 * time.Second):
+			t.Fatal("queued message was not written")
+		}
+	}
+	// Replayed in order
+	for _, want := range []string{"1", "2", "3"} {
+		assert.Equal(t, want, <-received)
+	}
+	assert.Equal(t, int32(3), written.Load())
+	assert.Zero(t, client.QueueDepth())
+
+	depthMu.Lock()
+	defer depthMu.Unlock()
+	assert.Equal(t, []int{1, 2, 3, 2, 1, 0}, depths)
+
+	// Connected, a message is written straight away
+	require.NoError(t, client.Send(Outgoing{Data: []byte(`{"n":4}`), TTL: time.Minute, Done: func(err error) { t.Error("Done called for a written message") }}))
+	assert.Equal(t, "4", <-received)
+}
+
+func TestClient_SendWaitsForFirstFrame(t *testing.T) {
+	received := make(chan string, 10)
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		conns <- conn
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client := New("ws"+strings.TrimPrefix(server.URL, "http"), func([]byte) {})
+	// Only the first frame starts the replay
+	client.replayDelay = time.Minute
+
+	require.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{"n":1}`), TTL: time.Minute}), ErrQueued)
+	runClient(t, client)
+	conn := <-conns
+
+	// Reconnected, but the printer has not reported its state yet
+	require.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{"n":2}`), TTL: time.Minute}), ErrQueued)
+	select {
+	case msg := <-received:
+		t.Fatalf("%s replayed before the first frame", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+	assert.Equal(t, 2, client.QueueDepth())
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"state":0}`)))
+	for _, want := range []string{`{"n":1}`, `{"n":2}`} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, want, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatal("queued message was not replayed")
+		}
+	}
+}
+
+func TestClient_SendExpires(t *testing.T) {
+	client := New("ws://127.0.0.1:1/", func([]byte) {})
+
+	done := make(chan error, 1)
+	require.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{}`), TTL: 20 * time.Millisecond, Done: func(err error) { done <- err }}), ErrQueued)
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, ErrExpired)
+	case <-time.After(2 * time.Second):
+		t.Fatal("queued message did not expire")
+	}
+	assert.Zero(t, client.QueueDepth())
+
+	for range MaxQueued {
+		require.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{}`), TTL: time.Minute}), ErrQueued)
+	}
+	assert.ErrorIs(t, client.Send(Outgoing{Data: []byte(`{}`), TTL: time.Minute}), ErrQueueFull)
+}
+
+func TestClient_TakeQueue(t *testing.T) {
+	client := New("ws://127.0.0.1:1/", func([]byte) {})
+	var depths []int
+	client.SetQueueHandler(func(depth int) { depths = append(depths, depth) })
+	assert.Empty(t, client.TakeQueue())
+
+	done := make(chan error, 2)
+	for i := 1; i <= 2; i++ {
+		err := client.Send(Outgoing{Data: fmt.Appendf(nil, `{"n":%d}`, i), TTL: time.Minute, Done: func(err error) { done <- err }})
+		require.ErrorIs(t, err, ErrQueued)
+	}
+
+	queued := client.TakeQueue()
+	require.Len(t, queued, 2)
+	// In order, with the TTL they have left
+	assert.Equal(t, `{"n":1}`, string(queued[0].Data))
+	assert.Equal(t, `{"n":2}`, string(queued[1].Data))
+	assert.Greater(t, queued[0].TTL, 50*time.Second)
+	assert.LessOrEqual(t, queued[0].TTL, time.Minute)
+	assert.NotNil(t, queued[0].Done)
+	assert.Zero(t, client.QueueDepth())
+	assert.Equal(t, []int{1, 2, 0}, depths)
+	// Taken messages are settled by their new client, not this one
+	assert.Empty(t, done)
+}